@@ -11,10 +11,21 @@ import (
 	"github.com/subutai-io/agent/agent/container"
 	"github.com/subutai-io/agent/agent/discovery"
 	"github.com/subutai-io/agent/agent/monitor"
-	"github.com/subutai-io/agent/config"
 	"github.com/subutai-io/agent/cli"
 	"github.com/subutai-io/agent/agent/console"
 	"github.com/subutai-io/agent/agent/vars"
+	"github.com/subutai-io/agent/agent/executer"
+	"github.com/subutai-io/agent/agent/util"
+	"github.com/subutai-io/agent/log"
+	"github.com/subutai-io/agent/agent/local"
+	"github.com/subutai-io/agent/lib/gpg"
+	"net"
+	"errors"
+	"crypto/tls"
+	"crypto/x509"
+	stdlog "log"
+	"github.com/subutai-io/agent/lib/audit"
+	"github.com/subutai-io/agent/config"
 )
 
 var (
@@ -30,8 +41,20 @@ func Start() {
 
 	initAgent()
 
-	//serve REST endpoints used by Console
-	setupHttpServer()
+	//serve endpoints authenticated by Console client certificate
+	setupSecureHttpServer()
+
+	//serve REST endpoints used by Consoles which do not call daemon API over TLS yet
+	if config.Management.PlainApi {
+		log.Warn("Option plainApi is deprecated and will be removed, plain HTTP API trusts requests by source address, use daemon API over TLS")
+		setupHttpServer()
+	}
+
+	//serve local API used by CLI
+	go local.Serve(func() { consol.SendHeartBeat(true) }, consol.PendingResponses, func() string {
+		host, _ := console.ActiveHost()
+		return host.Address
+	})
 
 	//search for peer or enable secondary RHs to find it
 	go discovery.Monitor()
@@ -39,6 +62,12 @@ func Start() {
 	//restart containers that got stopped not by user
 	go container.StateRestore()
 
+	//check health of services inside containers
+	go container.HealthChecks()
+
+	//fail over between configured Management hosts
+	go consol.MonitorHosts()
+
 	//wait till Console is loaded
 	for !consol.IsReady() {
 		time.Sleep(time.Second * 3)
@@ -50,32 +79,127 @@ func Start() {
 	}
 
 	//below routines should start only when registration with Console is established
+	go consol.RestoreJournal()
+
 	go monitor.Collect()
 
 	//start sending periodic heartbeats to Console
 	go consol.Heartbeats()
 
+	//push container lifecycle events to Console
+	go consol.Events()
+
+	//take scheduled snapshots in own loop, since snapshotting may take long
+	go func() {
+		for {
+			cli.TakeScheduledSnapshots()
+			time.Sleep(30 * time.Second)
+		}
+	}()
+
 	//todo refactor below
 	for {
 		cli.CheckSshTunnels()
+		//drop rotated keys once grace period is over
+		gpg.PruneRetiredKeys()
 		time.Sleep(30 * time.Second)
 	}
 }
 
 //HTTP server >>>>
-var mux map[string]func(http.ResponseWriter, *http.Request)
+
+//requests per second allowed for each Console client and route, and burst size
+const (
+	requestRate  = 5
+	requestBurst = 20
+)
+
+//route of daemon API
+type route struct {
+	methods []string
+	handler func(http.ResponseWriter, *http.Request)
+	//long-living connections are not rate limited
+	unlimited bool
+}
+
+func (r route) allows(method string) bool {
+	for _, m := range r.methods {
+		if m == method {
+			return true
+		}
+	}
+
+	return false
+}
+
+var mux map[string]route
+
+var secureMux map[string]route
+
+var limiter = util.NewRateLimiter(requestRate, requestBurst)
 
 type myHandler struct{}
 
 func (*myHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if h, ok := mux[r.URL.String()]; ok {
-		h(w, r)
+	clientIp, _, _ := net.SplitHostPort(r.RemoteAddr)
+
+	//plain API is not authenticated, so only Console address is trusted, and local clients for heartbeat
+	if clientIp != config.ManagementIP() && !(r.URL.Path == "/heartbeat" && net.ParseIP(clientIp).IsLoopback()) {
+		allowed, _ := limiter.Allow(clientIp)
+		rejectRequest(w, r, http.StatusForbidden, "Unknown client", allowed)
+		return
+	}
+
+	serveRoute(mux, w, r)
+}
+
+type mySecureHandler struct{}
+
+func (*mySecureHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serveRoute(secureMux, w, r)
+}
+
+//passes request to handler of its route if method is allowed and client is within rate limit
+func serveRoute(routes map[string]route, w http.ResponseWriter, r *http.Request) {
+	clientIp, _, _ := net.SplitHostPort(r.RemoteAddr)
+
+	rt, ok := routes[r.URL.Path]
+	if !ok {
+		rejectRequest(w, r, http.StatusNotFound, "Unknown route", true)
 		return
 	}
 
-	w.WriteHeader(http.StatusForbidden)
+	if !rt.allows(r.Method) {
+		w.Header().Set("Allow", strings.Join(rt.methods, ", "))
+		rejectRequest(w, r, http.StatusMethodNotAllowed, "Method not allowed", true)
+		return
+	}
+
+	if !rt.unlimited {
+		if allowed, first := limiter.Allow(clientIp + " " + r.URL.Path); !allowed {
+			//throttled client is audited once per burst
+			rejectRequest(w, r, http.StatusTooManyRequests, "Rate limit exceeded", first)
+			return
+		}
+	}
+
+	rt.handler(w, r)
+}
+
+//responds with passed status and reports rejected request to log and, if audited is set, to audit log
+func rejectRequest(w http.ResponseWriter, r *http.Request, status int, reason string, audited bool) {
+	clientIp, _, _ := net.SplitHostPort(r.RemoteAddr)
+
+	log.Warn("Rejected " + r.Method + " " + r.URL.Path + " from " + clientIp + ": " + reason)
+
+	if audited {
+		audit.Start("remote:"+clientIp, "api", []string{r.Method, r.URL.Path}, "")(errors.New("Rejected: " + reason))
+	}
+
+	w.WriteHeader(status)
 }
 
+//serves part of daemon API over plain HTTP to Consoles which are not migrated to TLS one yet
 func setupHttpServer() {
 	srv := &http.Server{
 		Addr:              ":" + vars.DAEMON_PORT,
@@ -84,39 +208,83 @@ func setupHttpServer() {
 		WriteTimeout:      30 * time.Second,
 		Handler:           &myHandler{},
 	}
-	mux = make(map[string]func(http.ResponseWriter, *http.Request))
-	mux["/trigger"] = triggerHandler
-	mux["/ping"] = pingHandler
-	mux["/heartbeat"] = heartbeatHandler
-	go srv.ListenAndServe()
+	mux = make(map[string]route)
+	mux["/trigger"] = route{methods: []string{http.MethodPost}, handler: triggerHandler}
+	mux["/ping"] = route{methods: []string{http.MethodGet}, handler: pingHandler}
+	mux["/heartbeat"] = route{methods: []string{http.MethodGet}, handler: heartbeatHandler}
+	go func() {
+		log.Check(log.WarnLevel, "Serving plain daemon API", srv.ListenAndServe())
+	}()
 }
 
-func pingHandler(rw http.ResponseWriter, request *http.Request) {
-	if request.Method == http.MethodGet && strings.Split(request.RemoteAddr, ":")[0] == config.ManagementIP {
-		rw.WriteHeader(http.StatusOK)
-	} else {
-		rw.WriteHeader(http.StatusForbidden)
+//serves daemon API to Console over TLS, peers are authenticated by Console client certificate
+func setupSecureHttpServer() {
+	tlsConfig, err := util.GetServerTLSConfig()
+	if log.Check(log.WarnLevel, "Creating TLS config for daemon API", err) {
+		return
 	}
-}
 
-func heartbeatHandler(rw http.ResponseWriter, request *http.Request) {
-	clientIp := strings.Split(request.RemoteAddr, ":")[0]
+	//peers presenting foreign certificate are reported with their address
+	verify := tlsConfig.VerifyPeerCertificate
+	tlsConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		clientIp, _, _ := net.SplitHostPort(hello.Conn.RemoteAddr().String())
+
+		clientConfig := tlsConfig.Clone()
+		clientConfig.GetConfigForClient = nil
+		clientConfig.VerifyPeerCertificate = func(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+			err := verify(rawCerts, chains)
+			//failed handshakes are audited within rate limit of peer, so that scanning peers can not flood audit log
+			if err != nil {
+				if allowed, _ := limiter.Allow(clientIp); allowed {
+					audit.Start("remote:"+clientIp, "api", nil, "")(errors.New("Rejected: " + err.Error()))
+				}
+			}
+			return err
+		}
 
-	if request.Method == http.MethodGet && (clientIp == config.ManagementIP || strings.HasPrefix(request.RemoteAddr, "[::1]")) {
-		rw.WriteHeader(http.StatusOK)
-		go consol.SendHeartBeat(true)
-	} else {
-		rw.WriteHeader(http.StatusForbidden)
+		return clientConfig, nil
 	}
+
+	//no read/write timeouts since sessions are long-living connections
+	srv := &http.Server{
+		Addr:              ":" + vars.DAEMON_SECURE_PORT,
+		ReadHeaderTimeout: 15 * time.Second,
+		TLSConfig:         tlsConfig,
+		Handler:           &mySecureHandler{},
+		//TLS handshake failures are reported by server error log
+		ErrorLog: stdlog.New(logWriter{}, "", 0),
+	}
+	secureMux = make(map[string]route)
+	secureMux["/session"] = route{methods: []string{http.MethodGet}, handler: executer.SessionHandler, unlimited: true}
+	secureMux["/trigger"] = route{methods: []string{http.MethodPost}, handler: triggerHandler}
+	secureMux["/ping"] = route{methods: []string{http.MethodGet}, handler: pingHandler}
+	secureMux["/heartbeat"] = route{methods: []string{http.MethodGet, http.MethodPost}, handler: heartbeatHandler}
+	go func() {
+		log.Check(log.WarnLevel, "Serving daemon API", srv.ListenAndServeTLS("", ""))
+	}()
+}
+
+//passes messages of standard logger to agent log
+type logWriter struct{}
+
+func (logWriter) Write(p []byte) (int, error) {
+	log.Warn(strings.TrimSpace(string(p)))
+
+	return len(p), nil
+}
+
+func pingHandler(rw http.ResponseWriter, request *http.Request) {
+	rw.WriteHeader(http.StatusOK)
+}
+
+func heartbeatHandler(rw http.ResponseWriter, request *http.Request) {
+	rw.WriteHeader(http.StatusOK)
+	go consol.SendHeartBeat(true)
 }
 
 func triggerHandler(rw http.ResponseWriter, request *http.Request) {
-	if request.Method == http.MethodPost && strings.Split(request.RemoteAddr, ":")[0] == config.ManagementIP {
-		rw.WriteHeader(http.StatusAccepted)
-		go consol.ExecuteConsoleCommands()
-	} else {
-		rw.WriteHeader(http.StatusForbidden)
-	}
+	rw.WriteHeader(http.StatusAccepted)
+	go consol.ExecuteConsoleCommands()
 }
 
 //<<<HTTP server
@@ -11,18 +11,17 @@ import (
 	"fmt"
 	"os"
 	"strings"
-	"bytes"
 	"encoding/json"
 	"runtime"
 	"github.com/subutai-io/agent/lib/net"
 	"github.com/subutai-io/agent/log"
-	"net/url"
 	"sync"
 	"github.com/subutai-io/agent/agent/executer"
 	"time"
 	"github.com/subutai-io/agent/db"
 	cont "github.com/subutai-io/agent/lib/container"
 	"github.com/wunderlist/ttlcache"
+	"strconv"
 )
 
 var (
@@ -34,14 +33,15 @@ var (
 	checkRegistrationLock sync.Mutex
 	pool                  []Container
 	cache                 *ttlcache.Cache
+	quotaCache            *ttlcache.Cache
 )
 
 func init() {
-	httpUtil := util.GetUtil()
-	sc, err := httpUtil.GetSecureClient(30)
+	transport, err := NewHttpTransport()
 	log.Check(log.FatalLevel, "'Initializing Console connectivity", err)
 	cache = util.GetCache(time.Minute * 30)
-	console = Console{httpUtil: httpUtil, client: httpUtil.GetClient(30), secureClient: sc, fingerprint: gpg.GetRhFingerprint()}
+	quotaCache = util.GetCache(time.Minute * 30)
+	console = NewConsole(transport)
 	config.Management.GpgUser, _ = db.GetMhGpgUsername()
 }
 
@@ -49,6 +49,11 @@ func GetConsole() Console {
 	return console
 }
 
+// NewConsole returns Console talking through passed transport
+func NewConsole(transport ConsoleTransport) Console {
+	return Console{transport: transport}
+}
+
 func (c Console) Heartbeats() {
 	for {
 		if c.CheckRegistration() {
@@ -66,12 +71,8 @@ func (c Console) Heartbeats() {
 //returns true if Console is ready to operate
 //returns false if not approved or any error during checking status
 func (c Console) IsReady() bool {
-	resp, err := c.client.Get("https://" + path.Join(config.ManagementIP) + ":8443/rest/health/ready")
-	if err == nil {
-		defer c.Close(resp)
-		if resp.StatusCode == http.StatusOK {
-			return true
-		}
+	if c.transport.Ready() {
+		return true
 	}
 	log.Warn("Console is not ready")
 	return false
@@ -80,21 +81,12 @@ func (c Console) IsReady() bool {
 //returns true if Console has approved this RH registration
 //returns false if not approved or any error during checking registration
 func (c Console) IsRegistered() bool {
-	theUrl := "https://" + path.Join(config.ManagementIP) + ":8444/rest/v1/agent/check/" + c.fingerprint
-	resp, err := c.secureClient.Get(theUrl)
-	if err == nil {
-		defer c.Close(resp)
-		if resp.StatusCode == http.StatusOK {
-			return true
-		}
-	}
-
-	return false
+	return c.transport.Registered(gpg.GetRhFingerprint())
 }
 
 //returns true if Console has approved this RH registration
 //returns false if not approved or any error during checking registration
-//resets http client to ensure clean operation
+//resets transport to ensure clean operation
 func (c Console) CheckRegistration() bool {
 	if c.IsRegistered() {
 		return true
@@ -105,11 +97,7 @@ func (c Console) CheckRegistration() bool {
 	checkRegistrationLock.Lock()
 	defer checkRegistrationLock.Unlock()
 
-	c.secureClient.Transport.(*http.Transport).CloseIdleConnections()
-	//recreate secure client to exclude issue with SSL
-	var err error
-	c.secureClient, err = c.httpUtil.GetSecureClient(30)
-	log.Check(log.FatalLevel, "Recreating secure client", err)
+	log.Check(log.FatalLevel, "Recreating secure client", c.transport.Reset())
 
 	return false
 }
@@ -131,7 +119,7 @@ func (c Console) Register() error {
 		Cert:         util.PublicCert(),
 		Address:      net.GetIp(),
 		InstanceType: util.InstanceType(),
-		Containers:   containers(true),
+		Containers:   containers(true, true),
 	})
 	if err != nil {
 		return err
@@ -142,40 +130,31 @@ func (c Console) Register() error {
 		return err
 	}
 
-	resp, err := c.client.Post("https://"+path.Join(config.ManagementIP)+":8443/rest/v1/registration/public-key", "text/plain",
-		bytes.NewBuffer(msg))
-	if err == nil {
-		defer c.Close(resp)
-	} else {
-		return err
-	}
-
-	return nil
+	return c.transport.Register(msg)
 }
 
 func (c Console) GetFingerprint() (string, error) {
-	resp, err := c.client.Get("https://" + path.Join(config.ManagementIP) + ":8443/rest/v1/security/keyman/getpublickeyfingerprint")
-	if err == nil {
-		defer c.Close(resp)
-	} else {
-		return "", err
-	}
-
-	if resp.StatusCode == 200 {
-		fp, err := ioutil.ReadAll(resp.Body)
-		if err == nil {
-			return string(fp), nil
-		} else {
-			return "", err
-		}
-	} else {
-		return "", errors.New(fmt.Sprintf("Response status %d", resp.StatusCode))
-	}
+	return c.transport.Fingerprint()
 }
 
-var lastHeartbeatTime time.Time
-var lastHeartbeat []byte
+const (
+	//period of sending full heartbeat with all containers, deltas are sent in between
+	fullHeartbeatPeriod = time.Minute * 10
+)
+
+var (
+	lastHeartbeatTime     time.Time
+	lastFullHeartbeatTime time.Time
+	//sequence number of last heartbeat accepted by Console
+	lastSequence int64
+	//containers reported by last accepted heartbeat, by name
+	lastContainers = make(map[string]Container)
+	//set when Console reports missed heartbeat
+	needFullHeartbeat bool
+)
+
 //sends heartbeat to Console
+//full heartbeat contains all containers, delta heartbeat contains only containers changed since previous heartbeat
 //todo check and return errors
 func (c Console) SendHeartBeat(force bool) error {
 	heartbeatLock.Lock()
@@ -186,26 +165,55 @@ func (c Console) SendHeartBeat(force bool) error {
 		return nil
 	}
 
-	pool = containers(false)
+	err := c.sendHeartBeat(force)
+	if err == errHeartbeatGap {
+		log.Info("Console reported heartbeat gap, sending full heartbeat")
+		needFullHeartbeat = true
+		err = c.sendHeartBeat(force)
+	}
+
+	return err
+}
+
+var errHeartbeatGap = errors.New("Heartbeat sequence gap")
+
+func (c Console) sendHeartBeat(force bool) error {
+	full := needFullHeartbeat || lastSequence == 0 || time.Since(lastFullHeartbeatTime) > fullHeartbeatPeriod
+
+	//quotas are recalculated for full and forced heartbeats only
+	pool = containers(false, full || force)
+	current := make(map[string]Container)
+	for _, cont := range pool {
+		current[cont.Name] = cont
+	}
+
 	hostname, err := os.Hostname()
 	log.Check(log.DebugLevel, "Obtaining RH hostname", err)
-	res := response{Beat: heartbeat{
-		Type:       "HEARTBEAT",
-		Hostname:   hostname,
-		Address:    net.GetIp(),
-		ID:         gpg.GetRhFingerprint(),
-		Arch:       instanceArch,
-		Instance:   instanceType,
-		Containers: pool,
-	}}
-	heartbeat, err := json.Marshal(&res)
-	if log.Check(log.WarnLevel, "Marshaling heartbeat JSON", err) {
-		return err
+	beat := heartbeat{
+		Type:     "HEARTBEAT",
+		Hostname: hostname,
+		Address:  net.GetIp(),
+		ID:       gpg.GetRhFingerprint(),
+		Arch:     instanceArch,
+		Instance: instanceType,
+		Sequence: lastSequence + 1,
 	}
 
-	//dont send heartbeat if nothing changed in its content
-	if !force && string(heartbeat) == string(lastHeartbeat) {
-		return nil
+	if full {
+		beat.Containers = pool
+	} else {
+		beat.Type = "HEARTBEAT_DELTA"
+		beat.Added, beat.Changed, beat.Removed = delta(lastContainers, current)
+
+		//dont send heartbeat if nothing changed since last one
+		if !force && len(beat.Added) == 0 && len(beat.Changed) == 0 && len(beat.Removed) == 0 {
+			return nil
+		}
+	}
+
+	heartbeat, err := json.Marshal(&response{Beat: beat})
+	if log.Check(log.WarnLevel, "Marshaling heartbeat JSON", err) {
+		return err
 	}
 
 	encryptedMessage, err := gpg.EncryptWrapper(config.Agent.GpgUser, config.Management.GpgUser, heartbeat)
@@ -213,21 +221,66 @@ func (c Console) SendHeartBeat(force bool) error {
 		message, err := json.Marshal(map[string]string{"hostId": gpg.GetRhFingerprint(), "response": string(encryptedMessage)})
 		log.Check(log.WarnLevel, "Marshal response json", err)
 
-		resp, err := postForm(c.secureClient, "https://"+path.Join(config.ManagementIP)+":8444/rest/v1/agent/heartbeat", url.Values{"heartbeat": {string(message)}})
+		status, err := c.transport.Heartbeat(message)
 		if !log.Check(log.WarnLevel, "Sending heartbeat: "+string(heartbeat), err) {
-			defer util.Close(resp)
-
-			if resp.StatusCode == http.StatusAccepted {
+			switch status {
+			case http.StatusAccepted:
 				lastHeartbeatTime = time.Now()
-				lastHeartbeat = heartbeat
+				lastSequence = beat.Sequence
+				lastContainers = current
+				if full {
+					lastFullHeartbeatTime = lastHeartbeatTime
+					needFullHeartbeat = false
+				}
 				return nil
+			case http.StatusConflict:
+				//Console has missed previous heartbeat
+				if !full {
+					return errHeartbeatGap
+				}
 			}
+
+			err = errors.New(fmt.Sprintf("Heartbeat response status %d", status))
 		}
 	}
 
 	return err
 }
 
+//compares containers of previous and current heartbeats
+//removed containers are reported by id
+func delta(previous, current map[string]Container) (added []Container, changed []Container, removed []string) {
+	for name, cont := range current {
+		prev, ok := previous[name]
+		if !ok {
+			added = append(added, cont)
+		} else if !equal(prev, cont) {
+			changed = append(changed, cont)
+		}
+	}
+
+	for name, cont := range previous {
+		if _, ok := current[name]; !ok {
+			removed = append(removed, cont.ID)
+		}
+	}
+
+	return added, changed, removed
+}
+
+func equal(a, b Container) bool {
+	aJson, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	bJson, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+
+	return string(aJson) == string(bJson)
+}
+
 //import Console public gpg key to RH keyring
 func (c Console) ImportPubKey() error {
 	key, err := c.getPubKey()
@@ -259,7 +312,7 @@ func (c Console) ExecuteConsoleCommands() {
 
 //fetches Console public GPG key
 func (c Console) getPubKey() ([]byte, error) {
-	return util.GetConsolePubKey()
+	return c.transport.PublicKey()
 }
 
 //returns container name by container id
@@ -271,59 +324,71 @@ func (c Console) getContainerNameByID(id string) string {
 		}
 	}
 
-	return ""
-}
+	//container key may have been rotated, requests for the old key are accepted during grace period
+	if owner := gpg.RetiredKeyOwner(id); owner != config.Agent.GpgUser {
+		return owner
+	}
 
-func (c Console) Close(resp *http.Response) {
-	c.httpUtil.Close(resp)
+	return ""
 }
 
 //fetch commands to execute from Console
 func (c Console) getCommands() []executer.EncRequest {
-	var rsp []executer.EncRequest
-
-	theUrl := "https://" + path.Join(config.ManagementIP) + ":8444/rest/v1/agent/requests/" + gpg.GetRhFingerprint()
-
-	resp, err := c.secureClient.Get(theUrl)
-	if err == nil {
-		defer util.Close(resp)
+	rsp, err := c.transport.Requests(gpg.GetRhFingerprint())
+	log.Check(log.WarnLevel, "Fetching commands from Console", err)
+
+	//Console may still queue requests for rotated RH key
+	for _, fp := range gpg.RetiredFingerprints(config.Agent.GpgUser) {
+		retired, err := c.transport.Requests(fp)
+		if !log.Check(log.WarnLevel, "Fetching commands for retired key from Console", err) {
+			rsp = append(rsp, retired...)
+		}
 	}
 
-	if log.Check(log.WarnLevel, "Fetching commands from Console", err) {
-		return rsp
-	}
+	return rsp
+}
 
-	if resp.StatusCode == http.StatusNoContent {
-		return rsp
+//send a single command execution result to Console
+//response is kept in journal until Console accepts it, so that it survives agent restart
+//responses of the same command are delivered in order of sending
+func (c Console) sendResponse(commandID string, msg []byte, deadline time.Time) {
+	//command with short or no timeout still gets time to deliver its response
+	if min := time.Now().Add(minDeliveryWindow); deadline.Before(min) {
+		deadline = min
 	}
+	response := &db.PendingResponse{CommandId: commandID, Message: msg, Deadline: deadline}
+	log.Check(log.WarnLevel, "Journaling response", db.SavePendingResponse(response))
 
-	data, err := ioutil.ReadAll(resp.Body)
-	if log.Check(log.WarnLevel, "Reading commands from Console", err) {
-		return rsp
-	}
+	enqueue(c, response)
+}
 
-	if log.Check(log.WarnLevel, "Parsing commands from Console", json.Unmarshal(data, &rsp)) {
-		return rsp
+//sends response to Console, returns true if Console has accepted it
+func (c Console) deliverResponse(response *db.PendingResponse) bool {
+	status, err := c.transport.Response(response.Message)
+	if !log.Check(log.WarnLevel, "Sending response "+string(response.Message), err) {
+		return status == http.StatusAccepted
 	}
 
-	return rsp
+	return false
 }
 
-//send a single command execution result to Console
-func (c Console) sendResponse(msg []byte, deadline time.Time) {
-	resp, err := postForm(c.secureClient, "https://"+path.Join(config.ManagementIP)+":8444/rest/v1/agent/response", url.Values{"response": {string(msg)}})
-	if !log.Check(log.WarnLevel, "Sending response "+string(msg), err) {
-		defer util.Close(resp)
-		if resp.StatusCode == http.StatusAccepted {
-			return
+//delivers responses left undelivered by previous agent run
+//and reports commands orphaned by agent restart
+func (c Console) RestoreJournal() {
+	//responses themselves are loaded from journal when their turn comes
+	commands, err := db.GetPendingCommands()
+	if !log.Check(log.WarnLevel, "Reading pending responses", err) {
+		for commandID, count := range commands {
+			enqueueJournaled(c, commandID, count)
 		}
 	}
 
-	//retry sending a response
-	if deadline.After(time.Now()) {
-		time.Sleep(time.Second * 5)
-		go c.sendResponse(msg, deadline)
-	}
+	executer.Reattach(c.sendResponse)
+}
+
+// PendingResponses returns number of command responses not yet accepted by Console
+func (c Console) PendingResponses() int {
+	return pending()
 }
 
 func (c Console) execute(cmd executer.EncRequest) {
@@ -332,7 +397,8 @@ func (c Console) execute(cmd executer.EncRequest) {
 }
 
 // containers provides list of active Subutai containers.
-func containers(details bool) []Container {
+// fresh forces recalculation of cached quotas
+func containers(details bool, fresh bool) []Container {
 	var contArr []Container
 
 	for _, c := range cont.Containers() {
@@ -351,14 +417,16 @@ func containers(details bool) []Container {
 				Vlan:     ct.Vlan,
 				EnvId:    ct.EnvironmentId,
 			}
+			if ct.HealthCheck != "" {
+				aContainer.Health = ct.Health
+			}
 
 			aContainer.Interfaces = interfaces(c, ct.Ip)
 
 			//cacheable properties>>>
 
-			aContainer.ID = util.GetFromCacheOrCalculate(cache, c+"_fingerprint", func() string {
-				return gpg.GetFingerprint(c)
-			})
+			//not cached since container key may be rotated
+			aContainer.ID = gpg.GetFingerprint(c)
 
 			aContainer.Arch = util.GetFromCacheOrCalculate(cache, c+"_arch", func() string {
 				return strings.ToUpper(cont.GetConfigItem(configPath, "lxc.arch"))
@@ -368,11 +436,17 @@ func containers(details bool) []Container {
 				return cont.GetConfigItem(configPath, "subutai.parent")
 			})
 
-			aContainer.Quota.RAM = cont.QuotaRAM(c, "")
+			aContainer.Quota.RAM = quota(c+"_ram", fresh, func() int {
+				return cont.QuotaRAM(c, "")
+			})
 
-			aContainer.Quota.CPU = cont.QuotaCPU(c, "")
+			aContainer.Quota.CPU = quota(c+"_cpu", fresh, func() int {
+				return cont.QuotaCPU(c, "")
+			})
 
-			aContainer.Quota.Disk = cont.QuotaDisk(c, "")
+			aContainer.Quota.Disk = quota(c+"_disk", fresh, func() int {
+				return cont.QuotaDisk(c, "")
+			})
 
 			//<<<cacheable properties
 
@@ -387,6 +461,22 @@ func containers(details bool) []Container {
 	return contArr
 }
 
+//returns cached quota value, recalculates it if missing or fresh value is requested
+func quota(key string, fresh bool, calc func() int) int {
+	if fresh {
+		value := calc()
+		quotaCache.Set(key, strconv.Itoa(value))
+		return value
+	}
+
+	value, err := strconv.Atoi(util.GetFromCacheOrCalculate(quotaCache, key, func() string {
+		return strconv.Itoa(calc())
+	}))
+	log.Check(log.DebugLevel, "Parsing cached quota "+key, err)
+
+	return value
+}
+
 //this should be done together with Console changes
 func interfaces(name string, staticIp string) []Iface {
 
@@ -404,12 +494,3 @@ func interfaces(name string, staticIp string) []Iface {
 
 	return []Iface{*iface}
 }
-
-func postForm(client *http.Client, url string, data url.Values) (resp *http.Response, err error) {
-	req, err := http.NewRequest("POST", url, strings.NewReader(data.Encode()))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	return client.Do(req)
-}
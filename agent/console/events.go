@@ -0,0 +1,96 @@
+package console
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/gpg"
+	"github.com/subutai-io/agent/log"
+)
+
+const (
+	//max number of events sent in a single request
+	eventsBatchSize = 100
+	//max number of buffered events, the oldest ones are dropped when exceeded
+	maxBufferedEvents = 10000
+	eventsPollPeriod  = time.Second * 5
+	maxEventsDelay    = time.Minute * 2
+)
+
+type eventMessage struct {
+	Type      string            `json:"type"`
+	Container string            `json:"container,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	Time      int64             `json:"time"`
+}
+
+//pushes buffered events to Console, retries with growing delay on failures
+func (c Console) Events() {
+	delay := eventsPollPeriod
+	for {
+		time.Sleep(delay)
+
+		removed, err := db.TrimEvents(maxBufferedEvents)
+		if !log.Check(log.WarnLevel, "Trimming events buffer", err) && removed > 0 {
+			log.Warn("Dropped " + strconv.Itoa(removed) + " undelivered events")
+		}
+
+		if err := c.sendEvents(); err != nil {
+			log.Warn("Sending events to Console: " + err.Error())
+			if delay *= 2; delay > maxEventsDelay {
+				delay = maxEventsDelay
+			}
+		} else {
+			delay = eventsPollPeriod
+		}
+	}
+}
+
+//sends buffered events in batches until buffer is empty
+func (c Console) sendEvents() error {
+	for {
+		events, err := db.GetEvents(eventsBatchSize)
+		if err != nil || len(events) == 0 {
+			return err
+		}
+
+		var messages []eventMessage
+		for _, e := range events {
+			messages = append(messages, eventMessage{Type: e.Type, Container: e.Container, Details: e.Details, Time: e.Time.Unix()})
+		}
+
+		data, err := json.Marshal(map[string]interface{}{"id": gpg.GetRhFingerprint(), "events": messages})
+		if err != nil {
+			return err
+		}
+
+		encryptedMessage, err := gpg.EncryptWrapper(config.Agent.GpgUser, config.Management.GpgUser, data)
+		if err != nil {
+			return err
+		}
+
+		message, err := json.Marshal(map[string]string{"hostId": gpg.GetRhFingerprint(), "response": string(encryptedMessage)})
+		if err != nil {
+			return err
+		}
+
+		status, err := c.transport.Events(message)
+		if err != nil {
+			return err
+		}
+
+		if status != http.StatusAccepted && status != http.StatusOK {
+			return errors.New(fmt.Sprintf("Response status %d", status))
+		}
+
+		if err = db.RemoveEvents(events); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package console
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/log"
+)
+
+const (
+	//number of consecutive failed health checks of active Management host which triggers failover
+	maxHostFailures = 3
+	hostCheckPeriod = time.Second * 10
+)
+
+var (
+	hostFailures int
+	//consecutive successful health checks of preferred Management host while agent talks to another one
+	preferredSuccesses int
+	failoverLock       sync.Mutex
+)
+
+// MonitorHosts health-checks active Management host and fails over to the next healthy host
+// from configured list after maxHostFailures consecutive failures.
+// Agent fails back to the first, preferred, host once it passes maxHostFailures consecutive health checks.
+// Returns immediately if less than two Management hosts are configured.
+func (c Console) MonitorHosts() {
+	if len(config.ManagementHosts()) < 2 {
+		return
+	}
+
+	for {
+		if c.ActiveHostHealthy() {
+			hostFailures = 0
+			c.failback()
+		} else {
+			hostFailures++
+			log.Warn("Management host " + config.ManagementIP() + " failed health check " + strconv.Itoa(hostFailures) + " time(s)")
+
+			if hostFailures >= maxHostFailures && c.Failover() {
+				hostFailures = 0
+			}
+		}
+
+		time.Sleep(hostCheckPeriod)
+	}
+}
+
+//switches agent back to preferred Management host once it is healthy again
+func (c Console) failback() {
+	preferred := config.ManagementHosts()[0]
+	if preferred.Address == config.ManagementIP() || !c.healthy(preferred) {
+		preferredSuccesses = 0
+		return
+	}
+
+	if preferredSuccesses++; preferredSuccesses < maxHostFailures {
+		return
+	}
+	preferredSuccesses = 0
+
+	failoverLock.Lock()
+	defer failoverLock.Unlock()
+
+	log.Info("Failing back from Management host " + config.ManagementIP() + " to " + preferred.Address)
+	c.switchTo(preferred)
+}
+
+// ActiveHostHealthy returns true if active Management host is ready and presents expected Console key
+func (c Console) ActiveHostHealthy() bool {
+	host, ok := ActiveHost()
+	if !ok {
+		return false
+	}
+
+	return c.healthy(host)
+}
+
+// Failover switches agent to the first healthy Management host in order of configuration, other than active one,
+// and registers with it. Returns false if no healthy host is found.
+func (c Console) Failover() bool {
+	failoverLock.Lock()
+	defer failoverLock.Unlock()
+
+	for _, host := range config.ManagementHosts() {
+		if host.Address == config.ManagementIP() || !c.healthy(host) {
+			continue
+		}
+
+		log.Info("Failing over from Management host " + config.ManagementIP() + " to " + host.Address)
+		c.switchTo(host)
+
+		return true
+	}
+
+	log.Warn("No healthy Management host found")
+
+	return false
+}
+
+//makes passed host active and registers with it, must be called with failoverLock held
+func (c Console) switchTo(host config.ManagementHost) {
+	config.SetManagementIP(host.Address)
+	log.Check(log.WarnLevel, "Resetting Console connections", c.transport.Reset())
+	log.Check(log.WarnLevel, "Importing Console key", c.ImportPubKey())
+	log.Check(log.WarnLevel, "Sending registration request to Console", c.Register())
+
+	//new Console has to receive all containers
+	heartbeatLock.Lock()
+	needFullHeartbeat = true
+	heartbeatLock.Unlock()
+}
+
+// ActiveHost returns configured Management host agent currently talks to.
+// Returns false if active host is not in configured list, e.g. it was found by discovery.
+func ActiveHost() (config.ManagementHost, bool) {
+	for _, host := range config.ManagementHosts() {
+		if host.Address == config.ManagementIP() {
+			return host, true
+		}
+	}
+
+	return config.ManagementHost{Address: config.ManagementIP()}, false
+}
+
+//returns true if Console at passed host is ready and its key matches configured fingerprint
+func (c Console) healthy(host config.ManagementHost) bool {
+	fp, err := c.transport.Probe(host.Address)
+	if err != nil {
+		log.Debug("Probing Management host " + host.Address + ": " + err.Error())
+		return false
+	}
+
+	if host.Fingerprint != "" && !strings.EqualFold(strings.TrimSpace(fp), host.Fingerprint) {
+		log.Warn("Management host " + host.Address + " presents unexpected fingerprint " + fp)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,551 @@
+// Package mock emulates Subutai Console endpoints used by agent, so that registration,
+// command execution and heartbeat flows can be exercised without real Management server.
+//
+// Mock Console keeps own GPG keyrings in its working directory and handles GPG envelope
+// the same way Console does. Resource hosts are approved automatically upon registration.
+//
+// Besides Console endpoints, following control endpoints are served on public port to loopback clients:
+//	GET  /mock/hosts                  lists registered hosts
+//	POST /mock/execute?host=<id>      queues request (JSON of executer.RequestOptions) for RH or container with passed id
+//	GET  /mock/responses?command=<id> lists responses received for command
+//
+// Mock Console is run by tools/mock-console built with mock tag and used by end-to-end tests built with e2e tag.
+package mock
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/subutai-io/agent/agent/executer"
+	"github.com/subutai-io/agent/agent/vars"
+	"github.com/subutai-io/agent/lib/gpg"
+	"github.com/subutai-io/agent/log"
+)
+
+//GPG identity of mock Console
+const consoleUser = "console@subutai.io"
+
+// Host describes Resource host registered with mock Console
+type Host struct {
+	ID       string `json:"id"`
+	Hostname string `json:"hostname"`
+	//address used to trigger request fetching
+	Address       string    `json:"address"`
+	Sequence      int64     `json:"sequence"`
+	LastHeartbeat time.Time `json:"lastHeartbeat"`
+	//container ids by name
+	Containers map[string]string `json:"containers"`
+	requests   []executer.EncRequest
+}
+
+type server struct {
+	dir       string
+	hosts     map[string]*Host
+	responses map[string][]executer.ResponseOptions
+	lock      sync.Mutex
+	//presented to agents as client certificate as well
+	cert tls.Certificate
+}
+
+//registration request sent by agent
+type registration struct {
+	ID         string `json:"id"`
+	Hostname   string `json:"hostname"`
+	Pk         string `json:"publicKey"`
+	Containers []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+		Pk   string `json:"publicKey"`
+	} `json:"hostInfos"`
+}
+
+//heartbeat sent by agent, only fields tracked by mock Console
+type heartbeat struct {
+	Response struct {
+		Type       string `json:"type"`
+		ID         string `json:"id"`
+		Sequence   int64  `json:"sequence"`
+		Containers []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"containers"`
+		Added []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"added"`
+		Removed []string `json:"removed"`
+	} `json:"response"`
+}
+
+//envelope of messages posted to agent endpoints
+type envelope struct {
+	HostID   string `json:"hostId"`
+	Response string `json:"response"`
+}
+
+// Serve starts mock Console on passed ports, dir keeps mock Console GPG keyrings.
+// Blocks until public port listener fails.
+func Serve(dir, port, securePort string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	s := &server{dir: dir, hosts: make(map[string]*Host), responses: make(map[string][]executer.ResponseOptions)}
+	if err := s.generateKey(); err != nil {
+		return err
+	}
+
+	cert, err := selfSignedCert()
+	if err != nil {
+		return err
+	}
+	s.cert = cert
+
+	public := http.NewServeMux()
+	public.HandleFunc("/rest/health/ready", s.ready)
+	public.HandleFunc("/rest/v1/security/keyman/getpublickeyring", s.publicKey)
+	public.HandleFunc("/rest/v1/security/keyman/getpublickeyfingerprint", s.fingerprint)
+	public.HandleFunc("/rest/v1/registration/public-key", s.register)
+	public.HandleFunc("/mock/hosts", local(s.listHosts))
+	public.HandleFunc("/mock/execute", local(s.execute))
+	public.HandleFunc("/mock/responses", local(s.listResponses))
+
+	secure := http.NewServeMux()
+	secure.HandleFunc("/rest/v1/agent/check/", s.check)
+	secure.HandleFunc("/rest/v1/agent/heartbeat", s.heartbeat)
+	secure.HandleFunc("/rest/v1/agent/requests/", s.requests)
+	secure.HandleFunc("/rest/v1/agent/response", s.response)
+	secure.HandleFunc("/rest/v1/agent/events", s.events)
+
+	secureSrv := &http.Server{
+		Addr:      ":" + securePort,
+		Handler:   clientCert(secure),
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}, ClientAuth: tls.RequireAnyClientCert},
+	}
+	go func() {
+		log.Check(log.WarnLevel, "Serving mock Console secure port", secureSrv.ListenAndServeTLS("", ""))
+	}()
+
+	publicSrv := &http.Server{
+		Addr:      ":" + port,
+		Handler:   public,
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+	}
+
+	log.Info("Mock Console is listening on ports " + port + " and " + securePort)
+
+	return publicSrv.ListenAndServeTLS("", "")
+}
+
+//Console endpoints >>>
+
+func (s *server) ready(rw http.ResponseWriter, r *http.Request) {
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (s *server) publicKey(rw http.ResponseWriter, r *http.Request) {
+	key := gpg.ExportPk(s.pubring(), consoleUser)
+	if key == "" {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rw.Write([]byte(key))
+}
+
+func (s *server) fingerprint(rw http.ResponseWriter, r *http.Request) {
+	fp := gpg.KeyringFingerprint(s.pubring(), consoleUser)
+	if fp == "" {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rw.Write([]byte(fp))
+}
+
+func (s *server) register(rw http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if log.Check(log.WarnLevel, "Reading registration request", err) {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var reg registration
+	if log.Check(log.WarnLevel, "Decrypting registration request", s.decrypt(body, &reg)) {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	log.Check(log.WarnLevel, "Importing key of RH "+reg.ID, s.importKey(reg.Pk))
+
+	address, _, err := net.SplitHostPort(r.RemoteAddr)
+	log.Check(log.DebugLevel, "Parsing RH address", err)
+
+	h := &Host{ID: reg.ID, Hostname: reg.Hostname, Address: address, Containers: make(map[string]string)}
+	for _, c := range reg.Containers {
+		h.Containers[c.Name] = c.ID
+		log.Check(log.WarnLevel, "Importing key of container "+c.Name, s.importKey(c.Pk))
+	}
+
+	s.lock.Lock()
+	//rotated key is accepted in place of the old one if it is certified by the old key
+	if _, ok := s.hosts[reg.ID]; !ok {
+		for id, old := range s.hosts {
+			if gpg.CertifiedBy([]byte(reg.Pk), s.pubring(), id) {
+				log.Info("RH " + reg.Hostname + " rotated key " + id + " to " + reg.ID)
+				h.requests = old.requests
+				delete(s.hosts, id)
+				break
+			}
+		}
+	}
+	s.hosts[reg.ID] = h
+	s.lock.Unlock()
+
+	log.Info("Registered RH " + reg.Hostname + " " + reg.ID + " at " + address)
+}
+
+func (s *server) check(rw http.ResponseWriter, r *http.Request) {
+	if s.host(path.Base(r.URL.Path)) == nil {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (s *server) heartbeat(rw http.ResponseWriter, r *http.Request) {
+	var beat heartbeat
+	if log.Check(log.WarnLevel, "Decrypting heartbeat", s.open(r.FormValue("heartbeat"), &beat)) {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	h, ok := s.hosts[beat.Response.ID]
+	if !ok {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if beat.Response.Type == "HEARTBEAT_DELTA" {
+		if beat.Response.Sequence != h.Sequence+1 {
+			log.Info("Heartbeat gap from " + h.Hostname + ", requesting full heartbeat")
+			rw.WriteHeader(http.StatusConflict)
+			return
+		}
+		for _, c := range beat.Response.Added {
+			h.Containers[c.Name] = c.ID
+		}
+		for _, id := range beat.Response.Removed {
+			for name, cid := range h.Containers {
+				if cid == id {
+					delete(h.Containers, name)
+				}
+			}
+		}
+	} else {
+		h.Containers = make(map[string]string)
+		for _, c := range beat.Response.Containers {
+			h.Containers[c.Name] = c.ID
+		}
+	}
+
+	h.Sequence = beat.Response.Sequence
+	h.LastHeartbeat = time.Now()
+
+	log.Debug("Heartbeat " + beat.Response.Type + " from " + h.Hostname)
+
+	rw.WriteHeader(http.StatusAccepted)
+}
+
+func (s *server) requests(rw http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	h, ok := s.hosts[path.Base(r.URL.Path)]
+	if !ok || len(h.requests) == 0 {
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	requests := h.requests
+	h.requests = nil
+
+	data, err := json.Marshal(requests)
+	if log.Check(log.WarnLevel, "Marshalling requests", err) {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rw.Write(data)
+}
+
+func (s *server) response(rw http.ResponseWriter, r *http.Request) {
+	var response executer.Response
+	if log.Check(log.WarnLevel, "Decrypting response", s.open(r.FormValue("response"), &response)) {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	opts := response.ResponseOpts
+	log.Info("Response to command " + opts.CommandID + ": " + opts.StdOut + opts.StdErr)
+
+	s.lock.Lock()
+	s.responses[opts.CommandID] = append(s.responses[opts.CommandID], opts)
+	s.lock.Unlock()
+
+	rw.WriteHeader(http.StatusAccepted)
+}
+
+func (s *server) events(rw http.ResponseWriter, r *http.Request) {
+	var events struct {
+		Events []json.RawMessage `json:"events"`
+	}
+	if log.Check(log.WarnLevel, "Decrypting events", s.open(r.FormValue("events"), &events)) {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	for _, e := range events.Events {
+		log.Info("Event " + string(e))
+	}
+
+	rw.WriteHeader(http.StatusAccepted)
+}
+
+//<<<Console endpoints
+
+//control endpoints >>>
+
+func (s *server) listHosts(rw http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var hosts []Host
+	for _, h := range s.hosts {
+		hosts = append(hosts, *h)
+	}
+
+	writeJson(rw, hosts)
+}
+
+func (s *server) execute(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	target := r.URL.Query().Get("host")
+	h := s.owner(target)
+	if h == nil {
+		http.Error(rw, "Unknown host "+target, http.StatusNotFound)
+		return
+	}
+
+	var opts executer.RequestOptions
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if opts.Type == "" {
+		opts.Type = "EXECUTE_REQUEST"
+	}
+	if opts.CommandID == "" {
+		opts.CommandID = newID()
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 30
+	}
+	opts.ID = target
+
+	//Console encrypts request options only, target is known from envelope
+	data, err := json.Marshal(opts)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	encrypted, err := gpg.EncryptWrapper(consoleUser, target, data, s.pubring(), s.secring())
+	if err != nil {
+		http.Error(rw, "Encrypting request: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.lock.Lock()
+	h.requests = append(h.requests, executer.EncRequest{HostID: target, Request: string(encrypted)})
+	address := h.Address
+	s.lock.Unlock()
+
+	//let agent know that there are requests waiting
+	go func() {
+		client := &http.Client{Timeout: 30 * time.Second, Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{Certificates: []tls.Certificate{s.cert}, InsecureSkipVerify: true},
+		}}
+		resp, err := client.Post("https://"+net.JoinHostPort(address, vars.DAEMON_SECURE_PORT)+"/trigger", "text/plain", nil)
+		if !log.Check(log.WarnLevel, "Triggering agent at "+address, err) {
+			resp.Body.Close()
+		}
+	}()
+
+	writeJson(rw, map[string]string{"commandId": opts.CommandID})
+}
+
+func (s *server) listResponses(rw http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	writeJson(rw, s.responses[r.URL.Query().Get("command")])
+}
+
+//<<<control endpoints
+
+func (s *server) host(id string) *Host {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.hosts[id]
+}
+
+//returns RH with passed id or RH hosting container with passed id
+func (s *server) owner(id string) *Host {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, h := range s.hosts {
+		if h.ID == id {
+			return h
+		}
+		for _, cid := range h.Containers {
+			if cid == id {
+				return h
+			}
+		}
+	}
+
+	return nil
+}
+
+//opens envelope posted by agent and unmarshals decrypted payload
+func (s *server) open(message string, v interface{}) error {
+	var env envelope
+	if err := json.Unmarshal([]byte(message), &env); err != nil {
+		return err
+	}
+
+	return s.decrypt([]byte(env.Response), v)
+}
+
+func (s *server) decrypt(message []byte, v interface{}) error {
+	//signature of unknown sender can not be verified, but payload is still decrypted
+	out := gpg.DecryptWrapper(string(message), s.secring(), s.pubring())
+	if out == "" {
+		return errors.New("Failed to decrypt message")
+	}
+
+	return json.Unmarshal([]byte(out), v)
+}
+
+func (s *server) importKey(key string) error {
+	if key == "" {
+		return nil
+	}
+
+	return gpg.ImportPkTo(s.pubring(), []byte(key))
+}
+
+//generates mock Console key if missing
+func (s *server) generateKey() error {
+	if gpg.KeyringFingerprint(s.pubring(), consoleUser) != "" {
+		return nil
+	}
+
+	log.Info("Generating mock Console GPG key in " + s.dir)
+
+	return gpg.GenerateKeyring("console", consoleUser, s.pubring(), s.secring())
+}
+
+func (s *server) pubring() string {
+	return path.Join(s.dir, "pubring.gpg")
+}
+
+func (s *server) secring() string {
+	return path.Join(s.dir, "secring.gpg")
+}
+
+//allows control endpoints to loopback clients only
+func local(handler http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil || !net.ParseIP(host).IsLoopback() {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		handler(rw, r)
+	}
+}
+
+//agent endpoints require client certificate, just like Console does
+func clientCert(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(rw, r)
+	})
+}
+
+func writeJson(rw http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if log.Check(log.WarnLevel, "Marshalling reply", err) {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Write(data)
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+func selfSignedCert() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "mock-console"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,148 @@
+// +build e2e
+
+// End-to-end test of agent talking to mock Console.
+// Test registers Resource host it runs on and executes commands on it, so it is built with e2e tag only:
+//	go test -tags e2e ./agent/console/mock/
+package mock
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/subutai-io/agent/agent/console"
+	"github.com/subutai-io/agent/agent/executer"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/gpg"
+)
+
+var control = &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{
+	TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+}}
+
+func TestAgentAgainstMockConsole(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mock-console")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	port, securePort := freePort(t), freePort(t)
+	go Serve(dir, port, securePort)
+
+	config.SetManagementIP("127.0.0.1")
+	config.Management.Port, config.Management.SecurePort = port, securePort
+
+	transport, err := console.NewHttpTransport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := console.NewConsole(transport)
+
+	waitFor(t, "mock Console to get ready", c.IsReady)
+
+	if err = c.ImportPubKey(); err != nil {
+		t.Fatal("Importing mock Console key: ", err)
+	}
+	if err = c.Register(); err != nil {
+		t.Fatal("Registering: ", err)
+	}
+	waitFor(t, "registration approval", c.IsRegistered)
+
+	//heartbeat
+	if err = c.SendHeartBeat(true); err != nil {
+		t.Fatal("Sending heartbeat: ", err)
+	}
+	var hosts []Host
+	get(t, "/mock/hosts", &hosts)
+	if len(hosts) != 1 || hosts[0].ID != gpg.GetRhFingerprint() || hosts[0].LastHeartbeat.IsZero() {
+		t.Fatalf("Mock Console has not received heartbeat of RH %s: %+v", gpg.GetRhFingerprint(), hosts)
+	}
+
+	//command execution
+	var queued map[string]string
+	post(t, "/mock/execute?host="+gpg.GetRhFingerprint(), executer.RequestOptions{
+		Command:    "echo",
+		Args:       []string{"hello"},
+		RunAs:      "root",
+		WorkingDir: "/",
+	}, &queued)
+
+	c.ExecuteConsoleCommands()
+
+	var final executer.ResponseOptions
+	waitFor(t, "command response", func() bool {
+		var responses []executer.ResponseOptions
+		get(t, "/mock/responses?command="+queued["commandId"], &responses)
+		for _, response := range responses {
+			if response.ExitCode != "" {
+				final = response
+				return true
+			}
+		}
+		return false
+	})
+	if final.ExitCode != "0" || !strings.Contains(final.StdOut, "hello") {
+		t.Fatalf("Unexpected final response %+v", final)
+	}
+}
+
+func freePort(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	return port
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	for deadline := time.Now().Add(30 * time.Second); time.Now().Before(deadline); time.Sleep(500 * time.Millisecond) {
+		if condition() {
+			return
+		}
+	}
+	t.Fatal("Timed out waiting for " + what)
+}
+
+func get(t *testing.T, endpoint string, v interface{}) {
+	resp, err := control.Get("https://127.0.0.1:" + config.Management.Port + endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func post(t *testing.T, endpoint string, body interface{}, v interface{}) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := control.Post("https://127.0.0.1:"+config.Management.Port+endpoint, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST %s: status %d", endpoint, resp.StatusCode)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
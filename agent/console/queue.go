@@ -0,0 +1,176 @@
+package console
+
+import (
+	"sync"
+	"time"
+
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/log"
+)
+
+const (
+	//number of responses of all commands kept in memory, the rest is loaded from journal when its turn comes
+	maxQueuedInMemory = 500
+	//number of responses of command loaded from journal at once
+	journalPage = 10
+	//responses are retried at least that long, even if command timeout is shorter
+	minDeliveryWindow = 10 * time.Minute
+	minRetryDelay     = time.Second
+	maxRetryDelay     = time.Minute
+)
+
+//ordered queue of responses of a single command
+type responseQueue struct {
+	//responses held in memory, in order of sending
+	responses []*db.PendingResponse
+	//number of responses following in-memory ones which are kept in journal only
+	spilled int
+	//id of last delivered response, journal is read after it
+	lastId int
+}
+
+var (
+	queues     = make(map[string]*responseQueue)
+	queuesLock sync.Mutex
+	//number of responses held in memory by all queues
+	inMemory int
+)
+
+//adds journaled response to the queue of its command and starts delivery if needed
+func enqueue(c Console, response *db.PendingResponse) {
+	queuesLock.Lock()
+	defer queuesLock.Unlock()
+
+	queue := queueOf(c, response.CommandId)
+
+	//responses after spilled ones are spilled too to keep order, response missing in journal stays in memory
+	if response.Id != 0 && (queue.spilled > 0 || inMemory >= maxQueuedInMemory) {
+		queue.spilled++
+		return
+	}
+
+	queue.responses = append(queue.responses, response)
+	inMemory++
+}
+
+//adds responses left in journal by previous agent run to the queue of their command
+func enqueueJournaled(c Console, commandID string, count int) {
+	queuesLock.Lock()
+	defer queuesLock.Unlock()
+
+	queueOf(c, commandID).spilled += count
+}
+
+//returns queue of command, new queue is created and its delivery is started if needed
+//must be called with queuesLock held
+func queueOf(c Console, commandID string) *responseQueue {
+	queue, ok := queues[commandID]
+	if !ok {
+		queue = &responseQueue{}
+		queues[commandID] = queue
+		go deliver(c, commandID, queue)
+	}
+
+	return queue
+}
+
+//returns number of responses waiting for delivery
+func pending() int {
+	queuesLock.Lock()
+	defer queuesLock.Unlock()
+
+	count := 0
+	for _, queue := range queues {
+		count += len(queue.responses) + queue.spilled
+	}
+
+	return count
+}
+
+//sends responses of command one by one, next response is sent only after Console has accepted the previous one
+func deliver(c Console, commandID string, queue *responseQueue) {
+	for {
+		queuesLock.Lock()
+		if len(queue.responses) == 0 && queue.spilled == 0 {
+			delete(queues, commandID)
+			queuesLock.Unlock()
+			return
+		}
+		if len(queue.responses) == 0 {
+			queuesLock.Unlock()
+			loadPage(commandID, queue)
+			continue
+		}
+		response := queue.responses[0]
+		queuesLock.Unlock()
+
+		deliverWithBackoff(c, response)
+
+		log.Check(log.WarnLevel, "Removing response from journal", db.RemovePendingResponse(response))
+
+		queuesLock.Lock()
+		queue.responses = queue.responses[1:]
+		inMemory--
+		if response.Id != 0 {
+			queue.lastId = response.Id
+		}
+		queuesLock.Unlock()
+	}
+}
+
+//loads next spilled responses of command from journal into memory
+//page is limited by memory left to all queues, but at least one response is loaded so that delivery goes on
+func loadPage(commandID string, queue *responseQueue) {
+	queuesLock.Lock()
+	limit := journalPage
+	if limit > maxQueuedInMemory-inMemory {
+		limit = maxQueuedInMemory - inMemory
+	}
+	if limit > queue.spilled {
+		limit = queue.spilled
+	}
+	if limit < 1 {
+		limit = 1
+	}
+	after := queue.lastId
+	queuesLock.Unlock()
+
+	page, err := db.GetPendingResponses(commandID, after, limit)
+	if log.Check(log.WarnLevel, "Loading responses of command "+commandID+" from journal", err) {
+		time.Sleep(minRetryDelay)
+		return
+	}
+
+	queuesLock.Lock()
+	defer queuesLock.Unlock()
+
+	if len(page) == 0 {
+		log.Warn("Responses of command " + commandID + " are missing in journal")
+		queue.spilled = 0
+		return
+	}
+	//journal may already contain response which is being enqueued
+	if len(page) > queue.spilled {
+		page = page[:queue.spilled]
+	}
+	for i := range page {
+		queue.responses = append(queue.responses, &page[i])
+	}
+	queue.spilled -= len(page)
+	inMemory += len(page)
+}
+
+//retries delivery with growing delay until Console accepts response or its deadline passes
+func deliverWithBackoff(c Console, response *db.PendingResponse) {
+	delay := minRetryDelay
+	for !c.deliverResponse(response) {
+		if time.Now().Add(delay).After(response.Deadline) {
+			log.Warn("Dropping response of command " + response.CommandId + " after deadline")
+			return
+		}
+		time.Sleep(delay)
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package console
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"bytes"
+
+	"github.com/subutai-io/agent/agent/executer"
+	"github.com/subutai-io/agent/agent/util"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/log"
+)
+
+// ConsoleTransport delivers agent messages to Console and fetches Console data.
+// Messages are passed already wrapped into GPG envelope, transport is responsible for delivery only.
+type ConsoleTransport interface {
+	// Ready returns true if Console is up and ready to operate
+	Ready() bool
+	// Registered returns true if Console has approved RH with passed fingerprint
+	Registered(fingerprint string) bool
+	// Register sends encrypted registration request
+	Register(msg []byte) error
+	// Heartbeat sends heartbeat and returns status code of Console reply
+	Heartbeat(msg []byte) (int, error)
+	// Requests fetches encrypted requests queued for RH with passed fingerprint
+	Requests(fingerprint string) ([]executer.EncRequest, error)
+	// Response sends command response and returns status code of Console reply
+	Response(msg []byte) (int, error)
+	// Events sends batch of events and returns status code of Console reply
+	Events(msg []byte) (int, error)
+	// PublicKey fetches Console public GPG key
+	PublicKey() ([]byte, error)
+	// Fingerprint fetches fingerprint of Console public GPG key
+	Fingerprint() (string, error)
+	// Probe checks if Console at passed host is ready and returns fingerprint of its public GPG key
+	Probe(host string) (string, error)
+	// Reset drops established connections, e.g. after Console certificate change
+	Reset() error
+}
+
+//transport talking to Console REST API over https
+//public endpoints are served on Management port, agent endpoints on Management secure port and require client certificate
+type httpTransport struct {
+	httpUtil     util.HttpUtil
+	client       *http.Client
+	secureClient *http.Client
+	lock         sync.RWMutex
+}
+
+// NewHttpTransport returns transport which talks to Console REST API at configured Management host and ports
+func NewHttpTransport() (ConsoleTransport, error) {
+	httpUtil := util.GetUtil()
+	sc, err := httpUtil.GetSecureClient(30)
+	if err != nil {
+		return nil, err
+	}
+
+	return &httpTransport{httpUtil: httpUtil, client: httpUtil.GetClient(30), secureClient: sc}, nil
+}
+
+//returns url of endpoint of active Console
+func (t *httpTransport) url(secure bool, endpoint string) string {
+	return t.urlAt(config.ManagementIP(), secure, endpoint)
+}
+
+func (t *httpTransport) urlAt(host string, secure bool, endpoint string) string {
+	port := config.Management.Port
+	if secure {
+		port = config.Management.SecurePort
+	}
+
+	return "https://" + path.Join(host) + ":" + port + endpoint
+}
+
+func (t *httpTransport) secure() *http.Client {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.secureClient
+}
+
+func (t *httpTransport) Ready() bool {
+	resp, err := t.client.Get(t.url(false, "/rest/health/ready"))
+	if err != nil {
+		return false
+	}
+	defer t.httpUtil.Close(resp)
+
+	return resp.StatusCode == http.StatusOK
+}
+
+func (t *httpTransport) Registered(fingerprint string) bool {
+	resp, err := t.secure().Get(t.url(true, "/rest/v1/agent/check/"+fingerprint))
+	if err != nil {
+		return false
+	}
+	defer t.httpUtil.Close(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+
+	//daemon API trusts only Console which has approved RH
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		log.Check(log.WarnLevel, "Pinning Console certificate", util.PinConsoleCert(config.ManagementIP(), resp.TLS.PeerCertificates[0]))
+	}
+
+	return true
+}
+
+func (t *httpTransport) Register(msg []byte) error {
+	resp, err := t.client.Post(t.url(false, "/rest/v1/registration/public-key"), "text/plain", bytes.NewBuffer(msg))
+	if err != nil {
+		return err
+	}
+	defer t.httpUtil.Close(resp)
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return errors.New("Console rejected registration request with status " + resp.Status)
+	}
+
+	return nil
+}
+
+func (t *httpTransport) Heartbeat(msg []byte) (int, error) {
+	return t.post("/rest/v1/agent/heartbeat", "heartbeat", msg)
+}
+
+func (t *httpTransport) Requests(fingerprint string) ([]executer.EncRequest, error) {
+	var requests []executer.EncRequest
+
+	resp, err := t.secure().Get(t.url(true, "/rest/v1/agent/requests/"+fingerprint))
+	if err != nil {
+		return nil, err
+	}
+	defer t.httpUtil.Close(resp)
+
+	if resp.StatusCode == http.StatusNoContent {
+		return requests, nil
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return requests, json.Unmarshal(data, &requests)
+}
+
+func (t *httpTransport) Response(msg []byte) (int, error) {
+	return t.post("/rest/v1/agent/response", "response", msg)
+}
+
+func (t *httpTransport) Events(msg []byte) (int, error) {
+	return t.post("/rest/v1/agent/events", "events", msg)
+}
+
+func (t *httpTransport) PublicKey() ([]byte, error) {
+	return t.get(config.ManagementIP(), "/rest/v1/security/keyman/getpublickeyring")
+}
+
+func (t *httpTransport) Fingerprint() (string, error) {
+	fp, err := t.get(config.ManagementIP(), "/rest/v1/security/keyman/getpublickeyfingerprint")
+
+	return string(fp), err
+}
+
+func (t *httpTransport) Probe(host string) (string, error) {
+	if _, err := t.get(host, "/rest/health/ready"); err != nil {
+		return "", err
+	}
+
+	fp, err := t.get(host, "/rest/v1/security/keyman/getpublickeyfingerprint")
+
+	return string(fp), err
+}
+
+//recreates secure client to exclude issue with SSL
+func (t *httpTransport) Reset() error {
+	sc, err := t.httpUtil.GetSecureClient(30)
+	if err != nil {
+		return err
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.secureClient.Transport.(*http.Transport).CloseIdleConnections()
+	t.secureClient = sc
+
+	return nil
+}
+
+//fetches data from public endpoint of Console at passed host
+func (t *httpTransport) get(host, endpoint string) ([]byte, error) {
+	resp, err := t.client.Get(t.urlAt(host, false, endpoint))
+	if err != nil {
+		return nil, err
+	}
+	defer t.httpUtil.Close(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("Response status %d", resp.StatusCode))
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+//posts message as form field to agent endpoint of Console
+func (t *httpTransport) post(endpoint, field string, msg []byte) (int, error) {
+	data := url.Values{field: {string(msg)}}
+	req, err := http.NewRequest("POST", t.url(true, endpoint), strings.NewReader(data.Encode()))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := t.secure().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer t.httpUtil.Close(resp)
+
+	return resp.StatusCode, nil
+}
@@ -1,10 +1,5 @@
 package console
 
-import (
-	"net/http"
-	"github.com/subutai-io/agent/agent/util"
-)
-
 // Container describes Subutai container with all required options for the Management server.
 type Container struct {
 	ID         string  `json:"id"`
@@ -18,6 +13,8 @@ type Container struct {
 	EnvId      string  `json:"environmentId,omitempty"`
 	Pk         string  `json:"publicKey,omitempty"`
 	Quota      Quota   `json:"quota,omitempty"`
+	//result of health checks, empty for containers without health check
+	Health string `json:"health,omitempty"`
 }
 
 //Quota describes container quota value.
@@ -33,10 +30,7 @@ type Iface struct {
 }
 
 type Console struct {
-	fingerprint  string
-	httpUtil     util.HttpUtil
-	client       *http.Client
-	secureClient *http.Client
+	transport ConsoleTransport
 }
 
 type rHost struct {
@@ -65,4 +59,10 @@ type heartbeat struct {
 	Arch       string      `json:"arch"`
 	Instance   string      `json:"instance"`
 	Containers []Container `json:"containers,omitempty"`
+	//sequence number, Console responds with 409 if it has missed previous heartbeat
+	Sequence int64       `json:"sequence"`
+	Added    []Container `json:"added,omitempty"`
+	Changed  []Container `json:"changed,omitempty"`
+	//ids of removed containers
+	Removed []string `json:"removed,omitempty"`
 }
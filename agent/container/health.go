@@ -0,0 +1,113 @@
+package container
+
+import (
+	"time"
+
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/log"
+)
+
+const (
+	defaultHealthInterval = 30 * time.Second
+	healthTimeout         = 10 * time.Second
+	//longest output of failed check kept in container record
+	maxHealthOutput = 512
+)
+
+// HealthChecks runs health checks of running containers on their intervals.
+// Container which failed configured number of consecutive checks is restarted as allowed by its restart policy.
+func HealthChecks() {
+	for {
+		doHealthChecks()
+		time.Sleep(time.Second * 5)
+	}
+}
+
+func doHealthChecks() {
+	for _, v := range getContainersSupposedToBeRunning() {
+		if v.HealthCheck == "" {
+			continue
+		}
+
+		//stopped container is handled by state restore, its checks start over once it is back
+		if container.State(v.Name) != container.Running {
+			if v.Health != container.HealthStarting {
+				log.Check(log.WarnLevel, "Saving container metadata", db.UpdateContainer(v.Name, func(c *db.Container) {
+					c.Health, c.HealthFailures, c.HealthOutput = container.HealthStarting, 0, ""
+				}))
+			}
+			continue
+		}
+
+		interval := v.HealthInterval
+		if interval <= 0 {
+			interval = defaultHealthInterval
+		}
+		if time.Since(v.LastHealthCheck) >= interval {
+			checkHealth(v)
+		}
+	}
+}
+
+func checkHealth(v db.Container) {
+	err := container.CheckHealth(v.Name, v.HealthCheck, healthTimeout)
+	checked := time.Now()
+
+	//only health fields are updated, v is refreshed with current record for restart decision
+	log.Check(log.WarnLevel, "Saving container metadata", db.UpdateContainer(v.Name, func(c *db.Container) {
+		c.LastHealthCheck = checked
+
+		if err == nil {
+			if c.Health == container.Unhealthy {
+				log.Info("Container " + c.Name + " is healthy again")
+			}
+			c.Health, c.HealthFailures, c.HealthOutput = container.Healthy, 0, ""
+		} else {
+			c.HealthFailures++
+			c.HealthOutput = err.Error()
+			if len(c.HealthOutput) > maxHealthOutput {
+				c.HealthOutput = c.HealthOutput[:maxHealthOutput]
+			}
+
+			//container keeps its status until it fails the number of checks allowed
+			if c.Health != container.Unhealthy && c.HealthFailures >= c.HealthRetries {
+				log.Warn("Container " + c.Name + " is unhealthy: " + c.HealthOutput)
+				c.Health = container.Unhealthy
+			}
+		}
+
+		v = *c
+	}))
+
+	if err != nil && v.HealthRetries > 0 && v.HealthFailures >= v.HealthRetries {
+		restartUnhealthy(v)
+	}
+}
+
+//restarts unhealthy container as allowed by its restart policy
+func restartUnhealthy(v db.Container) {
+	if !restartAllowed(&v) || v.State != container.Running {
+		return
+	}
+
+	log.Info("Restarting unhealthy container " + v.Name)
+
+	failure := "Health check failed: " + v.HealthOutput
+	attempted := time.Now()
+
+	restartErr := container.Restart(v.Name)
+	if restartErr != nil {
+		failure = restartErr.Error()
+		log.Warn("Failed to restart container " + v.Name + ": " + failure)
+	}
+
+	//restart updates state of container record, only restart and health fields are updated on top of it
+	log.Check(log.WarnLevel, "Saving container metadata", db.UpdateContainer(v.Name, func(c *db.Container) {
+		c.LastRestart, c.LastFailure = attempted, failure
+		if restartErr == nil {
+			c.Restarts++
+		}
+		c.Health, c.HealthFailures, c.HealthOutput = container.HealthStarting, 0, ""
+	}))
+}
@@ -1,11 +1,21 @@
 package container
 
 import (
+	"strconv"
 	"time"
 
 	"github.com/subutai-io/agent/db"
 	"github.com/subutai-io/agent/lib/container"
 	"github.com/subutai-io/agent/log"
+	"github.com/subutai-io/agent/lib/event"
+)
+
+const (
+	//delay before restart after the first one, doubled with every subsequent restart up to maxBackoff
+	initialBackoff = 30 * time.Second
+	maxBackoff     = 10 * time.Minute
+	//container running this long after restart is considered recovered and its restarts are forgotten
+	stablePeriod = 10 * time.Minute
 )
 
 func StateRestore() {
@@ -18,17 +28,107 @@ func StateRestore() {
 func doRestore() {
 	active := getContainersSupposedToBeRunning()
 
+	down := make(map[string]db.Container)
+	var names []string
 	for _, v := range active {
-		if container.State(v.Name) != container.Running {
-			log.Debug("Starting container " + v.Name)
+		if container.State(v.Name) == container.Running {
+			if v.Restarts > 0 && time.Since(v.LastRestart) > stablePeriod {
+				log.Check(log.WarnLevel, "Saving container metadata", db.UpdateContainer(v.Name, func(c *db.Container) {
+					c.Restarts = 0
+				}))
+			}
+			continue
+		}
 
-			startErr := container.Start(v.Name)
+		down[v.Name] = v
+		names = append(names, v.Name)
+	}
 
-			if startErr != nil {
-				log.Warn("Failed to start container " + v.Name + ": " + startErr.Error())
-			}
+	//e.g. after RH reboot databases are started before applications depending on them
+	for _, name := range container.StartOrder(names) {
+		restore(down[name])
+	}
+}
+
+//restarts container as allowed by its restart policy
+func restore(v db.Container) {
+	if !restartAllowed(&v) {
+		return
+	}
+
+	container.WaitDependencies(v.Name)
+
+	//container may have been stopped by user meanwhile
+	if current, err := db.FindContainerByName(v.Name); err != nil || current == nil || current.State != container.Running {
+		return
+	}
+
+	log.Debug("Starting container " + v.Name)
+
+	failure := "Container was found " + container.State(v.Name)
+	attempted := time.Now()
+
+	startErr := container.Start(v.Name)
+	if startErr != nil {
+		failure = startErr.Error()
+		log.Warn("Failed to start container " + v.Name + ": " + failure)
+	}
+
+	//start updates state of container record, only restart fields are updated on top of it,
+	//failed start is not counted as restart but delays the next attempt
+	restarts := 0
+	log.Check(log.WarnLevel, "Saving container metadata", db.UpdateContainer(v.Name, func(c *db.Container) {
+		c.LastRestart, c.LastFailure = attempted, failure
+		if startErr == nil {
+			c.Restarts++
 		}
+		restarts = c.Restarts
+	}))
+
+	if startErr == nil {
+		event.Publish(event.ContainerRestored, v.Name, map[string]string{"restarts": strconv.Itoa(restarts)})
+	}
+}
+
+//checks restart policy and backoff of container, container which exhausted its restarts is declared failed
+func restartAllowed(v *db.Container) bool {
+	policy, max, err := container.ParseRestartPolicy(v.RestartPolicy)
+	if log.Check(log.WarnLevel, "Reading restart policy of "+v.Name, err) || policy == container.RestartNever || v.Failed {
+		return false
+	}
+
+	if !v.LastRestart.IsZero() && time.Since(v.LastRestart) < backoff(v.Restarts) {
+		return false
+	}
+
+	if policy == container.RestartOnFailure && v.Restarts >= max {
+		v.Failed = true
+		log.Check(log.WarnLevel, "Saving container metadata", db.UpdateContainer(v.Name, func(c *db.Container) {
+			c.Failed = true
+		}))
+
+		log.Warn("Container " + v.Name + " failed after " + strconv.Itoa(v.Restarts) + " restarts: " + v.LastFailure)
+		event.Publish(event.ContainerFailed, v.Name, map[string]string{
+			"restarts": strconv.Itoa(v.Restarts),
+			"reason":   v.LastFailure,
+		})
+		return false
+	}
+
+	return true
+}
+
+//returns delay between restart number n and the next attempt, delay after failed start of not yet restarted container is the initial one
+func backoff(n int) time.Duration {
+	delay := initialBackoff
+	for i := 1; i < n && delay < maxBackoff; i++ {
+		delay *= 2
 	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+
+	return delay
 }
 
 func getContainersSupposedToBeRunning() []db.Container {
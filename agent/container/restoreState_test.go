@@ -0,0 +1,28 @@
+package container
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		restarts int
+		want     time.Duration
+	}{
+		//failed start of container not restarted yet
+		{0, initialBackoff},
+		{1, initialBackoff},
+		{2, 2 * initialBackoff},
+		{3, 4 * initialBackoff},
+		{5, 16 * initialBackoff},
+		{6, maxBackoff},
+		{100, maxBackoff},
+	}
+
+	for _, tt := range tests {
+		if got := backoff(tt.restarts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.restarts, got, tt.want)
+		}
+	}
+}
@@ -27,7 +27,7 @@ func (h handler) Response(message gossdp.ResponseMessage) {
 
 	log.Debug("Found server " + message.Location + "/" + message.DeviceId + "/" + message.Server)
 
-	managementHostIp := config.ManagementIP
+	managementHostIp := config.ManagementIP()
 	if managementHostIp == container.ManagementIp {
 		managementHostIp = ""
 	}
@@ -122,21 +122,14 @@ func client() {
 		time.Sleep(10 * time.Second)
 	}
 
-	//reset config.ManagementIP to enable auto rediscovery
-	if strings.TrimSpace(config.Management.Host) == "" {
+	//reset Management host address to enable auto rediscovery
+	if len(config.ManagementHosts()) == 0 {
 		log.Debug("Resetting MH IP")
-		config.ManagementIP = ""
-	} else {
-		// check if peer is accessible by config.Management.Host
-		fingerprint, err := consol.GetFingerprint()
-		if err == nil {
-			//check if fingerprint matches config.Management.Fingerprint
-			if config.Management.Fingerprint == "" || config.Management.Fingerprint == fingerprint {
-				//save ip
-				save(config.Management.Host)
-				return
-			}
-		}
+		config.SetManagementIP("")
+	} else if consol.ActiveHostHealthy() {
+		//re-register with active host, switching between configured hosts is done by Console
+		save(config.ManagementIP())
+		return
 	}
 
 	c, err := gossdp.NewSsdpClientWithLogger(handler{}, handler{})
@@ -159,19 +152,20 @@ func save(ip string) {
 
 	log.Check(log.WarnLevel, "Saving Console IP "+ip, db.SaveDiscoveredIp(ip))
 
-	config.ManagementIP = ip
+	config.SetManagementIP(ip)
 
 	log.Check(log.WarnLevel, "Importing Console key", consol.ImportPubKey())
 	log.Check(log.WarnLevel, "Sending registration request to Console", consol.Register())
 }
 
 func loadManagementIp() {
-	if strings.TrimSpace(config.Management.Host) == "" {
+	if hosts := config.ManagementHosts(); len(hosts) == 0 {
 		ip, err := db.GetDiscoveredIp()
 		if !log.Check(log.ErrorLevel, "Loading discovered Console ip from db", err) {
-			config.ManagementIP = strings.TrimSpace(ip)
+			config.SetManagementIP(strings.TrimSpace(ip))
 		}
 	} else {
-		config.ManagementIP = strings.TrimSpace(config.Management.Host)
+		//start with the most preferred host
+		config.SetManagementIP(hosts[0].Address)
 	}
 }
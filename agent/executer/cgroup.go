@@ -0,0 +1,266 @@
+package executer
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"os/exec"
+	"regexp"
+	"syscall"
+
+	"github.com/subutai-io/agent/log"
+)
+
+const (
+	// OomResponse is a type of final response of command killed by OOM killer due to its memory limit
+	OomResponse = "EXECUTE_OOM"
+
+	cgroupParent = "subutai"
+	cfsPeriod    = 100000
+)
+
+var cgroupRoot = "/sys/fs/cgroup"
+
+//command ids are UUIDs generated by Console
+var commandIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+//transient cgroup limiting resources of a single host command
+type cgroup struct {
+	//controller -> cgroup directory, all controllers share one directory on cgroup v2
+	dirs map[string]string
+	//cgroup v2 hierarchy
+	unified bool
+}
+
+//returns true if host mounts unified cgroup v2 hierarchy
+func unifiedCgroups() bool {
+	_, err := os.Stat(path.Join(cgroupRoot, "cgroup.controllers"))
+	return err == nil
+}
+
+//creates cgroups with limits requested for command
+//returns nil if request has no limits
+func newCgroup(req RequestOptions) (*cgroup, error) {
+	if req.CpuQuota <= 0 && req.MemoryLimit <= 0 && req.IoWeight <= 0 {
+		return nil, nil
+	}
+
+	if req.IoWeight > 0 && (req.IoWeight < 10 || req.IoWeight > 1000) {
+		return nil, errors.New("IO weight must be in range 10-1000")
+	}
+
+	//command id becomes name of cgroup directory
+	if !commandIDPattern.MatchString(req.CommandID) {
+		return nil, errors.New("Invalid command id " + req.CommandID)
+	}
+
+	cg := &cgroup{dirs: make(map[string]string), unified: unifiedCgroups()}
+
+	if req.CpuQuota > 0 {
+		//quota is percentage of total host CPU, the same way as container CPU quota
+		quota := strconv.Itoa(cfsPeriod * runtime.NumCPU() * req.CpuQuota / 100)
+		limits := map[string]string{"cpu.cfs_period_us": strconv.Itoa(cfsPeriod), "cpu.cfs_quota_us": quota}
+		if cg.unified {
+			limits = map[string]string{"cpu.max": quota + " " + strconv.Itoa(cfsPeriod)}
+		}
+		if err := cg.set("cpu", req.CommandID, limits); err != nil {
+			cg.remove()
+			return nil, err
+		}
+	}
+
+	if req.MemoryLimit > 0 {
+		limits := map[string]string{"memory.limit_in_bytes": strconv.Itoa(req.MemoryLimit) + "M"}
+		if cg.unified {
+			limits = map[string]string{"memory.max": strconv.Itoa(req.MemoryLimit) + "M"}
+		}
+		if err := cg.set("memory", req.CommandID, limits); err != nil {
+			cg.remove()
+			return nil, err
+		}
+	}
+
+	if req.IoWeight > 0 {
+		controller, limits := "blkio", map[string]string{"blkio.weight": strconv.Itoa(req.IoWeight)}
+		if cg.unified {
+			//io.weight range 1-10000 includes blkio.weight range, default weight is 100 in both
+			controller, limits = "io", map[string]string{"io.weight": "default " + strconv.Itoa(req.IoWeight)}
+		}
+		if err := cg.set(controller, req.CommandID, limits); err != nil {
+			cg.remove()
+			return nil, err
+		}
+	}
+
+	return cg, nil
+}
+
+//creates cgroup of controller and writes limits into it
+func (cg *cgroup) set(controller, commandID string, limits map[string]string) error {
+	dir := path.Join(cgroupRoot, controller, cgroupParent, commandID)
+	if cg.unified {
+		dir = path.Join(cgroupRoot, cgroupParent, commandID)
+		if err := enableController(controller); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.New("Failed to create " + controller + " cgroup: " + err.Error())
+	}
+	cg.dirs[controller] = dir
+
+	for file, value := range limits {
+		if err := ioutil.WriteFile(path.Join(dir, file), []byte(value), 0644); err != nil {
+			return errors.New("Failed to set " + file + ": " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+//makes cgroup v2 controller available to command cgroups, limit files are missing otherwise
+func enableController(controller string) error {
+	available, err := ioutil.ReadFile(path.Join(cgroupRoot, "cgroup.controllers"))
+	if err != nil {
+		return err
+	}
+	if !contains(strings.Fields(string(available)), controller) {
+		return errors.New("Controller " + controller + " is not available on this host")
+	}
+
+	for _, dir := range []string{cgroupRoot, path.Join(cgroupRoot, cgroupParent)} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return errors.New("Failed to create cgroup " + dir + ": " + err.Error())
+		}
+		enabled, err := ioutil.ReadFile(path.Join(dir, "cgroup.subtree_control"))
+		if err != nil {
+			return err
+		}
+		if contains(strings.Fields(string(enabled)), controller) {
+			continue
+		}
+		if err := ioutil.WriteFile(path.Join(dir, "cgroup.subtree_control"), []byte("+"+controller), 0644); err != nil {
+			return errors.New("Failed to enable " + controller + " controller in " + dir + ": " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// start starts command stopped right after exec and resumes it once it is moved into command cgroups,
+// so that neither command nor anything it forks runs outside of limits.
+// Command is killed if it can not be moved into cgroups.
+func (cg *cgroup) start(cmd *exec.Cmd) error {
+	//traced process can be detached only by the thread which has started it
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	cmd.SysProcAttr.Ptrace = true
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	pid := cmd.Process.Pid
+
+	var status syscall.WaitStatus
+	_, err := syscall.Wait4(pid, &status, 0, nil)
+	if err == nil && !status.Stopped() {
+		err = errors.New("Command exited before resource limits were applied")
+	}
+	if err == nil {
+		err = cg.add(pid)
+	}
+	if err == nil {
+		err = syscall.PtraceDetach(pid)
+	}
+
+	if err != nil {
+		log.Check(log.DebugLevel, "Killing command outside of resource limits", cmd.Process.Kill())
+		cmd.Wait()
+		return err
+	}
+
+	return nil
+}
+
+//moves process into command cgroups
+func (cg *cgroup) add(pid int) error {
+	for _, dir := range cg.paths() {
+		if err := ioutil.WriteFile(path.Join(dir, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return errors.New("Failed to add process to cgroup " + dir + ": " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+//returns distinct cgroup directories of command
+func (cg *cgroup) paths() []string {
+	var dirs []string
+	for _, dir := range cg.dirs {
+		if !contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+//returns true if OOM killer has killed any process of command
+//killed tells whether command has ended by SIGKILL, used when kernel does not count OOM kills
+func (cg *cgroup) oomKilled(killed bool) bool {
+	dir, ok := cg.dirs["memory"]
+	if !ok {
+		return false
+	}
+
+	//cgroup v2 reports oom_kill counter in memory.events
+	events := "memory.oom_control"
+	if cg.unified {
+		events = "memory.events"
+	}
+	file, err := os.Open(path.Join(dir, events))
+	if log.Check(log.DebugLevel, "Reading OOM state of command", err) {
+		return false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			count, err := strconv.Atoi(fields[1])
+			return err == nil && count > 0
+		}
+	}
+
+	//older kernels do not report oom_kill counter, limit hits together with SIGKILL are the only hint
+	if !killed || cg.unified {
+		return false
+	}
+	failcnt, err := ioutil.ReadFile(path.Join(dir, "memory.failcnt"))
+	if err != nil {
+		return false
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(string(failcnt)))
+	return err == nil && count > 0
+}
+
+//removes command cgroups, fails silently if processes are still there
+func (cg *cgroup) remove() {
+	for _, dir := range cg.paths() {
+		log.Check(log.DebugLevel, "Removing cgroup "+dir, os.Remove(dir))
+	}
+}
@@ -0,0 +1,109 @@
+package executer
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestNewCgroup(t *testing.T) {
+	defer func(root string) { cgroupRoot = root }(cgroupRoot)
+
+	req := RequestOptions{CommandID: "cmd-1", CpuQuota: 50, MemoryLimit: 64, IoWeight: 200}
+	quota := strconv.Itoa(cfsPeriod * runtime.NumCPU() / 2)
+
+	tests := []struct {
+		name    string
+		unified bool
+		dirs    int
+		want    map[string]string
+	}{
+		{"cgroup v1", false, 3, map[string]string{
+			"cpu/subutai/cmd-1/cpu.cfs_period_us":        "100000",
+			"cpu/subutai/cmd-1/cpu.cfs_quota_us":         quota,
+			"memory/subutai/cmd-1/memory.limit_in_bytes": "64M",
+			"blkio/subutai/cmd-1/blkio.weight":           "200",
+		}},
+		{"cgroup v2", true, 1, map[string]string{
+			"cgroup.subtree_control":         "+io",
+			"subutai/cgroup.subtree_control": "+io",
+			"subutai/cmd-1/cpu.max":          quota + " 100000",
+			"subutai/cmd-1/memory.max":       "64M",
+			"subutai/cmd-1/io.weight":        "default 200",
+		}},
+	}
+
+	for _, test := range tests {
+		root, err := ioutil.TempDir("", "cgroup")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(root)
+		cgroupRoot = root
+
+		if test.unified {
+			//cpu and memory are enabled for children already, io is not
+			os.MkdirAll(path.Join(root, cgroupParent), 0755)
+			ioutil.WriteFile(path.Join(root, "cgroup.controllers"), []byte("cpuset cpu io memory pids\n"), 0644)
+			ioutil.WriteFile(path.Join(root, "cgroup.subtree_control"), []byte("cpu memory\n"), 0644)
+			ioutil.WriteFile(path.Join(root, cgroupParent, "cgroup.subtree_control"), []byte("cpu memory\n"), 0644)
+		}
+
+		cg, err := newCgroup(req)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if cg.unified != test.unified {
+			t.Errorf("%s: unified %v", test.name, cg.unified)
+		}
+		for file, want := range test.want {
+			got, err := ioutil.ReadFile(path.Join(root, file))
+			if err != nil {
+				t.Errorf("%s: %v", test.name, err)
+			} else if string(got) != want {
+				t.Errorf("%s: %s is %q, want %q", test.name, file, got, want)
+			}
+		}
+		if len(cg.paths()) != test.dirs {
+			t.Errorf("%s: cgroup directories %v", test.name, cg.paths())
+		}
+	}
+}
+
+func TestNewCgroupUnavailableController(t *testing.T) {
+	defer func(root string) { cgroupRoot = root }(cgroupRoot)
+
+	root, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	cgroupRoot = root
+	ioutil.WriteFile(path.Join(root, "cgroup.controllers"), []byte("cpu memory\n"), 0644)
+
+	if _, err := newCgroup(RequestOptions{CommandID: "cmd-1", IoWeight: 200}); err == nil {
+		t.Error("expected error for io controller missing on host")
+	}
+}
+
+func TestNewCgroupInvalid(t *testing.T) {
+	tests := []RequestOptions{
+		{CommandID: "../etc", CpuQuota: 50},
+		{CommandID: "cmd-1", IoWeight: 5},
+		{CommandID: "cmd-1", IoWeight: 2000},
+	}
+
+	for _, req := range tests {
+		if _, err := newCgroup(req); err == nil {
+			t.Errorf("expected error for %+v", req)
+		}
+	}
+
+	if cg, err := newCgroup(RequestOptions{CommandID: "cmd-1"}); cg != nil || err != nil {
+		t.Errorf("request without limits: %v, %v", cg, err)
+	}
+}
@@ -0,0 +1,73 @@
+package executer
+
+import (
+	"os/user"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/log"
+)
+
+//search path of commands run on Resource host
+const hostPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin:/snap/bin"
+
+//returns minimal environment commands run on Resource host start with, requested variables are added to it
+func hostEnv(usr *user.User) []string {
+	return []string{
+		"PATH=" + hostPath,
+		"HOME=" + usr.HomeDir,
+		"USER=" + usr.Username,
+		"LOGNAME=" + usr.Username,
+		"SHELL=/bin/bash",
+	}
+}
+
+//returns environment variables of request allowed by agent policy, in KEY=VALUE form
+func requestedEnv(req RequestOptions) []string {
+	var env []string
+
+	whitelist := patterns(config.Agent.EnvWhitelist)
+	blacklist := patterns(config.Agent.EnvBlacklist)
+
+	//sorted to make resulting environment predictable
+	var names []string
+	for name := range req.Environment {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == "" || strings.ContainsAny(name, "=\x00") {
+			log.Warn("Command " + req.CommandID + ": skipping invalid environment variable name " + name)
+			continue
+		}
+		if (len(whitelist) > 0 && !matches(name, whitelist)) || matches(name, blacklist) {
+			log.Warn("Command " + req.CommandID + ": environment variable " + name + " is not allowed by agent policy")
+			continue
+		}
+		env = append(env, name+"="+req.Environment[name])
+	}
+
+	return env
+}
+
+func patterns(list string) []string {
+	var result []string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+func matches(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, err := path.Match(p, name); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package executer
+
+import (
+	"os/user"
+	"reflect"
+	"testing"
+
+	"github.com/subutai-io/agent/config"
+)
+
+func TestRequestedEnv(t *testing.T) {
+	defer func(whitelist, blacklist string) {
+		config.Agent.EnvWhitelist, config.Agent.EnvBlacklist = whitelist, blacklist
+	}(config.Agent.EnvWhitelist, config.Agent.EnvBlacklist)
+
+	env := map[string]string{
+		"PATH":       "/bin",
+		"LD_PRELOAD": "evil.so",
+		"APP_MODE":   "prod",
+		"APP_DEBUG":  "1",
+		"a=b":        "invalid",
+		"":           "invalid",
+	}
+
+	tests := []struct {
+		name      string
+		whitelist string
+		blacklist string
+		want      []string
+	}{
+		{"empty whitelist allows any valid name", "", "",
+			[]string{"APP_DEBUG=1", "APP_MODE=prod", "LD_PRELOAD=evil.so", "PATH=/bin"}},
+		{"blacklist", "", "LD_PRELOAD,LD_LIBRARY_PATH",
+			[]string{"APP_DEBUG=1", "APP_MODE=prod", "PATH=/bin"}},
+		{"blacklist pattern", "", "LD_*, APP_DEBUG",
+			[]string{"APP_MODE=prod", "PATH=/bin"}},
+		{"whitelist pattern", "APP_*", "",
+			[]string{"APP_DEBUG=1", "APP_MODE=prod"}},
+		{"blacklist wins over whitelist", "APP_*,PATH", "APP_DEBUG",
+			[]string{"APP_MODE=prod", "PATH=/bin"}},
+		{"whitelist matches whole name", "APP", "",
+			nil},
+		{"invalid pattern matches nothing", "[", "",
+			nil},
+	}
+
+	for _, tt := range tests {
+		config.Agent.EnvWhitelist, config.Agent.EnvBlacklist = tt.whitelist, tt.blacklist
+
+		if got := requestedEnv(RequestOptions{CommandID: "test", Environment: env}); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: requestedEnv() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHostEnv(t *testing.T) {
+	usr := &user.User{Username: "subutai", HomeDir: "/home/subutai"}
+
+	env := hostEnv(usr)
+	want := []string{"PATH=" + hostPath, "HOME=/home/subutai", "USER=subutai", "LOGNAME=subutai", "SHELL=/bin/bash"}
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("got %v, want %v", env, want)
+	}
+}
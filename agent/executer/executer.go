@@ -22,33 +22,86 @@ import (
 	"github.com/subutai-io/agent/lib/gpg"
 	"path"
 	"encoding/json"
+	"github.com/subutai-io/agent/db"
+	"errors"
+	"github.com/subutai-io/agent/lib/event"
+	"github.com/subutai-io/agent/lib/audit"
 )
 
-func Execute(rsp EncRequest, responseCallback func(msg []byte, deadline time.Time), contName string) {
+func Execute(rsp EncRequest, responseCallback func(commandID string, msg []byte, deadline time.Time), contName string) {
 	var req Request
-	var md, pub, keyring string
+	var md string
+	var signed time.Time
+	var err error
 
-	if rsp.HostID == gpg.GetRhFingerprint() {
-		md = gpg.DecryptWrapper(rsp.Request)
+	//only requests signed by Console are accepted
+	if gpg.IsRhFingerprint(rsp.HostID) {
+		md, signed, err = gpg.DecryptVerified(rsp.Request, config.Management.GpgUser)
 	} else {
 
 		if contName == "" {
 			return
 		}
 
-		pub = path.Join(config.Agent.LxcPrefix, contName, "public.pub")
-		keyring = path.Join(config.Agent.LxcPrefix, contName, "secret.sec")
+		pub := path.Join(config.Agent.LxcPrefix, contName, "public.pub")
+		keyring := path.Join(config.Agent.LxcPrefix, contName, "secret.sec")
 		log.Info("Getting public keyring", "keyring", keyring)
-		md = gpg.DecryptWrapper(rsp.Request, keyring, pub)
+		md, signed, err = gpg.DecryptVerified(rsp.Request, config.Management.GpgUser, keyring, pub)
 	}
 
-	if log.Check(log.WarnLevel, "Decrypting request", json.Unmarshal([]byte(md), &req.Request)) {
+	if err != nil {
+		reject(rsp.HostID, "", contName, "Decrypting request: "+err.Error())
+		return
+	}
+
+	if err = json.Unmarshal([]byte(md), &req.Request); err != nil {
+		reject(rsp.HostID, "", contName, "Parsing request: "+err.Error())
 		return
 	}
 
+	if reason := checkReplay(req.Request, signed); reason != "" {
+		reject(rsp.HostID, req.Request.CommandID, contName, reason)
+		return
+	}
+
+	//responses of host commands are encrypted with RH key
+	if gpg.IsRhFingerprint(rsp.HostID) {
+		contName = ""
+	}
+
+	if req.Request.Type != TerminateRequest {
+		log.Check(log.WarnLevel, "Journaling command "+req.Request.CommandID, db.SaveCommand(&db.Command{
+			CommandId: req.Request.CommandID,
+			HostId:    req.Request.ID,
+			Container: contName,
+			Timeout:   req.Request.Timeout,
+			Started:   time.Now(),
+		}))
+		defer func() {
+			log.Check(log.WarnLevel, "Removing command "+req.Request.CommandID+" from journal", db.RemoveCommand(req.Request.CommandID))
+		}()
+	}
+
+	what, args := auditCommand(req.Request.Command, req.Request.Args)
+	if req.Request.Type == TerminateRequest {
+		what = "terminate"
+	}
+	exitCode := ""
+	done := audit.Start("command:"+req.Request.CommandID, what, args, contName)
+	defer func() {
+		//accepted terminate request has no response of its own, terminated command reports its end
+		if exitCode == "0" || (req.Request.Type == TerminateRequest && exitCode == "") {
+			done(nil)
+		} else {
+			done(errors.New("Exit code " + exitCode))
+		}
+	}()
+
 	//create channels for stdout and stderr
 	sOut := make(chan ResponseOptions)
-	if rsp.HostID == gpg.GetRhFingerprint() {
+	if req.Request.Type == TerminateRequest {
+		go terminate(req.Request, sOut)
+	} else if contName == "" {
 		go execInHost(req.Request, sOut)
 	} else {
 		go execInContainer(contName, req.Request, sOut)
@@ -56,20 +109,16 @@ func Execute(rsp EncRequest, responseCallback func(msg []byte, deadline time.Tim
 
 	for sOut != nil {
 		if elem, ok := <-sOut; ok {
-			resp := Response{ResponseOpts: elem}
-			jsonR, err := json.Marshal(resp)
-			log.Check(log.WarnLevel, "Marshal response", err)
-
-			var payload []byte
-			if rsp.HostID == gpg.GetRhFingerprint() {
-				payload, err = gpg.EncryptWrapper(config.Agent.GpgUser, config.Management.GpgUser, jsonR)
-			} else {
-				payload, err = gpg.EncryptWrapper(contName, config.Management.GpgUser, jsonR, pub, keyring)
+			message, err := buildMessage(elem, contName)
+			if !log.Check(log.WarnLevel, "Preparing response "+elem.CommandID, err) {
+				responseCallback(elem.CommandID, message, time.Now().Add(time.Second*time.Duration(req.Request.Timeout)))
+			}
+			if elem.ExitCode != "" {
+				exitCode = elem.ExitCode
 			}
-			if err == nil && len(payload) > 0 {
-				message, err := json.Marshal(map[string]string{"hostId": elem.ID, "response": string(payload)})
-				log.Check(log.WarnLevel, "Marshal response json "+elem.CommandID, err)
-				go responseCallback(message, time.Now().Add(time.Second*time.Duration(req.Request.Timeout)))
+			//final response is handed over, command needs no reporting after agent restart
+			if elem.ExitCode != "" && req.Request.Type != TerminateRequest {
+				log.Check(log.WarnLevel, "Removing command "+elem.CommandID+" from journal", db.RemoveCommand(elem.CommandID))
 			}
 		} else {
 			sOut = nil
@@ -78,11 +127,98 @@ func Execute(rsp EncRequest, responseCallback func(msg []byte, deadline time.Tim
 
 }
 
+//returns reason to reject request signed at passed time, empty string if request is fresh
+//request is remembered, so that its replay is refused
+func checkReplay(req RequestOptions, signed time.Time) string {
+	window := time.Duration(config.Management.RequestWindow) * time.Second
+	keep := 24 * time.Hour
+
+	if window > 0 {
+		//allow the same clock skew in both directions
+		if age := time.Since(signed); age > window || age < -window {
+			return "Request signed at " + signed.Format(time.RFC3339) + " is outside of " + window.String() + " window"
+		}
+		keep = 2 * window
+	}
+
+	if req.CommandID == "" {
+		return "Request has no command id"
+	}
+
+	fresh, err := db.SaveReceivedRequest(req.Type+":"+req.CommandID, keep)
+	if err != nil {
+		return "Checking request for replay: " + err.Error()
+	}
+	if !fresh {
+		return "Request " + req.CommandID + " has been already received"
+	}
+
+	return ""
+}
+
+//logs rejected request and reports it to Console as an audit event
+func reject(hostID, commandID, contName, reason string) {
+	log.Warn("Rejected request " + commandID + " for " + hostID + ": " + reason)
+
+	event.Publish(event.RequestRejected, contName, map[string]string{
+		"hostId":    hostID,
+		"commandId": commandID,
+		"reason":    reason,
+	})
+
+	audit.Start("command:"+commandID, "request", nil, contName)(errors.New("Rejected: " + reason))
+}
+
+//encrypts response for Console and wraps it into message accepted by Console
+//empty contName means the response originates from Resource host
+func buildMessage(response ResponseOptions, contName string) ([]byte, error) {
+	jsonR, err := json.Marshal(Response{ResponseOpts: response})
+	if err != nil {
+		return nil, err
+	}
+
+	var payload []byte
+	if contName == "" {
+		payload, err = gpg.EncryptWrapper(config.Agent.GpgUser, config.Management.GpgUser, jsonR)
+	} else {
+		pub := path.Join(config.Agent.LxcPrefix, contName, "public.pub")
+		keyring := path.Join(config.Agent.LxcPrefix, contName, "secret.sec")
+		payload, err = gpg.EncryptWrapper(contName, config.Management.GpgUser, jsonR, pub, keyring)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) == 0 {
+		return nil, errors.New("Empty encrypted response")
+	}
+
+	return json.Marshal(map[string]string{"hostId": response.ID, "response": string(payload)})
+}
+
 // execInHost executes request inside Resource host
 // and sends output as response.
 func execInHost(req RequestOptions, outCh chan<- ResponseOptions) {
 	defer close(outCh)
 
+	if err := checkMode(req); err != nil {
+		outCh <- failedResponse(req, err)
+		return
+	}
+
+	if err := writeHostFiles(req); err != nil {
+		outCh <- failedResponse(req, err)
+		return
+	}
+
+	cg, err := newCgroup(req)
+	if err != nil {
+		outCh <- failedResponse(req, err)
+		return
+	}
+	if cg != nil {
+		defer cg.remove()
+	}
+
 	cmd := buildCmd(&req)
 
 	if cmd == nil {
@@ -102,16 +238,33 @@ func execInHost(req RequestOptions, outCh chan<- ResponseOptions) {
 
 	cmd.Stdout = wop
 	cmd.Stderr = wep
+	if req.StdIn != "" {
+		cmd.Stdin = strings.NewReader(req.StdIn)
+	}
 	if req.IsDaemon == 1 {
 		cmd.SysProcAttr = &syscall.SysProcAttr{}
 		cmd.SysProcAttr.Setpgid = true
 		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: 0, Gid: 0}
 	}
 
-	err = cmd.Start()
+	if cg != nil {
+		//command must not run without requested limits
+		if err = cg.start(cmd); err != nil {
+			wop.Close()
+			wep.Close()
+			outCh <- failedResponse(req, errors.New("Applying resource limits: "+err.Error()))
+			return
+		}
+	} else {
+		err = cmd.Start()
+	}
 
 	log.Check(log.WarnLevel, "Executing command: "+req.CommandID+" "+req.Command+" "+strings.Join(req.Args, " "), err)
 
+	if err == nil {
+		register(req.CommandID, cmd.Process.Pid, true)
+	}
+
 	log.Check(log.DebugLevel, "Closing standard output", wop.Close())
 	log.Check(log.DebugLevel, "Closing error output", wep.Close())
 
@@ -136,7 +289,14 @@ func execInHost(req RequestOptions, outCh chan<- ResponseOptions) {
 		wg.Wait()
 		response.ExitCode = "0"
 		if req.IsDaemon != 1 && cmd.ProcessState != nil {
-			response.ExitCode = strconv.Itoa(cmd.ProcessState.Sys().(syscall.WaitStatus).ExitStatus())
+			status := cmd.ProcessState.Sys().(syscall.WaitStatus)
+			response.ExitCode = strconv.Itoa(status.ExitStatus())
+			if cg != nil && cg.oomKilled(status.ExitStatus() == 137 || (status.Signaled() && status.Signal() == syscall.SIGKILL)) {
+				response.Type = OomResponse
+			}
+		}
+		if unregister(req.CommandID) {
+			response.Type = TerminatedResponse
 		}
 		outCh <- response
 	case <-time.After(time.Duration(req.Timeout) * time.Second):
@@ -144,6 +304,7 @@ func execInHost(req RequestOptions, outCh chan<- ResponseOptions) {
 			response.ExitCode = "0"
 			outCh <- response
 			<-done
+			unregister(req.CommandID)
 		} else {
 			log.Check(log.DebugLevel, "Killing process by timeout", cmd.Process.Kill())
 			response.Type = "EXECUTE_TIMEOUT"
@@ -154,6 +315,9 @@ func execInHost(req RequestOptions, outCh chan<- ResponseOptions) {
 			} else {
 				response.ExitCode = "-1"
 			}
+			if unregister(req.CommandID) {
+				response.Type = TerminatedResponse
+			}
 			outCh <- response
 		}
 	}
@@ -226,21 +390,32 @@ func buildCmd(r *RequestOptions) *exec.Cmd {
 	gid32 := *(*uint32)(unsafe.Pointer(&gid))
 	uid32 := *(*uint32)(unsafe.Pointer(&uid))
 
-	var buff bytes.Buffer
-	_, err = buff.WriteString(r.Command + " ")
-	if err != nil {
-		return nil
-	}
-	for _, arg := range r.Args {
-		_, err = buff.WriteString("\"" + arg + "\" ")
+	var cmd *exec.Cmd
+	if r.Mode == ExecMode {
+		cmd = exec.Command(r.Command, r.Args...)
+	} else {
+		var buff bytes.Buffer
+		_, err = buff.WriteString(r.Command + " ")
 		if err != nil {
 			return nil
 		}
+		for _, arg := range r.Args {
+			_, err = buff.WriteString("\"" + arg + "\" ")
+			if err != nil {
+				return nil
+			}
+		}
+		cmd = exec.Command("/bin/bash", "-c", buff.String())
 	}
-	cmd := exec.Command("/bin/bash", "-c", buff.String())
 	cmd.Dir = r.WorkingDir
+	//command does not inherit agent's own environment, the same as commands in containers
+	cmd.Env = append(hostEnv(usr), requestedEnv(*r)...)
+	//subutai CLI called by the command attributes audit entries to it
+	cmd.Env = append(cmd.Env, audit.CommandIDEnv+"="+r.CommandID)
 	cmd.SysProcAttr = &syscall.SysProcAttr{}
 	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uid32, Gid: gid32}
+	//own process group allows to terminate the command together with its children
+	cmd.SysProcAttr.Setpgid = true
 
 	return cmd
 }
@@ -255,17 +430,44 @@ func genericResponse(req RequestOptions) ResponseOptions {
 	}
 }
 
+//checks that execution mode of request is supported
+func checkMode(req RequestOptions) error {
+	if req.Mode != "" && req.Mode != ShellMode && req.Mode != ExecMode {
+		return errors.New("Unsupported execution mode " + req.Mode)
+	}
+	return nil
+}
+
+//prepare final response of command which could not be started
+func failedResponse(req RequestOptions, err error) ResponseOptions {
+	log.Warn("Command " + req.CommandID + " failed: " + err.Error())
+	response := genericResponse(req)
+	response.StdErr = err.Error()
+	response.ExitCode = "1"
+	return response
+}
+
 // execInContainer executes request inside Container host
 // and sends output as response.
 func execInContainer(name string, req RequestOptions, outCh chan<- ResponseOptions) error {
 	defer close(outCh)
 
+	if err := checkMode(req); err != nil {
+		outCh <- failedResponse(req, err)
+		return err
+	}
+
 	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
 	if err != nil {
 		return err
 	}
 	defer lxc.Release(c)
 
+	if err := writeContainerFiles(c, req); err != nil {
+		outCh <- failedResponse(req, err)
+		return err
+	}
+
 	rop, wop, err := os.Pipe()
 	if err != nil {
 		return err
@@ -285,25 +487,59 @@ func execInContainer(name string, req RequestOptions, outCh chan<- ResponseOptio
 	opts.Cwd = req.WorkingDir
 	opts.EnvToKeep = []string{"TERM", "USER", "LS_COLORS"}
 	opts.ClearEnv = true
+	opts.Env = requestedEnv(req)
+
+	var rip, wip *os.File
+	if req.StdIn != "" {
+		if rip, wip, err = os.Pipe(); err != nil {
+			return err
+		}
+		defer rip.Close()
+		opts.StdinFd = rip.Fd()
+	}
 
 	var exitCode int
-	var cmd bytes.Buffer
+	args := []string{"timeout", strconv.Itoa(req.Timeout)}
+	if req.Mode == ExecMode {
+		args = append(args, req.Command)
+		args = append(args, req.Args...)
+	} else {
+		var cmd bytes.Buffer
 
-	_, err = cmd.WriteString(req.Command)
-	if err != nil {
-		return err
-	}
-	for _, a := range req.Args {
-		_, err = cmd.WriteString(a + " ")
+		_, err = cmd.WriteString(req.Command)
 		if err != nil {
 			return err
 		}
+		for _, a := range req.Args {
+			_, err = cmd.WriteString(a + " ")
+			if err != nil {
+				return err
+			}
+		}
+		args = append(args, "/bin/bash", "-c", cmd.String())
 	}
 
-	log.Debug("Executing command in container " + name + ":" + cmd.String())
+	log.Debug("Executing command in container " + name + ":" + strings.Join(args[2:], " "))
+	pid, err := c.RunCommandNoWait(args, opts)
+	log.Check(log.DebugLevel, "Executing command inside container", err)
+	if err == nil {
+		register(req.CommandID, pid, false)
+	}
+	if wip != nil {
+		go func() {
+			_, err := wip.WriteString(req.StdIn)
+			log.Check(log.DebugLevel, "Writing standard input", err)
+			log.Check(log.DebugLevel, "Closing standard input", wip.Close())
+		}()
+	}
+	done := make(chan bool)
 	go func() {
-		exitCode, err = c.RunCommandStatus([]string{"timeout", strconv.Itoa(req.Timeout), "/bin/bash", "-c", cmd.String()}, opts)
-		log.Check(log.DebugLevel, "Executing command inside container", err)
+		defer close(done)
+		if err == nil {
+			exitCode = waitPid(pid)
+		} else {
+			exitCode = -1
+		}
 		log.Check(log.DebugLevel, "Closing standard output", wop.Close())
 		log.Check(log.DebugLevel, "Closing error output", wep.Close())
 	}()
@@ -315,11 +551,15 @@ func execInContainer(name string, req RequestOptions, outCh chan<- ResponseOptio
 
 	var response = genericResponse(req)
 	outputSender(stdout, stderr, outCh, &response)
+	<-done
 	if exitCode == 0 {
 		response.Type = "EXECUTE_RESPONSE"
 	} else if exitCode == 124 {
 		response.Type = "EXECUTE_TIMEOUT"
 	}
+	if unregister(req.CommandID) {
+		response.Type = TerminatedResponse
+	}
 	response.ExitCode = strconv.Itoa(exitCode)
 
 	outCh <- response
@@ -327,6 +567,28 @@ func execInContainer(name string, req RequestOptions, outCh chan<- ResponseOptio
 	return nil
 }
 
+//waits for process attached to container and returns its exit code
+func waitPid(pid int) int {
+	proc, err := os.FindProcess(pid)
+	if log.Check(log.DebugLevel, "Looking process by pid "+strconv.Itoa(pid), err) {
+		return -1
+	}
+
+	state, err := proc.Wait()
+	if log.Check(log.DebugLevel, "Waiting for process completion", err) {
+		return -1
+	}
+
+	if status, ok := state.Sys().(syscall.WaitStatus); ok {
+		if status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return status.ExitStatus()
+	}
+
+	return -1
+}
+
 // Credentials returns information about IDs from container. This informations is user for command execution only.
 func credentials(name, container string) (uid int, gid int) {
 	thePath := path.Join(config.Agent.LxcPrefix, container, "/rootfs/etc/passwd")
@@ -357,3 +619,27 @@ func parsePasswd(path, name string) (uid string, gid string) {
 	}
 	return "", ""
 }
+
+//shell tokens ending subutai CLI command line
+var shellOperators = map[string]bool{";": true, "&&": true, "||": true, "|": true, "&": true, ">": true, ">>": true, "<": true}
+
+// auditCommand returns command and arguments of Console request for audit,
+// secrets passed to subutai CLI anywhere in command line are masked the same way as in CLI audit entries
+func auditCommand(command string, args []string) (string, []string) {
+	words := strings.Fields(command)
+	tokens := append(append([]string{}, words...), args...)
+
+	for i := 0; i < len(tokens); i++ {
+		if path.Base(strings.Trim(tokens[i], "'\"")) != "subutai" {
+			continue
+		}
+		end := i + 1
+		for end < len(tokens) && !shellOperators[tokens[end]] {
+			end++
+		}
+		copy(tokens[i+1:end], audit.Mask(tokens[i+1:end]))
+		i = end
+	}
+
+	return strings.Join(tokens[:len(words)], " "), tokens[len(words):]
+}
@@ -0,0 +1,216 @@
+package executer
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+	"strings"
+
+	"gopkg.in/lxc/go-lxc.v2"
+
+	"github.com/subutai-io/agent/log"
+)
+
+const defaultFileMode = "0644"
+
+//decodes file content and permissions passed in request
+func parseFile(file File, workDir string) (thePath string, mode os.FileMode, content []byte, err error) {
+	if file.Path == "" {
+		return "", 0, nil, errors.New("Empty file path")
+	}
+
+	thePath = file.Path
+	if !path.IsAbs(thePath) {
+		thePath = path.Join(workDir, thePath)
+	}
+
+	if file.Mode == "" {
+		file.Mode = defaultFileMode
+	}
+	perm, err := strconv.ParseUint(file.Mode, 8, 32)
+	if err != nil || perm > 07777 {
+		return "", 0, nil, errors.New("Invalid file mode " + file.Mode)
+	}
+
+	content, err = base64.StdEncoding.DecodeString(file.Content)
+	if err != nil {
+		return "", 0, nil, errors.New("Invalid file content: " + err.Error())
+	}
+
+	return thePath, fileMode(perm), content, nil
+}
+
+//converts octal permissions to os.FileMode, which keeps setuid, setgid and sticky bits apart from permission bits
+func fileMode(perm uint64) os.FileMode {
+	mode := os.FileMode(perm & 0777)
+	if perm&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if perm&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if perm&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+
+	return mode
+}
+
+//converts os.FileMode back to octal permissions accepted by chmod
+func octalMode(mode os.FileMode) string {
+	perm := uint64(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		perm |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		perm |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		perm |= 01000
+	}
+
+	return strconv.FormatUint(perm, 8)
+}
+
+//writes files passed in request to Resource host
+func writeHostFiles(req RequestOptions) error {
+	for _, file := range req.Files {
+		if err := writeHostFile(file, req.WorkingDir); err != nil {
+			return errors.New("Failed to write file " + file.Path + ": " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+func writeHostFile(file File, workDir string) error {
+	thePath, mode, content, err := parseFile(file, workDir)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(path.Dir(thePath), 0755); err != nil {
+		return err
+	}
+
+	if err = ioutil.WriteFile(thePath, content, mode); err != nil {
+		return err
+	}
+
+	//WriteFile does not change permissions of existing file
+	if err = os.Chmod(thePath, mode); err != nil {
+		return err
+	}
+
+	if file.Owner == "" {
+		return nil
+	}
+
+	uid, gid, err := lookupOwner(file.Owner)
+	if err != nil {
+		return err
+	}
+
+	return os.Chown(thePath, uid, gid)
+}
+
+//resolves "user" or "user:group" to numeric ids on Resource host
+func lookupOwner(owner string) (uid int, gid int, err error) {
+	parts := strings.SplitN(owner, ":", 2)
+
+	usr, err := user.Lookup(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	if uid, err = strconv.Atoi(usr.Uid); err != nil {
+		return 0, 0, err
+	}
+	if gid, err = strconv.Atoi(usr.Gid); err != nil {
+		return 0, 0, err
+	}
+
+	if len(parts) == 2 && parts[1] != "" {
+		grp, err := user.LookupGroup(parts[1])
+		if err != nil {
+			return 0, 0, err
+		}
+		if gid, err = strconv.Atoi(grp.Gid); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return uid, gid, nil
+}
+
+//writes files passed in request inside container
+func writeContainerFiles(c *lxc.Container, req RequestOptions) error {
+	for _, file := range req.Files {
+		if err := writeContainerFile(c, file, req.WorkingDir); err != nil {
+			return errors.New("Failed to write file " + file.Path + ": " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+//file is streamed to shell running as root inside container, so that ownership is resolved by container itself
+func writeContainerFile(c *lxc.Container, file File, workDir string) error {
+	thePath, mode, content, err := parseFile(file, workDir)
+	if err != nil {
+		return err
+	}
+
+	script := `mkdir -p "$(dirname "$1")" && cat > "$1" && chmod "$2" "$1"`
+	args := []string{"/bin/bash", "-c", script, "write-file", thePath, octalMode(mode)}
+	if file.Owner != "" {
+		args[2] = script + ` && chown "$3" "$1"`
+		args = append(args, file.Owner)
+	}
+
+	rip, wip, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer rip.Close()
+
+	rep, wep, err := os.Pipe()
+	if err != nil {
+		wip.Close()
+		return err
+	}
+	defer rep.Close()
+
+	opts := lxc.DefaultAttachOptions
+	opts.UID, opts.GID = 0, 0
+	opts.StdinFd = rip.Fd()
+	opts.StderrFd = wep.Fd()
+	opts.ClearEnv = true
+
+	pid, err := c.RunCommandNoWait(args, opts)
+	log.Check(log.DebugLevel, "Closing error output", wep.Close())
+	if err != nil {
+		wip.Close()
+		return err
+	}
+
+	go func() {
+		_, err := wip.Write(content)
+		log.Check(log.DebugLevel, "Streaming file content to container", err)
+		log.Check(log.DebugLevel, "Closing file content stream", wip.Close())
+	}()
+
+	stderr, _ := ioutil.ReadAll(rep)
+	if exitCode := waitPid(pid); exitCode != 0 {
+		msg := strings.TrimSpace(string(stderr))
+		if msg == "" {
+			msg = "exit code " + strconv.Itoa(exitCode)
+		}
+		return errors.New(msg)
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package executer
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseFileMode(t *testing.T) {
+	tests := []struct {
+		mode    string
+		want    os.FileMode
+		octal   string
+		invalid bool
+	}{
+		{"", 0644, "644", false},
+		{"0600", 0600, "600", false},
+		{"755", 0755, "755", false},
+		{"04755", os.ModeSetuid | 0755, "4755", false},
+		{"2750", os.ModeSetgid | 0750, "2750", false},
+		{"1777", os.ModeSticky | 0777, "1777", false},
+		{"07777", os.ModeSetuid | os.ModeSetgid | os.ModeSticky | 0777, "7777", false},
+		{"10777", 0, "", true},
+		{"0999", 0, "", true},
+		{"rwx", 0, "", true},
+	}
+
+	for _, test := range tests {
+		thePath, mode, _, err := parseFile(File{Path: "file", Mode: test.mode}, "/tmp")
+		if test.invalid {
+			if err == nil {
+				t.Errorf("mode %q: expected error, got %v", test.mode, mode)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("mode %q: %v", test.mode, err)
+			continue
+		}
+		if thePath != "/tmp/file" {
+			t.Errorf("mode %q: path %s, want /tmp/file", test.mode, thePath)
+		}
+		if mode != test.want {
+			t.Errorf("mode %q: got %v, want %v", test.mode, mode, test.want)
+		}
+		if octal := octalMode(mode); octal != test.octal {
+			t.Errorf("mode %q: octal %s, want %s", test.mode, octal, test.octal)
+		}
+	}
+}
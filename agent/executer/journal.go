@@ -0,0 +1,121 @@
+package executer
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/log"
+)
+
+const (
+	lostOutputMessage = "Agent was restarted while command was running, command output is lost"
+	notStartedMessage = "Agent was restarted before command was started"
+)
+
+//commands journaled after this moment belong to the current agent run
+var agentStarted = time.Now()
+
+// Reattach reports commands which were in-flight when agent stopped.
+// Still running processes are tracked until exit (or timeout) and can be terminated by Console,
+// final responses of finished ones are sent right away.
+func Reattach(responseCallback func(commandID string, msg []byte, deadline time.Time)) {
+	commands, err := db.GetAllCommands()
+	if log.Check(log.WarnLevel, "Reading command journal", err) {
+		return
+	}
+
+	for _, cmd := range commands {
+		if cmd.Started.After(agentStarted) || isRunning(cmd.CommandId) {
+			continue
+		}
+		log.Info("Found orphaned command " + cmd.CommandId + " in journal")
+		go reattach(cmd, responseCallback)
+	}
+}
+
+func reattach(cmd db.Command, responseCallback func(commandID string, msg []byte, deadline time.Time)) {
+	defer func() {
+		log.Check(log.WarnLevel, "Removing command "+cmd.CommandId+" from journal", db.RemoveCommand(cmd.CommandId))
+	}()
+
+	response := ResponseOptions{
+		Type:           "EXECUTE_RESPONSE",
+		CommandID:      cmd.CommandId,
+		ID:             cmd.HostId,
+		Pid:            cmd.Pid,
+		ResponseNumber: 1,
+		ExitCode:       "-1",
+		StdErr:         lostOutputMessage,
+	}
+
+	deadline := cmd.Started.Add(time.Duration(cmd.Timeout) * time.Second)
+
+	if cmd.Pid == 0 {
+		response.StdErr = notStartedMessage
+	} else if alive(cmd.Pid, cmd.PidStart) {
+		//host commands are started in own process group
+		register(cmd.CommandId, cmd.Pid, cmd.Container == "")
+
+		for alive(cmd.Pid, cmd.PidStart) && time.Now().Before(deadline) {
+			time.Sleep(time.Second)
+		}
+
+		if alive(cmd.Pid, cmd.PidStart) {
+			log.Info("Killing orphaned command " + cmd.CommandId + " by timeout")
+			c := command{pid: cmd.Pid, group: cmd.Container == ""}
+			log.Check(log.DebugLevel, "Killing process by timeout", c.signal(syscall.SIGKILL))
+			response.Type = "EXECUTE_TIMEOUT"
+		}
+
+		if unregister(cmd.CommandId) {
+			response.Type = TerminatedResponse
+		}
+	}
+
+	message, err := buildMessage(response, cmd.Container)
+	if log.Check(log.WarnLevel, "Preparing response "+cmd.CommandId, err) {
+		return
+	}
+
+	//Console may still wait for the response if it has not timed out yet
+	if deadline.Before(time.Now()) {
+		deadline = time.Now().Add(time.Minute)
+	}
+
+	responseCallback(cmd.CommandId, message, deadline)
+}
+
+//returns true if process with passed pid exists and it is the same process which was journaled
+func alive(pid int, start uint64) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+
+	return start == 0 || procStartTime(pid) == start
+}
+
+//returns process start time in clock ticks since boot, 0 if it can not be read
+func procStartTime(pid int) uint64 {
+	stat, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0
+	}
+
+	//command name may contain spaces, so fields are counted after its closing bracket
+	line := string(stat)
+	fields := strings.Fields(line[strings.LastIndex(line, ")")+1:])
+	if len(fields) < 20 {
+		return 0
+	}
+
+	start, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return start
+}
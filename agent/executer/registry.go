@@ -0,0 +1,118 @@
+package executer
+
+import (
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/log"
+)
+
+const (
+	// TerminateRequest is a type of request which aborts previously started command with the same CommandID
+	TerminateRequest = "TERMINATE_REQUEST"
+	// TerminatedResponse is a type of final response of command aborted by TerminateRequest
+	TerminatedResponse = "EXECUTE_TERMINATED"
+
+	//time given to a process to exit after SIGTERM before it gets SIGKILL
+	killGracePeriod = time.Second * 10
+)
+
+// command describes process started on behalf of Console request
+type command struct {
+	pid int
+	//host commands are started in own process group, so that the whole group can be killed
+	group      bool
+	terminated bool
+}
+
+var (
+	commands     = make(map[string]*command)
+	commandsLock sync.Mutex
+)
+
+//registers started command in the registry of in-flight commands
+func register(commandID string, pid int, group bool) {
+	commandsLock.Lock()
+	defer commandsLock.Unlock()
+
+	commands[commandID] = &command{pid: pid, group: group}
+
+	log.Check(log.WarnLevel, "Journaling pid of command "+commandID, db.SetCommandPid(commandID, pid, procStartTime(pid)))
+}
+
+//removes command from the registry
+//returns true if command was terminated by Console request
+func unregister(commandID string) bool {
+	commandsLock.Lock()
+	defer commandsLock.Unlock()
+
+	cmd, ok := commands[commandID]
+	if !ok {
+		return false
+	}
+	delete(commands, commandID)
+
+	return cmd.terminated
+}
+
+//returns true if command with passed id is in-flight
+func isRunning(commandID string) bool {
+	commandsLock.Lock()
+	defer commandsLock.Unlock()
+
+	_, ok := commands[commandID]
+
+	return ok
+}
+
+// Terminate sends SIGTERM to the in-flight command and SIGKILL if it does not exit within grace period.
+// Returns false if command with passed id is not running.
+func Terminate(commandID string) bool {
+	commandsLock.Lock()
+	cmd, ok := commands[commandID]
+	if ok {
+		cmd.terminated = true
+	}
+	commandsLock.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	log.Info("Terminating command " + commandID + ", pid " + strconv.Itoa(cmd.pid))
+	log.Check(log.WarnLevel, "Sending SIGTERM to command "+commandID, cmd.signal(syscall.SIGTERM))
+
+	time.AfterFunc(killGracePeriod, func() {
+		if isRunning(commandID) {
+			log.Check(log.WarnLevel, "Sending SIGKILL to command "+commandID, cmd.signal(syscall.SIGKILL))
+		}
+	})
+
+	return true
+}
+
+func (c *command) signal(sig syscall.Signal) error {
+	if c.group {
+		return syscall.Kill(-c.pid, sig)
+	}
+	return syscall.Kill(c.pid, sig)
+}
+
+//handles TerminateRequest
+//if command is running, its final response will be of type EXECUTE_TERMINATED,
+//otherwise error response is sent back
+func terminate(req RequestOptions, outCh chan<- ResponseOptions) {
+	defer close(outCh)
+
+	if Terminate(req.CommandID) {
+		return
+	}
+
+	response := genericResponse(req)
+	response.StdErr = "Command " + req.CommandID + " is not running"
+	response.ExitCode = "1"
+	outCh <- response
+}
@@ -0,0 +1,262 @@
+package executer
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/gorilla/websocket"
+	"gopkg.in/lxc/go-lxc.v2"
+
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/log"
+	"github.com/subutai-io/agent/lib/audit"
+	"net"
+)
+
+// SessionRequest is the first message sent by client over the session websocket.
+// Empty Container means that session is opened on the Resource host.
+type SessionRequest struct {
+	Container string   `json:"container"`
+	Command   []string `json:"command"`
+	RunAs     string   `json:"runAs"`
+	Cols      uint16   `json:"cols"`
+	Rows      uint16   `json:"rows"`
+}
+
+// SessionControl is a text message exchanged over the session websocket.
+// Client sends "resize" messages, agent sends single "exit" message when the session process ends.
+// Binary messages carry raw terminal input and output.
+type SessionControl struct {
+	Type     string `json:"type"`
+	Cols     uint16 `json:"cols,omitempty"`
+	Rows     uint16 `json:"rows,omitempty"`
+	ExitCode int    `json:"exitCode"`
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin: func(r *http.Request) bool {
+		//callers are authenticated by client certificate
+		return true
+	},
+}
+
+// SessionHandler upgrades request to websocket and runs interactive terminal session
+// on the Resource host or inside container
+func SessionHandler(rw http.ResponseWriter, request *http.Request) {
+	ws, err := upgrader.Upgrade(rw, request, nil)
+	if log.Check(log.WarnLevel, "Upgrading session connection", err) {
+		return
+	}
+	defer ws.Close()
+
+	var req SessionRequest
+	if log.Check(log.WarnLevel, "Reading session request", ws.ReadJSON(&req)) {
+		return
+	}
+	if len(req.Command) == 0 {
+		req.Command = []string{"/bin/bash", "-l"}
+	}
+	if req.RunAs == "" {
+		req.RunAs = "root"
+	}
+	if req.Cols == 0 || req.Rows == 0 {
+		req.Cols, req.Rows = 80, 24
+	}
+
+	log.Info("Opening terminal session from " + request.RemoteAddr + " to " + sessionTarget(req.Container))
+
+	//sessions are audited when opened and when closed, close entry carries session duration
+	peer, _, _ := net.SplitHostPort(request.RemoteAddr)
+	_, command := auditCommand("", req.Command)
+	closed := audit.Start("remote:"+peer, "session close", command, req.Container)
+
+	var ptmx *os.File
+	var pid int
+	if req.Container == "" {
+		ptmx, pid, err = startHostSession(req)
+	} else {
+		ptmx, pid, err = startContainerSession(req)
+	}
+	audit.Start("remote:"+peer, "session open", command, req.Container)(err)
+	if err != nil {
+		log.Warn("Starting terminal session: " + err.Error())
+		ws.WriteJSON(SessionControl{Type: "exit", ExitCode: -1})
+		return
+	}
+	defer ptmx.Close()
+
+	session := &session{ws: ws, ptmx: ptmx}
+
+	session.outWg.Add(1)
+	go session.input()
+	go session.output()
+
+	exitCode := waitPid(pid)
+	session.wait()
+
+	log.Info("Terminal session to " + sessionTarget(req.Container) + " ended with exit code " + strconv.Itoa(exitCode))
+	if exitCode == 0 {
+		closed(nil)
+	} else {
+		closed(errors.New("Exit code " + strconv.Itoa(exitCode)))
+	}
+
+	session.writeLock.Lock()
+	defer session.writeLock.Unlock()
+	ws.WriteJSON(SessionControl{Type: "exit", ExitCode: exitCode})
+	ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+}
+
+type session struct {
+	ws        *websocket.Conn
+	ptmx      *os.File
+	writeLock sync.Mutex
+	outWg     sync.WaitGroup
+}
+
+//forwards client input and resize events to the terminal
+func (s *session) input() {
+	for {
+		msgType, data, err := s.ws.ReadMessage()
+		if err != nil {
+			//client has gone, hang up the terminal
+			s.ptmx.Close()
+			return
+		}
+		switch msgType {
+		case websocket.BinaryMessage:
+			if _, err := s.ptmx.Write(data); err != nil {
+				return
+			}
+		case websocket.TextMessage:
+			var ctl SessionControl
+			if log.Check(log.DebugLevel, "Parsing session control message", json.Unmarshal(data, &ctl)) {
+				continue
+			}
+			if ctl.Type == "resize" && ctl.Cols > 0 && ctl.Rows > 0 {
+				log.Check(log.DebugLevel, "Resizing terminal",
+					pty.Setsize(s.ptmx, &pty.Winsize{Cols: ctl.Cols, Rows: ctl.Rows}))
+			}
+		}
+	}
+}
+
+//forwards terminal output to the client
+func (s *session) output() {
+	defer s.outWg.Done()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := s.ptmx.Read(buf)
+		if n > 0 {
+			s.writeLock.Lock()
+			werr := s.ws.WriteMessage(websocket.BinaryMessage, buf[:n])
+			s.writeLock.Unlock()
+			if werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+//waits until remaining terminal output is flushed to the client
+func (s *session) wait() {
+	done := make(chan bool)
+	go func() {
+		s.outWg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+	}
+}
+
+func startHostSession(req SessionRequest) (*os.File, int, error) {
+	usr, err := user.Lookup(req.RunAs)
+	if err != nil {
+		return nil, 0, err
+	}
+	uid, err := strconv.Atoi(usr.Uid)
+	if err != nil {
+		return nil, 0, err
+	}
+	gid, err := strconv.Atoi(usr.Gid)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cmd := exec.Command(req.Command[0], req.Command[1:]...)
+	cmd.Dir = usr.HomeDir
+	cmd.Env = append(hostEnv(usr), "TERM=xterm")
+
+	ptmx, err := pty.StartWithAttrs(cmd, &pty.Winsize{Cols: req.Cols, Rows: req.Rows}, &syscall.SysProcAttr{
+		Setsid:     true,
+		Setctty:    true,
+		Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)},
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return ptmx, cmd.Process.Pid, nil
+}
+
+func startContainerSession(req SessionRequest) (*os.File, int, error) {
+	if container.State(req.Container) != container.Running {
+		return nil, 0, errors.New("Container " + req.Container + " is not running")
+	}
+
+	c, err := lxc.NewContainer(req.Container, config.Agent.LxcPrefix)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer lxc.Release(c)
+
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tty.Close()
+
+	log.Check(log.DebugLevel, "Setting terminal size", pty.Setsize(ptmx, &pty.Winsize{Cols: req.Cols, Rows: req.Rows}))
+
+	opts := lxc.DefaultAttachOptions
+	opts.UID, opts.GID = credentials(req.RunAs, req.Container)
+	opts.StdinFd = tty.Fd()
+	opts.StdoutFd = tty.Fd()
+	opts.StderrFd = tty.Fd()
+	opts.ClearEnv = true
+	opts.Env = []string{"TERM=xterm", "USER=" + req.RunAs, "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"}
+
+	//setsid makes the terminal controlling one, so that job control works inside the session
+	pid, err := c.RunCommandNoWait(append([]string{"setsid", "-c"}, req.Command...), opts)
+	if err != nil {
+		ptmx.Close()
+		return nil, 0, err
+	}
+
+	return ptmx, pid, nil
+}
+
+func sessionTarget(name string) string {
+	if name == "" {
+		return "Resource host"
+	}
+	return "container " + name
+}
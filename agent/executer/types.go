@@ -26,6 +26,31 @@ type RequestOptions struct {
 	RunAs       string            `json:"runAs"`
 	Timeout     int               `json:"timeout"`
 	IsDaemon    int               `json:"isDaemon"`
+	StdIn       string            `json:"stdIn"`
+	Mode        string            `json:"mode"`
+	//percentage of total host CPU, applies to Resource host commands only
+	CpuQuota int `json:"cpuQuota"`
+	//megabytes, applies to Resource host commands only
+	MemoryLimit int `json:"memoryLimit"`
+	//blkio weight in range 10-1000, applies to Resource host commands only
+	IoWeight int `json:"ioWeight"`
+	Files       []File            `json:"files"`
+}
+
+const (
+	// ShellMode runs Command together with Args as a bash script, default mode
+	ShellMode = "shell"
+	// ExecMode runs Command directly with Args passed as separate arguments, no shell is involved
+	ExecMode = "exec"
+)
+
+// File describes file which is written to the target host before command execution.
+// Content is base64 encoded, Mode is octal permission string (e.g. "0644"), Owner is "user" or "user:group".
+type File struct {
+	Path    string `json:"path"`
+	Mode    string `json:"mode"`
+	Owner   string `json:"owner"`
+	Content string `json:"content"`
 }
 
 // Response is a encapsulation for ResponseOptions required by the Management server.
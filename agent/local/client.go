@@ -0,0 +1,117 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/subutai-io/agent/agent/vars"
+)
+
+var client = &http.Client{
+	Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", vars.DAEMON_SOCKET)
+		},
+	},
+}
+
+// Available returns true if local API of running daemon can be used by current process
+func Available() bool {
+	//process started by daemon itself would deadlock waiting for serialized command
+	if os.Getenv(vars.LOCAL_API_CHILD_ENV) != "" || vars.IsDaemon {
+		return false
+	}
+
+	resp, err := client.Get("http://unix/ping")
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode == http.StatusOK
+}
+
+// Command executes single CLI command through daemon
+func Command(action string, args ...string) (CommandResult, error) {
+	var result CommandResult
+	err := post("/command", Line{Action: action, Args: args}, &result)
+	return result, err
+}
+
+// Batch executes lines through daemon one by one, stops on first failed command
+func Batch(lines []Line) ([]Result, error) {
+	var results []Result
+	err := post("/batch", lines, &results)
+	return results, err
+}
+
+// Heartbeat asks daemon to send heartbeat to Console
+func Heartbeat() error {
+	return post("/heartbeat", nil, nil)
+}
+
+// PendingResponses returns number of command responses daemon has not yet delivered to Console
+func PendingResponses() (int, error) {
+	resp, err := client.Get("http://unix/pending")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.New(fmt.Sprintf("Response status %d", resp.StatusCode))
+	}
+
+	var result map[string]int
+	err = json.NewDecoder(resp.Body).Decode(&result)
+
+	return result["pending"], err
+}
+
+// ManagementHost returns address of Management host daemon currently talks to
+func ManagementHost() (string, error) {
+	resp, err := client.Get("http://unix/management")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(fmt.Sprintf("Response status %d", resp.StatusCode))
+	}
+
+	var result map[string]string
+	err = json.NewDecoder(resp.Body).Decode(&result)
+
+	return result["management"], err
+}
+
+func post(endpoint string, request interface{}, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post("http://unix"+endpoint, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return errors.New(fmt.Sprintf("Response status %d", resp.StatusCode))
+	}
+
+	if response == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(response)
+}
@@ -0,0 +1,336 @@
+// Package local provides control API of subutai daemon exposed over Unix socket.
+// CLI commands sent through the API are serialized by the daemon by their targets: commands on the same container
+// never overlap and commands without a target run exclusively, so callers do not race each other on lock files.
+// Each command still runs in a child subutai process, since CLI commands exit the process on error and write
+// to process-wide output. Children open agent database and take lock files the same way as commands run
+// directly, which keeps them coordinated with commands not sent through the daemon.
+package local
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/subutai-io/agent/agent/vars"
+	"github.com/subutai-io/agent/log"
+)
+
+var (
+	mux map[string]func(http.ResponseWriter, *http.Request)
+	//commands without targets are exclusive, commands with targets share it
+	commandLock sync.RWMutex
+	//serialize commands with the same target, e.g. container or template name
+	targetLocks     = make(map[string]*sync.Mutex)
+	targetLocksLock sync.Mutex
+	heartbeat       func()
+	pending         func() int
+	management      func() string
+)
+
+type myHandler struct{}
+
+func (*myHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h, ok := mux[r.URL.String()]; ok {
+		h(w, r)
+		return
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+}
+
+// Serve starts serving local API on Unix socket
+// heartbeatFunc is invoked when client asks daemon to send heartbeat to Console
+// pendingFunc returns number of command responses not yet delivered to Console
+// managementFunc returns address of Management host daemon currently talks to
+func Serve(heartbeatFunc func(), pendingFunc func() int, managementFunc func() string) {
+	heartbeat = heartbeatFunc
+	pending = pendingFunc
+	management = managementFunc
+
+	//socket left by previous daemon run prevents listening
+	if _, err := os.Stat(vars.DAEMON_SOCKET); err == nil {
+		log.Check(log.WarnLevel, "Removing stale socket "+vars.DAEMON_SOCKET, os.Remove(vars.DAEMON_SOCKET))
+	}
+
+	listener, err := net.Listen("unix", vars.DAEMON_SOCKET)
+	if log.Check(log.WarnLevel, "Listening on "+vars.DAEMON_SOCKET, err) {
+		return
+	}
+
+	//only root is allowed to run subutai commands
+	log.Check(log.WarnLevel, "Restricting access to "+vars.DAEMON_SOCKET, os.Chmod(vars.DAEMON_SOCKET, 0600))
+
+	mux = make(map[string]func(http.ResponseWriter, *http.Request))
+	mux["/ping"] = pingHandler
+	mux["/command"] = commandHandler
+	mux["/batch"] = batchHandler
+	mux["/heartbeat"] = heartbeatHandler
+	mux["/pending"] = pendingHandler
+	mux["/management"] = managementHandler
+
+	srv := &http.Server{
+		ReadHeaderTimeout: 15 * time.Second,
+		Handler:           &myHandler{},
+	}
+
+	log.Check(log.WarnLevel, "Serving local API", srv.Serve(listener))
+}
+
+func pingHandler(rw http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func heartbeatHandler(rw http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	rw.WriteHeader(http.StatusAccepted)
+	if heartbeat != nil {
+		go heartbeat()
+	}
+}
+
+func pendingHandler(rw http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	count := 0
+	if pending != nil {
+		count = pending()
+	}
+
+	writeJSON(rw, map[string]int{"pending": count})
+}
+
+func managementHandler(rw http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	host := ""
+	if management != nil {
+		host = management()
+	}
+
+	writeJSON(rw, map[string]string{"management": host})
+}
+
+func commandHandler(rw http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var line Line
+	if err := json.NewDecoder(request.Body).Decode(&line); err != nil || line.Action == "" {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if !known(line.Action) {
+		rw.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	_, result := run(line)
+	writeJSON(rw, result)
+}
+
+//executes lines one by one, stops on first failed command
+func batchHandler(rw http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var lines []Line
+	if err := json.NewDecoder(request.Body).Decode(&lines); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	for _, line := range lines {
+		if !known(line.Action) {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+	}
+
+	writeJSON(rw, RunBatch(lines))
+}
+
+// RunBatch executes lines one by one in the current process context, stops on first failed command
+func RunBatch(lines []Line) []Result {
+	var results []Result
+
+	for _, line := range lines {
+		result, _ := run(line)
+		results = append(results, result)
+		if result.ExitCode != "0" {
+			break
+		}
+	}
+
+	return results
+}
+
+// Actions are names of CLI commands accepted by the API, any command is accepted if empty
+var Actions []string
+
+//returns true if action is a CLI command
+func known(action string) bool {
+	if len(Actions) == 0 {
+		return true
+	}
+	for _, a := range Actions {
+		if a == action {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Targets returns resources locked while command line runs, e.g. containers named by its arguments or flags,
+// and whether command only reads state. Command line runs exclusively if Targets is not set or fails to parse it.
+var Targets func(args []string) (targets []string, readOnly bool, err error)
+
+//locks resources of command line, returns function releasing them
+//targets are taken from parsed command line, so that e.g. clone of foo and destroy of foo do not overlap,
+//while long import of one template does not block commands on other containers
+func lock(line Line) func() {
+	var targets []string
+	if Targets != nil {
+		var readOnly bool
+		var err error
+		targets, readOnly, err = Targets(append([]string{line.Action}, line.Args...))
+		if err != nil {
+			targets = nil
+		} else if readOnly {
+			return func() {}
+		}
+	}
+
+	if len(targets) == 0 {
+		commandLock.Lock()
+		return commandLock.Unlock
+	}
+
+	//locks are taken in the same order by all commands
+	sort.Strings(targets)
+
+	commandLock.RLock()
+	var locks []*sync.Mutex
+	for i, target := range targets {
+		if i > 0 && target == targets[i-1] {
+			continue
+		}
+		targetLocksLock.Lock()
+		l, ok := targetLocks[target]
+		if !ok {
+			l = new(sync.Mutex)
+			targetLocks[target] = l
+		}
+		targetLocksLock.Unlock()
+
+		l.Lock()
+		locks = append(locks, l)
+	}
+
+	return func() {
+		for _, l := range locks {
+			l.Unlock()
+		}
+		commandLock.RUnlock()
+	}
+}
+
+//executes single CLI command as a child process, which may exit on error without affecting daemon
+//returns result with combined output and result with output streams kept apart
+//heartbeat is sent once command is over, since commands started by daemon do not trigger it themselves
+func run(line Line) (Result, CommandResult) {
+	unlock := lock(line)
+	defer unlock()
+
+	if heartbeat != nil {
+		defer func() { go heartbeat() }()
+	}
+
+	binary, err := os.Executable()
+	if err != nil {
+		binary = "subutai"
+	}
+
+	cmd := exec.Command(binary, append([]string{line.Action}, line.Args...)...)
+	//commands started by daemon must not call back into it
+	cmd.Env = append(os.Environ(), vars.LOCAL_API_CHILD_ENV+"=1")
+
+	var combined bytes.Buffer
+	var combinedLock sync.Mutex
+	stdout := &teeBuffer{shared: &combined, lock: &combinedLock}
+	stderr := &teeBuffer{shared: &combined, lock: &combinedLock}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	err = cmd.Run()
+
+	exitCode := "0"
+	if err != nil {
+		exitCode = "1"
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+				exitCode = strconv.Itoa(status.ExitStatus())
+			}
+		} else {
+			stderr.Write([]byte(err.Error()))
+		}
+	}
+
+	return Result{Output: combined.String(), ExitCode: exitCode},
+		CommandResult{Stdout: stdout.own.String(), Stderr: stderr.own.String(), ExitCode: exitCode}
+}
+
+//collects output stream and writes it into buffer shared with another stream
+type teeBuffer struct {
+	own    bytes.Buffer
+	shared *bytes.Buffer
+	lock   *sync.Mutex
+}
+
+func (t *teeBuffer) Write(p []byte) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.shared.Write(p)
+
+	return t.own.Write(p)
+}
+
+func writeJSON(rw http.ResponseWriter, value interface{}) {
+	data, err := json.Marshal(value)
+	if log.Check(log.WarnLevel, "Marshalling local API response", err) {
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(data)
+}
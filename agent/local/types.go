@@ -0,0 +1,20 @@
+package local
+
+// Line describes a single Subutai CLI command, e.g. {"action":"clone","args":["debian-stretch","foo"]}
+type Line struct {
+	Action string   `json:"action"`
+	Args   []string `json:"args"`
+}
+
+// Result describes output of a single Subutai CLI command
+type Result struct {
+	Output   string `json:"output"`
+	ExitCode string `json:"exitcode"`
+}
+
+// CommandResult describes output of a single Subutai CLI command executed through daemon with output streams kept apart
+type CommandResult struct {
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode string `json:"exitcode"`
+}
@@ -0,0 +1,68 @@
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket limiter keyed by client, e.g. by client IP and route
+type RateLimiter struct {
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	lock    sync.Mutex
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	//set once request is dropped, reset by the next allowed request
+	throttled bool
+}
+
+// NewRateLimiter returns limiter allowing rate requests per second with bursts of burst requests
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*bucket)}
+}
+
+// Allow takes token from bucket of passed key.
+// first is true when request is the first one dropped since bucket got exhausted,
+// so that callers can report throttled client once instead of on every request.
+func (l *RateLimiter) Allow(key string) (allowed bool, first bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst}
+		l.buckets[key] = b
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * l.rate
+		if b.tokens > l.burst {
+			b.tokens = l.burst
+		}
+	}
+	b.last = now
+
+	//drop buckets of clients which are quiet long enough to have full bucket
+	if len(l.buckets) > 1024 {
+		for k, v := range l.buckets {
+			if now.Sub(v.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, k)
+			}
+		}
+	}
+
+	if b.tokens < 1 {
+		first = !b.throttled
+		b.throttled = true
+		return false, first
+	}
+
+	b.tokens--
+	b.throttled = false
+
+	return true, false
+}
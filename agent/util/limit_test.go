@@ -0,0 +1,52 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	type step struct {
+		key string
+		//time passed before request
+		wait    time.Duration
+		allowed bool
+		first   bool
+	}
+
+	tests := []struct {
+		name  string
+		rate  float64
+		burst int
+		steps []step
+	}{
+		{"burst is allowed, then the first drop is reported once", 0, 2, []step{
+			{"a", 0, true, false},
+			{"a", 0, true, false},
+			{"a", 0, false, true},
+			{"a", 0, false, false},
+		}},
+		{"keys have own buckets", 0, 1, []step{
+			{"a", 0, true, false},
+			{"a", 0, false, true},
+			{"b", 0, true, false},
+			{"b", 0, false, true},
+		}},
+		{"bucket refills over time up to burst", 100, 1, []step{
+			{"a", 0, true, false},
+			{"a", 0, false, true},
+			{"a", 50 * time.Millisecond, true, false},
+			{"a", 0, false, true},
+		}},
+	}
+
+	for _, tt := range tests {
+		limiter := NewRateLimiter(tt.rate, tt.burst)
+		for i, s := range tt.steps {
+			time.Sleep(s.wait)
+			if allowed, first := limiter.Allow(s.key); allowed != s.allowed || first != s.first {
+				t.Errorf("%s: request %d of %s allowed %t, first %t, want %t, %t", tt.name, i, s.key, allowed, first, s.allowed, s.first)
+			}
+		}
+	}
+}
@@ -17,6 +17,10 @@ import (
 	"encoding/pem"
 	"crypto/rand"
 	"github.com/subutai-io/agent/config"
+	"sync"
+	"crypto/tls"
+	"errors"
+	"github.com/subutai-io/agent/db"
 )
 
 var sslPath = path.Join(config.Agent.DataPrefix, "ssl")
@@ -130,3 +134,137 @@ func generateCertNKey() error {
 
 	return nil
 }
+
+var (
+	//pinned Console certificates by Management host
+	consoleCerts    = make(map[string]*x509.Certificate)
+	consoleCertLock sync.Mutex
+)
+
+// GetConsoleCert returns certificate of active Console pinned when Console approved RH registration.
+// Certificate is never fetched from the network, so that nobody answering on Management address can impersonate Console.
+func GetConsoleCert() (*x509.Certificate, error) {
+	consoleCertLock.Lock()
+	defer consoleCertLock.Unlock()
+
+	host := config.ManagementIP()
+	if cert, ok := consoleCerts[host]; ok {
+		return cert, nil
+	}
+
+	raw, err := db.GetConsoleCert(host)
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, errors.New("Certificate of Console at " + host + " is not pinned, RH has to be registered first")
+	}
+
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		return nil, err
+	}
+	consoleCerts[host] = cert
+
+	return cert, nil
+}
+
+// PinConsoleCert stores certificate presented by Console at passed host, once it has approved RH registration.
+// Pinned certificate is never replaced, error is returned if Console presents certificate with another key.
+// After Console certificate rotation pin has to be reset by UnpinConsoleCert (subutai keys unpin).
+func PinConsoleCert(host string, cert *x509.Certificate) error {
+	consoleCertLock.Lock()
+	defer consoleCertLock.Unlock()
+
+	raw, err := db.GetConsoleCert(host)
+	if err != nil {
+		return err
+	}
+
+	if raw != nil {
+		pinned, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		if !sameKey(pinned, cert) {
+			return errors.New("Console at " + host + " presents certificate which differs from the pinned one, " +
+				"run `subutai keys unpin " + host + "` if Console certificate was replaced")
+		}
+		return nil
+	}
+
+	log.Info("Pinning certificate of Console at " + host)
+
+	if err = db.SaveConsoleCert(host, cert.Raw); err != nil {
+		return err
+	}
+	consoleCerts[host] = cert
+
+	return nil
+}
+
+// UnpinConsoleCert forgets certificate pinned for Console at passed host, or for all hosts if host is empty.
+// Certificate is pinned again when Console confirms RH registration next time.
+func UnpinConsoleCert(host string) error {
+	consoleCertLock.Lock()
+	defer consoleCertLock.Unlock()
+
+	if host == "" {
+		consoleCerts = make(map[string]*x509.Certificate)
+	} else {
+		delete(consoleCerts, host)
+	}
+
+	return db.RemoveConsoleCert(host)
+}
+
+//returns true if certificates have the same public key
+func sameKey(a, b *x509.Certificate) bool {
+	aKey, err := x509.MarshalPKIXPublicKey(a.PublicKey)
+	if err != nil {
+		return false
+	}
+	bKey, err := x509.MarshalPKIXPublicKey(b.PublicKey)
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(aKey, bKey)
+}
+
+// GetServerTLSConfig returns TLS configuration for RH endpoints which are called by Console.
+// Peers are required to present client certificate with the same public key as Console certificate pinned during registration.
+func GetServerTLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(path.Join(sslPath, "cert.pem"), path.Join(sslPath, "key.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates:          []tls.Certificate{cert},
+		ClientAuth:            tls.RequireAnyClientCert,
+		VerifyPeerCertificate: verifyConsoleCert,
+	}, nil
+}
+
+func verifyConsoleCert(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("Client certificate is missing")
+	}
+
+	clientCert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return err
+	}
+
+	consoleCert, err := GetConsoleCert()
+	if err != nil {
+		return err
+	}
+
+	if !sameKey(clientCert, consoleCert) {
+		return errors.New("Client certificate does not match Console certificate")
+	}
+
+	return nil
+}
@@ -23,7 +23,7 @@ var httpUtil = GetUtil()
 // ---> InfluxDB
 func InfluxDbClient() (clnt client.Client, err error) {
 	return client.NewHTTPClient(client.HTTPConfig{
-		Addr:               "https://" + path.Join(config.ManagementIP) + ":8086",
+		Addr:               "https://" + path.Join(config.ManagementIP()) + ":8086",
 		Username:           config.Influxdb.User,
 		Password:           config.Influxdb.Pass,
 		Timeout:            time.Second * 60,
@@ -110,7 +110,7 @@ func GetConsolePubKey() ([]byte, error) {
 
 	clnt := httpUtil.GetClient(30)
 
-	resp, err := clnt.Get("https://" + path.Join(config.ManagementIP) + ":8443/rest/v1/security/keyman/getpublickeyring")
+	resp, err := clnt.Get("https://" + path.Join(config.ManagementIP()) + ":" + config.Management.Port + "/rest/v1/security/keyman/getpublickeyring")
 
 	if err == nil {
 		defer Close(resp)
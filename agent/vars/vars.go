@@ -6,4 +6,14 @@ var (
 	Version string
 )
 
+//port of plain HTTP daemon API kept for Consoles not using TLS one yet, see Management.PlainApi
 const DAEMON_PORT = "7070"
+
+//port of daemon API, served over TLS and authenticated by Console client certificate
+const DAEMON_SECURE_PORT = "7071"
+
+//unix socket of local daemon API
+const DAEMON_SOCKET = "/var/run/subutai.sock"
+
+//environment variable set for commands executed by daemon on behalf of local API clients
+const LOCAL_API_CHILD_ENV = "SUBUTAI_LOCAL_API_CHILD"
@@ -0,0 +1,474 @@
+package cli
+
+import (
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/fs"
+	"github.com/subutai-io/agent/lib/proxy"
+	"github.com/subutai-io/agent/lib/secret"
+	"github.com/subutai-io/agent/log"
+	"github.com/subutai-io/agent/agent/util"
+	"gopkg.in/yaml.v2"
+	"regexp"
+)
+
+// ContainerSpec is a declarative description of container applied by `subutai apply`.
+// Omitted sections are left as is, so that spec may manage only part of container settings.
+type ContainerSpec struct {
+	Name string `yaml:"name"`
+	//template reference in form name[@owner[:version]], used only to create container
+	Template    string `yaml:"template"`
+	Environment string `yaml:"environment"`
+	//console secret, may be a reference to stored secret in form secret:name
+	Secret   string       `yaml:"secret"`
+	Network  *NetworkSpec `yaml:"network"`
+	Hostname string       `yaml:"hostname"`
+	Quota    QuotaSpec    `yaml:"quota"`
+	//proxies created by earlier applied spec and missing in this one are removed, empty list removes all of them
+	Proxies  []ProxySpec   `yaml:"proxies"`
+	Snapshot *SnapshotSpec `yaml:"snapshots"`
+	//restart policy: always, on-failure:N or never
+	Restart string `yaml:"restart"`
+}
+
+// NetworkSpec describes static container network, it can be set only on container creation
+type NetworkSpec struct {
+	//ip/mask
+	Ip   string `yaml:"ip"`
+	Vlan int    `yaml:"vlan"`
+}
+
+// QuotaSpec lists resource quotas in units of `subutai quota`, empty quota is not managed
+type QuotaSpec struct {
+	Cpu    string `yaml:"cpu"`
+	Ram    string `yaml:"ram"`
+	Disk   string `yaml:"disk"`
+	Net    string `yaml:"net"`
+	Cpuset string `yaml:"cpuset"`
+}
+
+// ProxySpec describes proxy identified by tag, servers in form :port are ports of the container itself
+type ProxySpec struct {
+	Tag         string   `yaml:"tag"`
+	Protocol    string   `yaml:"protocol"`
+	Domain      string   `yaml:"domain"`
+	Port        int      `yaml:"port"`
+	Balancing   string   `yaml:"balancing"`
+	Certificate string   `yaml:"certificate"`
+	Redirect    bool     `yaml:"redirect"`
+	SslBackend  bool     `yaml:"sslbackend"`
+	Http2       bool     `yaml:"http2"`
+	Servers     []string `yaml:"servers"`
+}
+
+// SnapshotSpec describes periodic snapshots, zero interval removes schedule
+type SnapshotSpec struct {
+	Interval  string `yaml:"interval"`
+	Keep      int    `yaml:"keep"`
+	Partition string `yaml:"partition"`
+}
+
+// ReadSpec parses container spec file
+func ReadSpec(file string) ContainerSpec {
+	data, err := ioutil.ReadFile(file)
+	log.Check(log.ErrorLevel, "Reading spec "+file, err)
+
+	var spec ContainerSpec
+	log.Check(log.ErrorLevel, "Parsing spec "+file, yaml.UnmarshalStrict(data, &spec))
+
+	spec.Name = strings.TrimSpace(spec.Name)
+	checkArgument(spec.Name != "", "Container name is missing in spec")
+
+	return spec
+}
+
+// SpecName returns container name of spec file, empty string if spec can not be read
+func SpecName(file string) string {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+
+	var spec ContainerSpec
+	if yaml.Unmarshal(data, &spec) != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(spec.Name)
+}
+
+// Apply converges container to the state described by spec file: container is created if missing,
+// then hostname, quotas, proxies and snapshot schedule are changed where they differ from spec.
+// Returns descriptions of changes in the order they were made, with dryRun changes are only planned.
+func Apply(file string, dryRun bool) []string {
+	spec := ReadSpec(file)
+	name := spec.Name
+
+	util.VerifyLxcName(name)
+	checkState(!container.IsTemplate(name), "%s is a template", name)
+
+	var changes []string
+	change := func(description string, apply func()) {
+		changes = append(changes, description)
+		if !dryRun {
+			apply()
+		}
+	}
+
+	exists := container.LxcInstanceExists(name)
+	if !exists {
+		checkArgument(spec.Template != "", "Template is required to create container %s", name)
+
+		addr := ""
+		if spec.Network != nil {
+			addr = spec.Network.Ip + " " + strconv.Itoa(spec.Network.Vlan)
+		}
+
+		change("create container "+name+" from "+spec.Template, func() {
+			LxcClone(spec.Template, name, spec.Environment, addr, resolveSpecSecret(spec.Secret))
+		})
+		//in dry run the rest is planned against empty container
+		exists = !dryRun
+	} else {
+		applyMetadata(spec, change)
+	}
+
+	if spec.Hostname != "" && spec.Hostname != currentHostname(name, exists) {
+		change("set hostname to "+spec.Hostname, func() {
+			LxcHostname(name, spec.Hostname)
+		})
+	}
+
+	if spec.Restart != "" {
+		applyRestartPolicy(name, spec.Restart, exists, change)
+	}
+
+	applyQuotas(spec, exists, change)
+
+	ip := ""
+	if c, err := db.FindContainerByName(name); !log.Check(log.WarnLevel, "Reading container metadata", err) && c != nil {
+		ip = c.Ip
+	}
+	if ip == "" && spec.Network != nil {
+		ip = strings.Split(spec.Network.Ip, "/")[0]
+	}
+	if spec.Proxies != nil {
+		var tags []string
+		for _, p := range spec.Proxies {
+			tags = append(tags, applyProxy(p, ip, change))
+		}
+		applyRemovedProxies(name, tags, dryRun, change)
+	}
+
+	if spec.Snapshot != nil {
+		applySnapshotSchedule(name, *spec.Snapshot, change)
+	}
+
+	return changes
+}
+
+//checks immutable settings of existing container and updates its environment
+func applyMetadata(spec ContainerSpec, change func(string, func())) {
+	c, err := db.FindContainerByName(spec.Name)
+	log.Check(log.ErrorLevel, "Reading container metadata", err)
+	if c == nil {
+		log.Warn("Metadata of container " + spec.Name + " is missing, template and network are not checked")
+		return
+	}
+
+	if spec.Template != "" {
+		t := parseTemplateRef(spec.Template)
+		checkState(t.Name == c.Template && (t.Owner == "" || t.Owner == c.TemplateOwner) && (t.Version == "" || t.Version == c.TemplateVersion),
+			"Container %s is cloned from %s@%s:%s, template can not be changed without re-creating container",
+			spec.Name, c.Template, c.TemplateOwner, c.TemplateVersion)
+	}
+
+	if spec.Network != nil {
+		checkState(strings.Split(spec.Network.Ip, "/")[0] == c.Ip && strconv.Itoa(spec.Network.Vlan) == c.Vlan,
+			"Container %s has address %s in vlan %s, network can not be changed without re-creating container",
+			spec.Name, c.Ip, c.Vlan)
+	}
+
+	if spec.Environment != "" && spec.Environment != c.EnvironmentId {
+		change("set environment to "+spec.Environment, func() {
+			log.Check(log.ErrorLevel, "Saving container metadata", db.UpdateContainer(spec.Name, func(c *db.Container) {
+				c.EnvironmentId = spec.Environment
+			}))
+		})
+	}
+}
+
+//returns name, owner and version of template reference, id references are not parsed
+func parseTemplateRef(ref string) Template {
+	var t Template
+	for _, rx := range []*regexp.Regexp{templateNameNOwnerNVersionRx, templateNameNOwnerRx, templateNameRx} {
+		if rx.MatchString(ref) {
+			groups := util.MatchRegexGroups(rx, ref)
+			t.Name, t.Owner, t.Version = groups["name"], groups["owner"], groups["version"]
+			break
+		}
+	}
+	checkArgument(t.Name != "", "Invalid template reference %s", ref)
+
+	return t
+}
+
+func resolveSpecSecret(value string) string {
+	if name, ok := secret.Ref(value); ok {
+		return SecretGet(name)
+	}
+
+	return value
+}
+
+func currentHostname(name string, exists bool) string {
+	if !exists {
+		return ""
+	}
+
+	hostname, err := ioutil.ReadFile(path.Join(config.Agent.LxcPrefix, name, "rootfs/etc/hostname"))
+	log.Check(log.DebugLevel, "Reading hostname of "+name, err)
+
+	return strings.TrimSpace(string(hostname))
+}
+
+func applyRestartPolicy(name, policy string, exists bool, change func(string, func())) {
+	kind, max, err := container.ParseRestartPolicy(policy)
+	checkArgument(err == nil, "%v", err)
+	policy = container.FormatRestartPolicy(kind, max)
+
+	if exists {
+		current := GetRestartPolicy(name)
+		if current.Policy == policy {
+			return
+		}
+	}
+
+	change("set restart policy to "+policy, func() {
+		SetRestartPolicy(name, policy)
+	})
+}
+
+func applyQuotas(spec ContainerSpec, exists bool, change func(string, func())) {
+	quotas := [][]string{
+		{"cpu", spec.Quota.Cpu},
+		{"ram", spec.Quota.Ram},
+		{"disk", spec.Quota.Disk},
+		{"network", spec.Quota.Net},
+		{"cpuset", spec.Quota.Cpuset},
+	}
+
+	for _, q := range quotas {
+		resource, value := q[0], strings.TrimSpace(q[1])
+		if value == "" {
+			continue
+		}
+		if resource == "cpu" {
+			_, err := strconv.Atoi(value)
+			checkArgument(err == nil, "Invalid cpu quota %s", value)
+		}
+		if exists && quotaApplied(spec.Name, resource, value) {
+			continue
+		}
+		//cpu quota is set on running container only, see container.QuotaCPU
+		checkState(resource != "cpu" || !exists || container.State(spec.Name) == container.Running,
+			"Container %s is not running, cpu quota can not be set", spec.Name)
+
+		change("set "+resource+" quota to "+value, func() {
+			LxcQuota(spec.Name, resource, value, "")
+		})
+	}
+}
+
+//checks if quota is configured for container as passed value, without querying running container
+func quotaApplied(name, resource, value string) bool {
+	if resource != "cpu" {
+		return value == currentQuota(name, resource)
+	}
+
+	//cpu quota may be given in MHz, so configured value is compared to the one container.QuotaCPU would set
+	current := container.GetProperty(name, "lxc.cgroup.cpu.cfs_quota_us")
+	if quota, err := strconv.Atoi(current); err != nil || quota < 0 {
+		current = "0"
+	}
+
+	return current == container.CPUQuotaValue(value)
+}
+
+//returns quota as it is configured for container, without querying running container
+func currentQuota(name, resource string) string {
+	switch resource {
+	case "ram":
+		return strings.TrimSuffix(container.GetProperty(name, "lxc.cgroup.memory.limit_in_bytes"), "M")
+	case "disk":
+		value, err := fs.GetQuota(name)
+		log.Check(log.DebugLevel, "Getting disk quota of "+name, err)
+		return strconv.Itoa(value / 1024 / 1024 / 1024)
+	case "network":
+		return container.GetProperty(name, "subutai.network.ratelimit")
+	case "cpuset":
+		return container.GetProperty(name, "lxc.cgroup.cpuset.cpus")
+	}
+
+	return ""
+}
+
+//converges proxy to spec and returns its tag,
+//servers in form :port are resolved to container ip, which has to be known
+func applyProxy(spec ProxySpec, ip string, change func(string, func())) string {
+	spec.Tag = strings.ToLower(strings.TrimSpace(spec.Tag))
+	spec.Protocol = strings.ToLower(strings.TrimSpace(spec.Protocol))
+	spec.Domain = strings.ToLower(strings.TrimSpace(spec.Domain))
+	spec.Balancing = strings.ToLower(strings.TrimSpace(spec.Balancing))
+	checkArgument(spec.Tag != "", "Proxy tag is missing in spec")
+
+	existing, err := proxy.FindProxyByTag(spec.Tag)
+	log.Check(log.ErrorLevel, "Looking up proxy "+spec.Tag, err)
+
+	var servers []string
+	if existing != nil && !proxyMatches(*existing, spec) {
+		change("re-create proxy "+spec.Tag, func() {
+			log.Check(log.ErrorLevel, "Removing proxy "+spec.Tag, proxy.RemoveProxy(spec.Tag))
+		})
+		existing = nil
+	}
+
+	if existing == nil {
+		change("create proxy "+spec.Tag+" for "+spec.Protocol+"://"+spec.Domain+":"+strconv.Itoa(spec.Port), func() {
+			log.Check(log.ErrorLevel, "Creating proxy "+spec.Tag, proxy.CreateProxy(spec.Protocol, spec.Domain,
+				spec.Balancing, spec.Tag, spec.Port, spec.Redirect, spec.SslBackend, spec.Certificate, spec.Http2))
+		})
+	} else {
+		proxied, err := proxy.FindProxiedServers(spec.Tag, "")
+		log.Check(log.ErrorLevel, "Looking up servers of proxy "+spec.Tag, err)
+		for _, s := range proxied {
+			servers = append(servers, s.Socket)
+		}
+	}
+
+	var desired []string
+	for _, socket := range spec.Servers {
+		socket = strings.TrimSpace(socket)
+		if strings.HasPrefix(socket, ":") {
+			checkState(ip != "", "Address of container is unknown, server %s of proxy %s can not be resolved, specify network ip in spec", socket, spec.Tag)
+			socket = ip + socket
+		}
+		desired = append(desired, socket)
+	}
+
+	for _, socket := range desired {
+		if !contains(servers, socket) {
+			change("add server "+socket+" to proxy "+spec.Tag, func() {
+				log.Check(log.ErrorLevel, "Adding server to proxy "+spec.Tag, proxy.AddProxiedServer(spec.Tag, socket))
+			})
+		}
+	}
+	for _, socket := range servers {
+		if !contains(desired, socket) {
+			change("remove server "+socket+" from proxy "+spec.Tag, func() {
+				log.Check(log.ErrorLevel, "Removing server from proxy "+spec.Tag, proxy.RemoveProxiedServer(spec.Tag, socket))
+			})
+		}
+	}
+
+	return spec.Tag
+}
+
+//removes proxies created by earlier applied spec of container which are no longer in spec,
+//proxies created other way are not touched
+func applyRemovedProxies(name string, tags []string, dryRun bool, change func(string, func())) {
+	applied, err := db.GetAppliedProxies(name)
+	log.Check(log.ErrorLevel, "Reading proxies applied to "+name, err)
+
+	for _, tag := range applied {
+		if contains(tags, tag) {
+			continue
+		}
+		existing, err := proxy.FindProxyByTag(tag)
+		log.Check(log.ErrorLevel, "Looking up proxy "+tag, err)
+		if existing != nil {
+			change("remove proxy "+tag, func() {
+				log.Check(log.ErrorLevel, "Removing proxy "+tag, proxy.RemoveProxy(tag))
+			})
+		}
+	}
+
+	if !dryRun {
+		log.Check(log.ErrorLevel, "Saving proxies applied to "+name, db.SaveAppliedProxies(name, tags))
+	}
+}
+
+//compares proxy with spec the way proxy.CreateProxy normalizes its options
+func proxyMatches(p db.Proxy, spec ProxySpec) bool {
+	domain, balancing, certificate := spec.Domain, spec.Balancing, spec.Certificate
+	redirect, sslBackend, http2 := spec.Redirect, spec.SslBackend, spec.Http2
+	if balancing == "" {
+		balancing = "rr"
+	}
+	if spec.Protocol != proxy.HTTP && spec.Protocol != proxy.HTTPS {
+		domain = ""
+		http2 = false
+	}
+	if spec.Protocol != proxy.HTTPS {
+		redirect, sslBackend, certificate = false, false, ""
+	} else if spec.Port == 80 {
+		redirect = false
+	}
+
+	return p.Protocol == spec.Protocol && p.Domain == domain && p.Port == spec.Port && p.LoadBalancing == balancing &&
+		p.CertPath == certificate && p.Redirect80Port == redirect && p.SslBackend == sslBackend && p.Http2 == http2
+}
+
+func applySnapshotSchedule(name string, spec SnapshotSpec, change func(string, func())) {
+	interval, err := time.ParseDuration(strings.TrimSpace(spec.Interval))
+	checkArgument(err == nil && interval >= 0, "Invalid snapshot interval %s", spec.Interval)
+
+	schedule, err := db.FindSnapshotSchedule(name)
+	log.Check(log.ErrorLevel, "Reading snapshot schedule", err)
+
+	if interval == 0 {
+		if schedule != nil {
+			change("remove snapshot schedule", func() {
+				log.Check(log.ErrorLevel, "Removing snapshot schedule", db.RemoveSnapshotSchedule(schedule))
+			})
+		}
+		return
+	}
+
+	partition := strings.ToLower(strings.TrimSpace(spec.Partition))
+	if partition == "" {
+		partition = "all"
+	}
+	checkPartitionName(partition)
+	checkArgument(interval >= time.Minute, "Snapshot interval must be at least a minute")
+	checkArgument(spec.Keep >= 0, "Invalid number of kept snapshots %d", spec.Keep)
+
+	if schedule != nil && schedule.Partition == partition && schedule.Interval == interval && schedule.Keep == spec.Keep {
+		return
+	}
+	if schedule == nil {
+		schedule = &db.SnapshotSchedule{Container: name}
+	}
+
+	change("snapshot "+partition+" every "+interval.String()+" keeping "+strconv.Itoa(spec.Keep), func() {
+		schedule.Partition, schedule.Interval, schedule.Keep = partition, interval, spec.Keep
+		log.Check(log.ErrorLevel, "Saving snapshot schedule", db.SaveSnapshotSchedule(schedule))
+	})
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
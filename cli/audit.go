@@ -0,0 +1,32 @@
+package cli
+
+import (
+	"strings"
+	"time"
+
+	"github.com/subutai-io/agent/lib/audit"
+	"github.com/subutai-io/agent/log"
+)
+
+// AuditList returns audit log entries recorded since passed moment for passed container.
+// since is either duration back from now (e.g. 24h) or time 'yyyy-mm-dd hh:mi:ss', empty since lists all entries.
+// Broken hash chain is reported as a warning, entries are still listed.
+func AuditList(since, container string) []audit.Entry {
+	since = strings.TrimSpace(since)
+	container = strings.TrimSpace(container)
+
+	var from time.Time
+	if since != "" {
+		if d, err := time.ParseDuration(since); err == nil {
+			from = time.Now().Add(-d)
+		} else {
+			from, err = time.ParseInLocation("2006-01-02 15:04:05", since, time.Local)
+			checkArgument(err == nil, "Invalid since value %s", since)
+		}
+	}
+
+	entries, err := audit.List(from, container)
+	log.Check(log.WarnLevel, "Verifying audit log", err)
+
+	return entries
+}
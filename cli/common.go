@@ -2,9 +2,10 @@ package cli
 
 import (
 	"github.com/subutai-io/agent/agent/console"
-	"github.com/subutai-io/agent/agent/vars"
-	"net/http"
 	"github.com/subutai-io/agent/log"
+	"github.com/subutai-io/agent/agent/local"
+	"github.com/subutai-io/agent/agent/vars"
+	"os"
 )
 
 var (
@@ -16,11 +17,22 @@ func init() {
 }
 
 func sendHeartbeat() {
+	//commands executed through local API are followed by heartbeat sent by daemon
+	if os.Getenv(vars.LOCAL_API_CHILD_ENV) != "" {
+		return
+	}
+
+	if vars.IsDaemon {
+		go consol.SendHeartBeat(true)
+		return
+	}
+
 	if consol.IsRegistered() {
-		//trigger heartbeat via REST to agent
-		resp, err := http.Get("http://localhost:" + vars.DAEMON_PORT + "/heartbeat")
-		if !log.Check(log.WarnLevel, "Triggering heartbeat", err) {
-			consol.Close(resp)
+		//trigger heartbeat via local API of agent, daemon API accepts Console only
+		if !local.Available() {
+			log.Debug("Agent daemon is not available, heartbeat is not sent")
+			return
 		}
+		log.Check(log.WarnLevel, "Triggering heartbeat", local.Heartbeat())
 	}
 }
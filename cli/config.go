@@ -9,6 +9,9 @@ import (
 	"github.com/subutai-io/agent/lib/container"
 	"github.com/subutai-io/agent/log"
 	"path"
+	"strconv"
+	"github.com/subutai-io/agent/db"
+	"time"
 )
 
 // LxcConfig function allows read and write container's configuration file through command line.
@@ -91,3 +94,106 @@ func delValue(containerName, key string) {
 		log.Info(key + " deleted")
 	}
 }
+
+// ContainerSettings describes settings of container kept in agent db
+type ContainerSettings struct {
+	Container      string   `json:"container"`
+	DependsOn      []string `json:"dependsOn"`
+	Priority       int      `json:"priority"`
+	ReadinessProbe string   `json:"readinessProbe,omitempty"`
+	RestartPolicy  string   `json:"restartPolicy"`
+	//health check settings and the last check result
+	HealthCheck     string        `json:"healthCheck,omitempty"`
+	HealthInterval  time.Duration `json:"healthInterval,omitempty"`
+	HealthRetries   int           `json:"healthRetries"`
+	Health          string        `json:"health,omitempty"`
+	HealthOutput    string        `json:"healthOutput,omitempty"`
+	LastHealthCheck time.Time     `json:"lastHealthCheck,omitempty"`
+}
+
+// GetContainerSettings returns start order, restart and health check settings of container
+func GetContainerSettings(name string) ContainerSettings {
+	c := containerMetadata(name)
+
+	return ContainerSettings{
+		Container:      name,
+		DependsOn:      append([]string{}, c.DependsOn...),
+		Priority:       c.Priority,
+		ReadinessProbe: c.ReadinessProbe,
+		RestartPolicy:  GetRestartPolicy(name).Policy,
+
+		HealthCheck:     c.HealthCheck,
+		HealthInterval:  c.HealthInterval,
+		HealthRetries:   c.HealthRetries,
+		Health:          c.Health,
+		HealthOutput:    c.HealthOutput,
+		LastHealthCheck: c.LastHealthCheck,
+	}
+}
+
+// SetContainerSetting changes container setting kept in agent db:
+//	depends-on, comma separated containers started and ready before this one, empty value clears it
+//	priority, containers independent of each other are started in order of priority, higher first
+//	readiness, command executed inside container to check that it is ready for its dependents
+//	restart-policy, always, on-failure:N or never
+//	health-check, exec:{command}, tcp:{port} or http:{port}[/{path}] checked by daemon, empty value clears it
+//	health-interval, duration between health checks, e.g. 30s
+//	health-retries, consecutive failed checks after which container is restarted, 0 disables restart
+func SetContainerSetting(name, key, value string) {
+	value = strings.TrimSpace(value)
+
+	if key == "restart-policy" {
+		SetRestartPolicy(name, value)
+		return
+	}
+
+	containerMetadata(name)
+
+	//only edited setting is changed, so that concurrent changes of container record made by daemon are kept
+	var update func(c *db.Container)
+
+	switch key {
+	case "depends-on":
+		var deps []string
+		for _, dep := range strings.Split(value, ",") {
+			if dep = strings.TrimSpace(dep); dep != "" {
+				checkArgument(dep != name, "Container can not depend on itself")
+				checkState(container.IsContainer(dep), "Container %s not found", dep)
+				deps = append(deps, dep)
+			}
+		}
+		cycle := container.DependencyCycle(name, deps)
+		checkState(cycle == nil, "Dependencies form a cycle: %s", strings.Join(cycle, " -> "))
+		update = func(c *db.Container) { c.DependsOn = deps }
+	case "priority":
+		priority, err := strconv.Atoi(value)
+		checkArgument(err == nil, "Invalid priority %s", value)
+		update = func(c *db.Container) { c.Priority = priority }
+	case "readiness":
+		update = func(c *db.Container) { c.ReadinessProbe = value }
+	case "health-check":
+		if value != "" {
+			_, _, err := container.ParseHealthCheck(value)
+			checkArgument(err == nil, "Invalid health check: %v", err)
+		}
+		update = func(c *db.Container) {
+			//result of previous check does not apply to the new one
+			c.HealthCheck, c.Health, c.HealthFailures, c.HealthOutput = value, "", 0, ""
+			if value != "" {
+				c.Health = container.HealthStarting
+			}
+		}
+	case "health-interval":
+		interval, err := time.ParseDuration(value)
+		checkArgument(err == nil && interval > 0, "Invalid health check interval %s", value)
+		update = func(c *db.Container) { c.HealthInterval = interval }
+	case "health-retries":
+		retries, err := strconv.Atoi(value)
+		checkArgument(err == nil && retries >= 0, "Invalid number of health check retries %s", value)
+		update = func(c *db.Container) { c.HealthRetries = retries }
+	default:
+		log.Error("Unknown setting " + key + ", expected depends-on, priority, readiness, restart-policy, health-check, health-interval or health-retries")
+	}
+
+	log.Check(log.ErrorLevel, "Saving container metadata", db.UpdateContainer(name, update))
+}
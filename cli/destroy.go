@@ -127,6 +127,12 @@ func destroy(name string) error {
 			return errors.New(name + " not found")
 		}
 
+		//scheduled snapshots are gone together with container
+		schedule, err := db.FindSnapshotSchedule(name)
+		if !log.Check(log.WarnLevel, "Reading snapshot schedule", err) && schedule != nil {
+			log.Check(log.WarnLevel, "Removing snapshot schedule", db.RemoveSnapshotSchedule(schedule))
+		}
+
 		if name == container.Management {
 			//todo check error here
 			deleteManagement()
@@ -9,7 +9,7 @@ import (
 	"path"
 )
 
-//gpg1 --batch --passphrase {pwd} --symmetric --cipher-algo AES256 {/path/to/file}
+//encrypts the same way as: gpg --batch --passphrase {pwd} --symmetric --cipher-algo AES256 {/path/to/file}
 func EncryptFile(pathToFile, password string) {
 	pathToFile = strings.TrimSpace(pathToFile)
 	password = strings.TrimSpace(password)
@@ -32,7 +32,7 @@ func EncryptFile(pathToFile, password string) {
 	log.Info("Encrypted file to " + pathToFile + ".gpg")
 }
 
-//gpg1 --batch --passphrase {pwd} --output {/path/to/file} --decrypt {/path/to/file}
+//decrypts the same way as: gpg --batch --passphrase {pwd} --output {/path/to/file} --decrypt {/path/to/file}
 func DecryptFile(pathToSrcFile, pathToDestFile, password string) {
 	pathToSrcFile = strings.TrimSpace(pathToSrcFile)
 	pathToDestFile = strings.TrimSpace(pathToDestFile)
@@ -25,6 +25,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/subutai-io/agent/db"
 	"github.com/subutai-io/agent/lib/net"
+	"github.com/subutai-io/agent/lib/event"
 )
 
 const maxDownloadAttempts = 3
@@ -333,10 +334,13 @@ func LxcImport(name, token string, auxDepList ...string) {
 
 	if t.Name == container.Management {
 		initManagement(templateRef)
+		event.Publish(event.TemplateImported, "", map[string]string{"template": templateRef})
 		return
 	}
 
 	log.Check(log.ErrorLevel, "Setting lxc config", updateContainerConfig(templateRef))
+
+	event.Publish(event.TemplateImported, "", map[string]string{"template": templateRef})
 }
 
 func download(template Template) {
@@ -0,0 +1,60 @@
+package cli
+
+import (
+	"strings"
+	"time"
+
+	"github.com/subutai-io/agent/agent/util"
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/lib/gpg"
+	"github.com/subutai-io/agent/log"
+)
+
+// RotateKey replaces GPG key of RH ("rh" target) or container with a new one certified by the old key
+// and pushes the new key to Console. Old key keeps decrypting requests for the grace period.
+// RH key is restored if Console does not accept registration with the new key.
+// Container key is registered with Console using token, the same way as on container import.
+func RotateKey(target string, grace time.Duration, token string) {
+	target = strings.TrimSpace(target)
+	token = strings.TrimSpace(token)
+
+	checkArgument(target != "", "Invalid target")
+	checkArgument(grace >= 0, "Invalid grace period")
+
+	name := config.Agent.GpgUser
+	if target != "rh" {
+		checkState(container.LxcInstanceExists(target) && !container.IsTemplate(target), "Container %s not found", target)
+		checkArgument(token != "", "Token is required to register container key")
+		name = target
+	}
+
+	fingerprint, rollback, err := gpg.RotateKey(name, grace)
+	log.Check(log.ErrorLevel, "Rotating key of "+target, err)
+
+	if target == "rh" {
+		if err = consol.Register(); err != nil {
+			log.Check(log.WarnLevel, "Restoring old key of RH", rollback())
+			log.Error("Sending new key to Console, old key is kept: " + err.Error())
+		}
+	} else {
+		gpg.ExchangeAndEncrypt(target, token)
+		sendHeartbeat()
+	}
+
+	log.Info("Rotated key of " + target + ", new fingerprint " + fingerprint)
+}
+
+// UnpinConsoleCert forgets Console certificate pinned at registration for passed Management host, or for all hosts.
+// It is needed when Console certificate is replaced, new certificate is pinned once Console confirms RH registration again.
+func UnpinConsoleCert(host string) {
+	host = strings.TrimSpace(host)
+
+	log.Check(log.ErrorLevel, "Removing pinned Console certificate", util.UnpinConsoleCert(host))
+
+	if host == "" {
+		log.Info("Removed pinned Console certificates")
+	} else {
+		log.Info("Removed pinned certificate of Console at " + host)
+	}
+}
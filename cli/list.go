@@ -46,16 +46,39 @@ func printList(list []string, c, t, i, p bool) {
 	w.Flush()
 }
 
+// InstanceInfo describes a single container or template in the listing
+type InstanceInfo struct {
+	Name      string `json:"name"`
+	State     string `json:"state,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	Interface string `json:"interface,omitempty"`
+	Parent    string `json:"parent,omitempty"`
+}
+
 // LxcList function shows a listing of Subutai instances with information such as IP address, parent template, etc.
 func LxcList(name string, c, t, i, p bool) {
+	var list []string
+	for _, item := range GetInstances(name, c, t, i, p) {
+		line := item.Name
+		if i {
+			line = line + "\t" + item.State + "\t" + item.IP + "\t" + item.Interface
+		}
+		if p {
+			line = line + "\t" + item.Parent
+		}
+		list = append(list, line)
+	}
+	printList(list, c, t, i, p)
+}
+
+// GetInstances returns Subutai instances shown by LxcList
+func GetInstances(name string, c, t, i, p bool) []InstanceInfo {
 	var list []string
 	if i {
 		if name == "" {
-			for _, item := range container.Containers() {
-				list = append(list, info(item)...)
-			}
+			list = append(list, container.Containers()...)
 		} else {
-			list = append(list, info(name)...)
+			list = append(list, name)
 		}
 	} else if c == t {
 		list = append(list, container.All()...)
@@ -72,31 +95,32 @@ func LxcList(name string, c, t, i, p bool) {
 			list = []string{}
 		}
 	}
-	if p {
-		list = addParent(list)
-	}
 	sort.Strings(list)
-	printList(list, c, t, i, p)
 
-}
-
-// addParent adds parent to each template in list
-func addParent(list []string) []string {
-	for i := range list {
-		name := strings.Fields(list[i])[0]
-		parent := strings.TrimSpace(container.GetProperty(name, "subutai.parent")) + ":" +
-			strings.TrimSpace(container.GetProperty(name, "subutai.parent.owner")) + ":" +
-			strings.TrimSpace(container.GetProperty(name, "subutai.parent.version"))
-		if name == parent {
-			list[i] = list[i] + "\t"
-		} else {
-			list[i] = list[i] + "\t" + parent
+	result := []InstanceInfo{}
+	for _, item := range list {
+		instance := InstanceInfo{Name: item}
+		if i {
+			instance.State = container.State(item)
+			instance.IP = container.GetIp(item)
+			instance.Interface = container.ContainerDefaultIface
+		}
+		if p {
+			instance.Parent = parent(item)
 		}
+		result = append(result, instance)
 	}
-	return list
+
+	return result
 }
 
-// info adds container's IP and NIC to list
-func info(name string) (result []string) {
-	return append(result, name+"\t"+container.State(name)+"\t"+container.GetIp(name)+"\t"+container.ContainerDefaultIface)
+// parent returns parent of template in form name:owner:version, empty for templates without parent
+func parent(name string) string {
+	parent := strings.TrimSpace(container.GetProperty(name, "subutai.parent")) + ":" +
+		strings.TrimSpace(container.GetProperty(name, "subutai.parent.owner")) + ":" +
+		strings.TrimSpace(container.GetProperty(name, "subutai.parent.version"))
+	if name == parent {
+		return ""
+	}
+	return parent
 }
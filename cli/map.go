@@ -19,17 +19,24 @@ var (
 	nginxInc = path.Join(config.Agent.DataPrefix, "nginx/nginx-includes")
 )
 
-func GetPortMappings(protocol string) []string {
+// PortMapping describes a server mapped to external port
+type PortMapping struct {
+	Protocol string `json:"protocol"`
+	Port     int    `json:"port"`
+	Server   string `json:"server"`
+	Domain   string `json:"domain"`
+}
+
+func GetPortMappings(protocol string) []PortMapping {
 	protocol = strings.ToLower(protocol)
 
-	var output []string
+	output := []PortMapping{}
 	proxies, err := proxy.GetProxies(protocol)
 	log.Check(log.ErrorLevel, "Getting proxies", err)
 	for _, p := range proxies {
 		if protocol == p.Proxy.Protocol || protocol == "" {
 			for _, server := range p.Servers {
-				line := fmt.Sprintf("%s\t%d\t%s\t%s", p.Proxy.Protocol, p.Proxy.Port, server.Socket, p.Proxy.Domain)
-				output = append(output, line)
+				output = append(output, PortMapping{Protocol: p.Proxy.Protocol, Port: p.Proxy.Port, Server: server.Socket, Domain: p.Proxy.Domain})
 			}
 		}
 	}
@@ -1,7 +1,6 @@
 package cli
 
 import (
-	"encoding/json"
 	"os"
 	"time"
 
@@ -49,7 +48,7 @@ func queryInfluxDB(clnt client.Client, cmd string) (res []client.Result, err err
 //	last day data aggregates to 1 minute interval,
 //	last week is in 5 minute intervals,
 // After 7 days all statistics is are overwritten by new incoming data.
-func GetHostMetrics(host, start, end string) string {
+func GetHostMetrics(host, start, end string) HostMetrics {
 	c, err := util.InfluxDbClient()
 	if err == nil {
 		defer c.Close()
@@ -97,7 +96,11 @@ func GetHostMetrics(host, start, end string) string {
 			WHERE hostname = '`+ host+ `' AND time > '`+ start+ `' AND time < '`+ end+ `'
 			GROUP BY time(`+ timeGroup+ `), mount, type fill(none);
 		`)
-	out, _ := json.Marshal(res)
 
-	return "{\"Metrics\":" + string(out) + "}"
+	return HostMetrics{Metrics: res}
+}
+
+// HostMetrics wraps results of metrics queries
+type HostMetrics struct {
+	Metrics []client.Result
 }
@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"time"
+
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/log"
+)
+
+// RestartPolicyInfo describes restart policy of container and restarts made by daemon according to it
+type RestartPolicyInfo struct {
+	Container   string     `json:"container"`
+	Policy      string     `json:"policy"`
+	Restarts    int        `json:"restarts"`
+	LastRestart *time.Time `json:"lastRestart,omitempty"`
+	LastFailure string     `json:"lastFailure,omitempty"`
+	Failed      bool       `json:"failed"`
+}
+
+// GetRestartPolicy returns restart policy of container together with its restart statistics
+func GetRestartPolicy(name string) RestartPolicyInfo {
+	c := containerMetadata(name)
+
+	kind, max, err := container.ParseRestartPolicy(c.RestartPolicy)
+	log.Check(log.ErrorLevel, "Reading restart policy of "+name, err)
+
+	info := RestartPolicyInfo{
+		Container:   name,
+		Policy:      container.FormatRestartPolicy(kind, max),
+		Restarts:    c.Restarts,
+		LastFailure: c.LastFailure,
+		Failed:      c.Failed,
+	}
+	if !c.LastRestart.IsZero() {
+		info.LastRestart = &c.LastRestart
+	}
+
+	return info
+}
+
+// SetRestartPolicy sets policy applied by daemon when container supposed to be running is down:
+// always restarts it with growing delays, on-failure:N gives up after N restarts and never does not restart it.
+// Container is restarted whatever way it went down, so on-failure:N means N restarts rather than N failures.
+// Restart statistics are reset.
+func SetRestartPolicy(name, policy string) {
+	kind, max, err := container.ParseRestartPolicy(policy)
+	checkArgument(err == nil, "%v", err)
+
+	containerMetadata(name)
+
+	log.Check(log.ErrorLevel, "Saving container metadata", db.UpdateContainer(name, func(c *db.Container) {
+		c.RestartPolicy = container.FormatRestartPolicy(kind, max)
+		c.Restarts, c.LastRestart, c.LastFailure, c.Failed = 0, time.Time{}, "", false
+	}))
+}
+
+func containerMetadata(name string) *db.Container {
+	checkState(container.IsContainer(name), "Container %s not found", name)
+
+	c, err := db.FindContainerByName(name)
+	log.Check(log.ErrorLevel, "Reading container metadata", err)
+	checkState(c != nil, "Metadata of container %s not found", name)
+
+	return c
+}
+
+//forgets restarts made by daemon, called when container is started by user
+func resetRestarts(name string) {
+	log.Check(log.WarnLevel, "Saving container metadata", db.UpdateContainer(name, func(c *db.Container) {
+		c.Restarts, c.Failed = 0, false
+	}))
+}
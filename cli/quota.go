@@ -1,13 +1,18 @@
 package cli
 
 import (
-	"fmt"
 	"strconv"
 
 	"github.com/subutai-io/agent/lib/container"
 	"github.com/subutai-io/agent/log"
 )
 
+// QuotaInfo describes container resource quota
+type QuotaInfo struct {
+	Quota     string `json:"quota"`
+	Threshold int    `json:"threshold"`
+}
+
 // LxcQuota function controls container's quotas and thresholds. Available resources:
 //	cpu, %
 //	cpuset, available cores
@@ -17,7 +22,7 @@ import (
 // The threshold value represents a percentage for each resource. Once resource consumption exceeds this threshold it triggers an alert.
 // The clone operation, sets no quotas and thresholds for new containers; quotas need to be configured with quota command after a clone operation.
 //todo improve, remove threshold param since alerts are not used
-func LxcQuota(name, res, size, threshold string) {
+func LxcQuota(name, res, size, threshold string) QuotaInfo {
 	if len(threshold) > 0 {
 		setQuotaThreshold(name, res, threshold)
 	}
@@ -40,7 +45,10 @@ func LxcQuota(name, res, size, threshold string) {
 		quota = "0"
 	}
 
-	fmt.Println(`{"quota":"` + quota + `", "threshold":` + alert + `}`)
+	result := QuotaInfo{Quota: quota}
+	result.Threshold, _ = strconv.Atoi(alert)
+
+	return result
 }
 
 // setQuotaThreshold sets threshold for quota alerts
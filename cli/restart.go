@@ -25,6 +25,7 @@ func LxcRestart(names ...string) {
 				}
 			} else {
 				needHeartBeat = true
+				resetRestarts(name)
 				log.Info(name + " restarted")
 			}
 		}
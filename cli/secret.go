@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/lib/secret"
+	"github.com/subutai-io/agent/log"
+)
+
+// SecretInfo describes stored secret, value is never listed
+type SecretInfo struct {
+	Name    string    `json:"name"`
+	Updated time.Time `json:"updated"`
+}
+
+// SecretSet stores secret encrypted with key derived from RH GPG key.
+// If value is empty, it is read from the first line of standard input, so that it does not get to shell history.
+// Stored secret is referenced in agent.conf as secret:name, except for gpgPassword protecting the RH key the store key is derived from
+func SecretSet(name, value string) {
+	name = strings.TrimSpace(name)
+	checkArgument(name != "" && !strings.ContainsAny(name, " \t"), "Invalid secret name")
+
+	if value == "" {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		checkState(err == nil || line != "", "Failed to read secret value")
+		value = strings.TrimRight(line, "\r\n")
+	}
+	checkArgument(value != "", "Invalid secret value")
+
+	log.Check(log.ErrorLevel, "Saving secret "+name, secrets().Set(name, value))
+}
+
+// SecretGet returns value of secret
+func SecretGet(name string) string {
+	name = strings.TrimSpace(name)
+	checkArgument(name != "", "Invalid secret name")
+
+	value, err := secrets().Get(name)
+	checkState(err != secret.ErrNotFound, "Secret %s not found", name)
+	log.Check(log.ErrorLevel, "Reading secret "+name, err)
+
+	return value
+}
+
+// SecretList returns names of stored secrets
+func SecretList() []SecretInfo {
+	list, err := secrets().List()
+	log.Check(log.ErrorLevel, "Listing secrets", err)
+
+	result := []SecretInfo{}
+	for _, s := range list {
+		result = append(result, SecretInfo{Name: s.Name, Updated: s.Updated})
+	}
+
+	return result
+}
+
+// SecretRemove deletes secret
+func SecretRemove(name string) {
+	name = strings.TrimSpace(name)
+	checkArgument(name != "", "Invalid secret name")
+
+	err := secrets().Remove(name)
+	checkState(err != secret.ErrNotFound, "Secret %s not found", name)
+	log.Check(log.ErrorLevel, "Removing secret "+name, err)
+}
+
+func secrets() secret.Store {
+	store, err := config.Secrets()
+	log.Check(log.ErrorLevel, "Opening secrets store", err)
+
+	return store
+}
@@ -10,6 +10,10 @@ import (
 	"path"
 	"os"
 	"path/filepath"
+	"github.com/subutai-io/agent/lib/event"
+	"github.com/subutai-io/agent/db"
+	"time"
+	"sort"
 )
 
 func CreateSnapshot(container, partition, label string, stopContainer bool) {
@@ -42,6 +46,8 @@ func CreateSnapshot(container, partition, label string, stopContainer bool) {
 	checkCondition(err == nil, func() {
 		log.Error("Failed to create snapshot ", err.Error())
 	})
+
+	event.Publish(event.SnapshotCreated, container, map[string]string{"partition": partition, "label": label})
 }
 
 func RemoveSnapshot(container, partition, label string) {
@@ -126,6 +132,42 @@ func ListSnapshots(container, partition string) string {
 	return out
 }
 
+// Snapshot describes a single snapshot of container partition
+type Snapshot struct {
+	Container string `json:"container"`
+	Partition string `json:"partition"`
+	Label     string `json:"label"`
+	Created   string `json:"created"`
+}
+
+// GetSnapshots returns snapshots listed by ListSnapshots
+func GetSnapshots(container, partition string) []Snapshot {
+	snapshots := []Snapshot{}
+
+	for _, line := range strings.Split(ListSnapshots(container, partition), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !strings.Contains(fields[0], "@") {
+			//skip header
+			continue
+		}
+
+		name := strings.TrimPrefix(strings.TrimPrefix(fields[0], config.Agent.Dataset), "/")
+		parts := strings.SplitN(name, "@", 2)
+		dataset := strings.SplitN(parts[0], "/", 2)
+
+		snapshot := Snapshot{Container: dataset[0], Partition: "config", Label: parts[1]}
+		if len(dataset) > 1 {
+			snapshot.Partition = dataset[1]
+		}
+		if len(fields) > 1 {
+			snapshot.Created = strings.Join(fields[1:], " ")
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	return snapshots
+}
+
 func RollbackToSnapshot(container, partition, label string, forceRollback, stopContainer bool) {
 	container = strings.TrimSpace(container)
 	partition = strings.ToLower(strings.TrimSpace(partition))
@@ -338,3 +380,67 @@ func checkPartitionName(partition string) {
 	}
 	checkArgument(partitionFound, "Invalid partition %s", partition)
 }
+
+//label prefix of snapshots taken by schedule, followed by creation time
+const scheduledSnapshotPrefix = "auto-"
+
+// TakeScheduledSnapshots snapshots containers whose schedule interval has passed
+// and removes the oldest scheduled snapshots over the number kept. Called periodically by daemon.
+func TakeScheduledSnapshots() {
+	schedules, err := db.FindSnapshotSchedules()
+	if log.Check(log.WarnLevel, "Reading snapshot schedules", err) {
+		return
+	}
+
+	for i := range schedules {
+		schedule := &schedules[i]
+		if time.Since(schedule.Last) < schedule.Interval || !container2.IsContainer(schedule.Container) {
+			continue
+		}
+
+		label := scheduledSnapshotPrefix + time.Now().Format("20060102150405")
+		err := fs.CreateSnapshot(getSnapshotName(schedule.Container, schedule.Partition, label), schedule.Partition == "all")
+		if log.Check(log.WarnLevel, "Creating scheduled snapshot of "+schedule.Container, err) {
+			continue
+		}
+		event.Publish(event.SnapshotCreated, schedule.Container, map[string]string{"partition": schedule.Partition, "label": label})
+
+		schedule.Last = time.Now()
+		log.Check(log.WarnLevel, "Saving snapshot schedule", db.SaveSnapshotSchedule(schedule))
+
+		pruneScheduledSnapshots(*schedule)
+	}
+}
+
+//removes the oldest scheduled snapshots over the number kept by schedule, zero keeps all
+func pruneScheduledSnapshots(schedule db.SnapshotSchedule) {
+	if schedule.Keep <= 0 {
+		return
+	}
+
+	dataset := schedule.Container
+	if schedule.Partition != "all" {
+		dataset = getSnapshotName(schedule.Container, schedule.Partition, "")
+	}
+
+	out, err := fs.ListSnapshotNamesOnly(dataset)
+	if log.Check(log.WarnLevel, "Listing snapshots of "+schedule.Container, err) {
+		return
+	}
+
+	var labels []string
+	for _, line := range strings.Split(out, "\n") {
+		name := strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(line), config.Agent.Dataset), "/")
+		parts := strings.SplitN(name, "@", 2)
+		if len(parts) == 2 && parts[0] == dataset && strings.HasPrefix(parts[1], scheduledSnapshotPrefix) {
+			labels = append(labels, parts[1])
+		}
+	}
+
+	//labels end with creation time, so they sort chronologically
+	sort.Strings(labels)
+	for ; len(labels) > schedule.Keep; labels = labels[1:] {
+		snapshot := getSnapshotName(schedule.Container, schedule.Partition, labels[0])
+		log.Check(log.WarnLevel, "Removing scheduled snapshot "+snapshot, fs.RemoveDataset(snapshot, schedule.Partition == "all"))
+	}
+}
@@ -9,6 +9,7 @@ import (
 
 // LxcStart starts a Subutai container and checks if container state changed to "running" or "starting".
 // If state is not changing for 60 seconds, then the "start" operation is considered to have failed.
+// Several containers are started in dependency order, each one after its dependencies pass readiness probe.
 func LxcStart(names ...string) {
 	needHeartBeat := false
 	defer func() {
@@ -17,8 +18,10 @@ func LxcStart(names ...string) {
 		}
 	}()
 
-	for _, name := range names {
+	//containers are started after containers they depend on are ready
+	for _, name := range container.StartOrder(names) {
 		if container.LxcInstanceExists(name) && container.State(name) == container.Stopped {
+			container.WaitDependencies(name)
 			startErr := container.Start(name)
 			for i := 0; i < 60 && startErr != nil; i++ {
 				log.Info("Waiting for container start (60 sec)")
@@ -33,6 +36,7 @@ func LxcStart(names ...string) {
 				}
 			} else {
 				needHeartBeat = true
+				resetRestarts(name)
 				log.Info(name + " started")
 			}
 		}
@@ -1,12 +1,25 @@
 package cli
 
 import (
+	"time"
+
 	"github.com/subutai-io/agent/lib/container"
 	"github.com/subutai-io/agent/log"
 )
 
 // LxcStop stops a Subutai container with an additional state check.
+// Container is given default timeout to run its pre-stop hook and shut down,
+// so callers like export and snapshot wait up to 2 minutes for container which does not shut down.
 func LxcStop(names ...string) {
+	LxcStopTimeout(container.DefaultStopTimeout, false, names...)
+}
+
+// LxcStopTimeout stops containers gracefully: pre-stop hook declared in container config is executed
+// and container is shut down, container still running after timeout is stopped forcibly.
+// With force containers are stopped right away.
+func LxcStopTimeout(timeout time.Duration, force bool, names ...string) {
+	checkArgument(timeout >= 0, "Invalid stop timeout %s", timeout)
+
 	needHeartBeat := false
 	defer func() {
 		if needHeartBeat {
@@ -16,11 +29,16 @@ func LxcStop(names ...string) {
 
 	for _, name := range names {
 		if container.LxcInstanceExists(name) && container.State(name) == container.Running {
+			if !force {
+				log.Info("Stopping " + name + ", waiting up to " + timeout.String() + " for it to shut down")
+			}
 			defer sendHeartbeat()
-			stopErr := container.Stop(name)
+			stopErr := container.Stop(name, timeout, force)
+			//container which survived graceful stop is not waited for again
 			for i := 0; i < 60 && stopErr != nil; i++ {
 				log.Info("Waiting for container stop (60 sec)")
-				stopErr = container.Stop(name)
+				time.Sleep(time.Second)
+				stopErr = container.Stop(name, 0, true)
 			}
 			if stopErr != nil {
 				if len(names) > 0 {
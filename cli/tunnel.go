@@ -2,7 +2,6 @@ package cli
 
 import (
 	"bufio"
-	"fmt"
 	"io/ioutil"
 	"net"
 	"os/exec"
@@ -30,7 +29,8 @@ var (
 )
 
 // TunAdd adds tunnel to specified network socket
-func AddSshTunnel(socket, timeout string, ssh bool) {
+// returns tunnel entrance socket or ssh connection string
+func AddSshTunnel(socket, timeout string, ssh bool) string {
 	if len(socket) == 0 {
 		log.Error("Please specify socket")
 	}
@@ -50,12 +50,10 @@ func AddSshTunnel(socket, timeout string, ssh bool) {
 		log.Check(log.ErrorLevel, "Updating tunnel entry", db.UpdateTunnel(item))
 		if ssh {
 			tunnel := strings.Split(item.RemoteSocket, ":")
-			fmt.Println("ssh root@" + tunnel[0] + " -p " + tunnel[1])
-		} else {
-			fmt.Println(item.RemoteSocket)
+			return "ssh root@" + tunnel[0] + " -p " + tunnel[1]
 		}
 
-		return
+		return item.RemoteSocket
 	}
 
 	log.Check(log.WarnLevel, "Setting key permissions", os.Chmod(path.Join(config.Agent.DataPrefix, "ssh.pem"), 0600))
@@ -75,11 +73,6 @@ func AddSshTunnel(socket, timeout string, ssh bool) {
 		log.Debug("Ssh tunnel output: \n" + string(line))
 		if strings.Contains(string(line), "Allocated port") {
 			port := strings.Fields(string(line))
-			if ssh {
-				fmt.Println("ssh root@" + tunsrv + " -p " + port[2])
-			} else {
-				fmt.Println(tunsrv + ":" + port[2])
-			}
 			tunnel := &db.SshTunnel{
 				Pid:          cmd.Process.Pid,
 				Ttl:          -1,
@@ -92,12 +85,16 @@ func AddSshTunnel(socket, timeout string, ssh bool) {
 				tunnel.Ttl = int(time.Now().Unix()) + tout
 			}
 			log.Check(log.WarnLevel, "Adding new tunnel entry", db.SaveTunnel(tunnel))
-			return
+			if ssh {
+				return "ssh root@" + tunsrv + " -p " + port[2]
+			}
+			return tunnel.RemoteSocket
 		}
 		time.Sleep(1 * time.Second)
 		line, _, err = r.ReadLine()
 	}
 	log.Error("Cannot get tunnel port")
+	return ""
 }
 
 func GetSshTunnels() (list []db.SshTunnel) {
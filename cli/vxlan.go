@@ -9,10 +9,10 @@ import (
 )
 
 type VxlanTunnel struct {
-	Name     string
-	RemoteIp string
-	Vlan     string
-	Vni      string
+	Name     string `json:"name"`
+	RemoteIp string `json:"remoteIp"`
+	Vlan     string `json:"vlan"`
+	Vni      string `json:"vni"`
 }
 
 func AddVxlanTunnel(name, remoteip, vlan, vni string) {
@@ -10,6 +10,10 @@ import (
 
 	"github.com/subutai-io/agent/log"
 	"path"
+	"strings"
+	"github.com/subutai-io/agent/lib/secret"
+	"sync"
+	"errors"
 )
 
 const RhGpgUser = "rh@subutai.io"
@@ -25,18 +29,30 @@ type agentConfig struct {
 	GpgHome       string
 	SshJumpServer string
 	LeStaging     bool
+	//comma separated patterns of environment variable names allowed in Console requests, empty allows any
+	EnvWhitelist string
+	//comma separated patterns of environment variable names rejected in Console requests
+	EnvBlacklist string
 }
 
 type managementConfig struct {
 	Host string
-	//TODO remove or add securePort
-	Port    string
-	Secret  string
-	GpgUser string
+	//comma separated ordered list of Management hosts in form host[@fingerprint], overrides Host and Fingerprint
+	Hosts string
+	//port of public Console endpoints
+	Port string
+	//port of agent endpoints authenticated by client certificate
+	SecurePort string
+	Secret     string
+	GpgUser    string
 	//TODO remove
 	RestPublicKey string
 	Fingerprint   string
 	AllowInsecure bool
+	//deprecated, serve /trigger, /ping and /heartbeat to Console over plain HTTP as well, for Consoles not using daemon API over TLS
+	PlainApi bool
+	//seconds, requests signed earlier are rejected as replays, 0 disables the check
+	RequestWindow int
 }
 
 type influxdbConfig struct {
@@ -71,15 +87,21 @@ const defaultConfig = `
     dataset = subutai/fs
     cacheDir = /var/cache/subutai
     sshJumpServer = cdn.subutai.io
+    envWhitelist =
+    envBlacklist = LD_PRELOAD,LD_LIBRARY_PATH,LD_AUDIT
 
 	[management]
 	host =
+	hosts =
 	port = 8443
+	securePort = 8444
 	secret = secret
 	gpgUser =
 	restPublicKey = /rest/v1/security/keyman/getpublickeyring
     fingerprint =
 	allowInsecure = true
+	plainApi = false
+	requestWindow = 300
 
 	[influxdb]
 	db = metrics
@@ -106,10 +128,29 @@ var (
 	// CDN url and port
 	CDN cdnConfig
 
-	CdnUrl       string
-	ManagementIP string
+	CdnUrl string
+
+	//address of Management host agent currently talks to, changed by discovery and failover
+	managementIP     string
+	managementIPLock sync.RWMutex
 )
 
+// ManagementIP returns address of Management host agent currently talks to
+func ManagementIP() string {
+	managementIPLock.RLock()
+	defer managementIPLock.RUnlock()
+
+	return managementIP
+}
+
+// SetManagementIP switches agent to Management host at passed address
+func SetManagementIP(ip string) {
+	managementIPLock.Lock()
+	defer managementIPLock.Unlock()
+
+	managementIP = ip
+}
+
 func init() {
 	log.Level(log.InfoLevel)
 
@@ -131,15 +172,112 @@ func init() {
 	if config.Agent.GpgHome == "" {
 		config.Agent.GpgHome = path.Join(config.Agent.DataPrefix, ".gnupg")
 	}
+	log.Check(log.ErrorLevel, "Checking gpgPassword option", checkGpgPassword(config.Agent.GpgPassword))
+
 	Agent = config.Agent
 	Influxdb = config.Influxdb
 	Management = config.Management
 	CDN = config.CDN
 
+	//values in form secret:name are kept in secrets store
+	resolveSecrets(&Agent, &Management, &Influxdb, &CDN)
+
 	CdnUrl = "https://" + path.Join(CDN.URL) + ":" + CDN.SSLport + "/rest/v1/cdn"
 
 }
 
+// Secrets returns store of agent secrets encrypted with key derived from RH GPG key.
+// Store is available once RH GPG key is generated.
+func Secrets() (secret.Store, error) {
+	key, err := secret.KeyringKey(path.Join(Agent.GpgHome, "secring.gpg"), Agent.GpgUser, KeyringPassword())
+	if err != nil {
+		return secret.Store{}, err
+	}
+
+	return secret.NewStore(path.Join(Agent.DataPrefix, "agent.db"), key), nil
+}
+
+// KeyringPassword returns password protecting private keys in agent keyrings
+func KeyringPassword() string {
+	return Agent.GpgPassword
+}
+
+//secrets store key is derived from RH key protected by gpgPassword, so gpgPassword can not be kept in the store
+func checkGpgPassword(value string) error {
+	if name, ok := secret.Ref(value); ok {
+		return errors.New("gpgPassword can not refer to secret " + name + ", set the password in agent.conf as plain value")
+	}
+
+	return nil
+}
+
+//replaces string options of config sections holding secret references with secret values,
+//unresolved secrets are replaced with empty values
+func resolveSecrets(sections ...interface{}) {
+	var store *secret.Store
+
+	for _, section := range sections {
+		s := reflect.ValueOf(section).Elem()
+		for i := 0; i < s.NumField(); i++ {
+			field := s.Field(i)
+			if field.Kind() != reflect.String {
+				continue
+			}
+			name, ok := secret.Ref(field.String())
+			if !ok {
+				continue
+			}
+			field.SetString("")
+
+			if store == nil {
+				secrets, err := Secrets()
+				if log.Check(log.WarnLevel, "Opening secrets store", err) {
+					continue
+				}
+				store = &secrets
+			}
+
+			value, err := store.Get(name)
+			if !log.Check(log.WarnLevel, "Resolving secret "+name+" of "+s.Type().Field(i).Name, err) {
+				field.SetString(value)
+			}
+		}
+	}
+}
+
+// ManagementHost is an entry of ordered list of Management hosts agent may talk to
+type ManagementHost struct {
+	Address string
+	//expected fingerprint of Console key, empty matches any
+	Fingerprint string
+}
+
+// ManagementHosts returns configured Management hosts in order of preference.
+// If Hosts option is not set, Host and Fingerprint options make a single entry list.
+// Empty list means that Management host is found by discovery.
+func ManagementHosts() []ManagementHost {
+	var hosts []ManagementHost
+
+	for _, entry := range strings.Split(Management.Hosts, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		host := ManagementHost{Address: entry}
+		if i := strings.LastIndex(entry, "@"); i != -1 {
+			host.Address = strings.TrimSpace(entry[:i])
+			host.Fingerprint = strings.TrimSpace(entry[i+1:])
+		}
+		hosts = append(hosts, host)
+	}
+
+	if len(hosts) == 0 && strings.TrimSpace(Management.Host) != "" {
+		hosts = append(hosts, ManagementHost{Address: strings.TrimSpace(Management.Host), Fingerprint: strings.TrimSpace(Management.Fingerprint)})
+	}
+
+	return hosts
+}
+
 // InitAgentDebug turns on Debug output for the Subutai Agent.
 func InitAgentDebug() {
 	if config.Agent.Debug {
@@ -154,7 +292,8 @@ func SaveDefaultConfig(conf string) error {
 		return nil
 	}
 
-	f, err := os.Create(conf)
+	//config may hold credentials
+	f, err := os.OpenFile(conf, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,23 @@
+package config
+
+import "testing"
+
+func TestCheckGpgPassword(t *testing.T) {
+	tests := []struct {
+		value   string
+		invalid bool
+	}{
+		{"", false},
+		{"12345678", false},
+		{"secret", false},
+		{"secret:", false},
+		{"secret:gpg", true},
+		{" secret: gpg ", true},
+	}
+
+	for _, test := range tests {
+		if err := checkGpgPassword(test.value); (err != nil) != test.invalid {
+			t.Errorf("gpgPassword %q: got error %v, want error %v", test.value, err, test.invalid)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"go.etcd.io/bbolt"
 	"github.com/asdine/storm/q"
 	"fmt"
+	"strings"
 )
 
 var (
@@ -26,6 +27,12 @@ func init() {
 		log.Check(log.ErrorLevel, "Initializing ssh tunnels storage", db.Init(&SshTunnel{}))
 		log.Check(log.ErrorLevel, "Initializing proxy storage", db.Init(&Proxy{}))
 		log.Check(log.ErrorLevel, "Initializing proxied servers storage", db.Init(&ProxiedServer{}))
+		log.Check(log.ErrorLevel, "Initializing command journal", db.Init(&Command{}))
+		log.Check(log.ErrorLevel, "Initializing pending responses storage", db.Init(&PendingResponse{}))
+		log.Check(log.ErrorLevel, "Initializing events storage", db.Init(&Event{}))
+		log.Check(log.ErrorLevel, "Initializing retired keys storage", db.Init(&RetiredKey{}))
+		log.Check(log.ErrorLevel, "Initializing received requests storage", db.Init(&ReceivedRequest{}))
+		log.Check(log.ErrorLevel, "Initializing snapshot schedules storage", db.Init(&SnapshotSchedule{}))
 	}
 
 }
@@ -101,6 +108,99 @@ func SaveMhGpgUsername(username string) (err error) {
 	return err
 }
 
+// GetConsoleCert returns DER encoded certificate of Console at passed host pinned at registration, nil if none is pinned
+func GetConsoleCert(host string) (cert []byte, err error) {
+	var instance *storm.DB
+	if instance, err = getDb(true); err == nil {
+		defer instance.Close()
+		instance.Bolt.View(func(tx *bolt.Tx) error {
+			if b := tx.Bucket([]byte("config")); b != nil {
+				if value := b.Get([]byte("ConsoleCert:" + host)); value != nil {
+					cert = append([]byte{}, value...)
+				}
+			}
+			return nil
+		})
+	}
+	return cert, err
+}
+
+func SaveConsoleCert(host string, cert []byte) (err error) {
+	var instance *storm.DB
+	if instance, err = getDb(false); err == nil {
+		defer instance.Close()
+		return instance.Bolt.Update(func(tx *bolt.Tx) error {
+			var b *bolt.Bucket
+			if b, err = tx.CreateBucketIfNotExists([]byte("config")); err == nil {
+				err = b.Put([]byte("ConsoleCert:"+host), cert)
+			}
+			return err
+		})
+	}
+	return err
+}
+
+// RemoveConsoleCert forgets certificate pinned for Console at passed host, all pinned certificates are removed if host is empty
+func RemoveConsoleCert(host string) (err error) {
+	var instance *storm.DB
+	if instance, err = getDb(false); err == nil {
+		defer instance.Close()
+		return instance.Bolt.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("config"))
+			if b == nil {
+				return nil
+			}
+			var keys [][]byte
+			c := b.Cursor()
+			prefix := []byte("ConsoleCert:" + host)
+			for k, _ := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), string(prefix)); k, _ = c.Next() {
+				if host == "" || string(k) == string(prefix) {
+					keys = append(keys, append([]byte{}, k...))
+				}
+			}
+			for _, k := range keys {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	return err
+}
+
+// GetAppliedProxies returns tags of proxies created for container by `subutai apply`
+func GetAppliedProxies(container string) (tags []string, err error) {
+	var instance *storm.DB
+	if instance, err = getDb(true); err == nil {
+		defer instance.Close()
+		instance.Bolt.View(func(tx *bolt.Tx) error {
+			if b := tx.Bucket([]byte("config")); b != nil {
+				if value := b.Get([]byte("AppliedProxies:" + container)); len(value) > 0 {
+					tags = strings.Split(string(value), ",")
+				}
+			}
+			return nil
+		})
+	}
+	return tags, err
+}
+
+func SaveAppliedProxies(container string, tags []string) (err error) {
+	var instance *storm.DB
+	if instance, err = getDb(false); err == nil {
+		defer instance.Close()
+		return instance.Bolt.Update(func(tx *bolt.Tx) error {
+			var b *bolt.Bucket
+			if b, err = tx.CreateBucketIfNotExists([]byte("config")); err == nil {
+				err = b.Put([]byte("AppliedProxies:"+container), []byte(strings.Join(tags, ",")))
+			}
+			return err
+		})
+	}
+	return err
+}
+
 //Container>>>>>>>
 func SaveContainer(container *Container) (err error) {
 	var db *storm.DB
@@ -113,6 +213,39 @@ func SaveContainer(container *Container) (err error) {
 	return db.Save(container)
 }
 
+// UpdateContainer applies update to current record of container and saves it in one transaction,
+// so that fields changed concurrently by other processes are not overwritten. Missing record is left as is.
+func UpdateContainer(name string, update func(container *Container)) (err error) {
+	var db *storm.DB
+	db, err = getDb(false);
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result := Container{}
+	err = tx.One("Name", name, &result)
+	if err == storm.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	update(&result)
+
+	if err = tx.Save(&result); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 func RemoveContainer(container *Container) (err error) {
 	var db *storm.DB
 	db, err = getDb(false);
@@ -395,3 +528,419 @@ func RemoveTunnel(tunnel SshTunnel) (err error) {
 }
 
 // >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>> Ssh tunnels
+
+// Command journal >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
+
+func SaveCommand(command *Command) (err error) {
+	var db *storm.DB
+	db, err = getDb(false);
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Save(command)
+}
+
+func SetCommandPid(commandId string, pid int, pidStart uint64) (err error) {
+	var db *storm.DB
+	db, err = getDb(false);
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	command := Command{}
+	err = db.One("CommandId", commandId, &command)
+	if err != nil {
+		if err == storm.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+
+	command.Pid = pid
+	command.PidStart = pidStart
+
+	return db.Update(&command)
+}
+
+func RemoveCommand(commandId string) (err error) {
+	var db *storm.DB
+	db, err = getDb(false);
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	command := Command{}
+	err = db.One("CommandId", commandId, &command)
+	if err != nil {
+		if err == storm.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+
+	return db.DeleteStruct(&command)
+}
+
+func GetAllCommands() (commands []Command, err error) {
+	var db *storm.DB
+	db, err = getDb(true);
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.All(&commands)
+
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+
+	return commands, err
+}
+
+func SavePendingResponse(response *PendingResponse) (err error) {
+	var db *storm.DB
+	db, err = getDb(false);
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Save(response)
+}
+
+func RemovePendingResponse(response *PendingResponse) (err error) {
+	var db *storm.DB
+	db, err = getDb(false);
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.DeleteStruct(response)
+}
+
+func GetPendingResponse(id int) (response *PendingResponse, err error) {
+	var db *storm.DB
+	db, err = getDb(true);
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	result := PendingResponse{}
+	err = db.One("Id", id, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+//returns up to limit pending responses of command saved after response with passed id, in order of saving
+func GetPendingResponses(commandID string, afterId int, limit int) (responses []PendingResponse, err error) {
+	var db *storm.DB
+	db, err = getDb(true);
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	//records are stored in order of their incremented ids
+	err = db.Select(q.Eq("CommandId", commandID), q.Gt("Id", afterId)).Limit(limit).Find(&responses)
+
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+
+	return responses, err
+}
+
+//returns number of pending responses by command id, responses are read one by one
+func GetPendingCommands() (commands map[string]int, err error) {
+	var db *storm.DB
+	db, err = getDb(true);
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	commands = make(map[string]int)
+	err = db.Select().Each(new(PendingResponse), func(record interface{}) error {
+		commands[record.(*PendingResponse).CommandId]++
+		return nil
+	})
+
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+
+	return commands, err
+}
+
+// >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>> Command journal
+
+// Events >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
+
+func SaveEvent(event *Event) (err error) {
+	var db *storm.DB
+	db, err = getDb(false);
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Save(event)
+}
+
+//returns up to limit oldest events
+func GetEvents(limit int) (events []Event, err error) {
+	var db *storm.DB
+	db, err = getDb(true);
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.All(&events, storm.Limit(limit))
+
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+
+	return events, err
+}
+
+func RemoveEvents(events []Event) (err error) {
+	var db *storm.DB
+	db, err = getDb(false);
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := range events {
+		if err = tx.DeleteStruct(&events[i]); err != nil && err != storm.ErrNotFound {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+//removes oldest events keeping at most max of them
+func TrimEvents(max int) (removed int, err error) {
+	var db *storm.DB
+	db, err = getDb(false);
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	count, err := db.Count(&Event{})
+	if err != nil || count <= max {
+		return 0, err
+	}
+
+	var events []Event
+	err = db.All(&events, storm.Limit(count-max))
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range events {
+		if err = db.DeleteStruct(&events[i]); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>> Events
+
+// Retired keys >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
+
+func SaveRetiredKey(key *RetiredKey) (err error) {
+	var db *storm.DB
+	db, err = getDb(false);
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Save(key)
+}
+
+func RemoveRetiredKey(key *RetiredKey) (err error) {
+	var db *storm.DB
+	db, err = getDb(false);
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.DeleteStruct(key)
+}
+
+func FindRetiredKeyByFingerprint(fingerprint string) (key *RetiredKey, err error) {
+	var db *storm.DB
+	db, err = getDb(true);
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var result RetiredKey
+	err = db.One("Fingerprint", fingerprint, &result)
+	if err == storm.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+//returns retired keys of owner, all retired keys if owner is empty
+func FindRetiredKeys(owner string) (keys []RetiredKey, err error) {
+	var db *storm.DB
+	db, err = getDb(true);
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	if owner == "" {
+		err = db.All(&keys)
+	} else {
+		err = db.Find("Owner", owner, &keys)
+	}
+
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+
+	return keys, err
+}
+
+// >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>> Retired keys
+
+// Received requests >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
+
+//remembers request with passed key, returns false if request has been already received,
+//requests received earlier than keep period ago are forgotten
+func SaveReceivedRequest(key string, keep time.Duration) (fresh bool, err error) {
+	var db *storm.DB
+	db, err = getDb(false);
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var expired []ReceivedRequest
+	err = tx.Select(q.Lt("Received", time.Now().Add(-keep))).Find(&expired)
+	if err != nil && err != storm.ErrNotFound {
+		return false, err
+	}
+	for i := range expired {
+		if err = tx.DeleteStruct(&expired[i]); err != nil {
+			return false, err
+		}
+	}
+
+	var existing ReceivedRequest
+	err = tx.One("Key", key, &existing)
+	if err == nil {
+		return false, tx.Commit()
+	} else if err != storm.ErrNotFound {
+		return false, err
+	}
+
+	if err = tx.Save(&ReceivedRequest{Key: key, Received: time.Now()}); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>> Received requests
+
+// Snapshot schedules >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>
+
+func SaveSnapshotSchedule(schedule *SnapshotSchedule) (err error) {
+	var db *storm.DB
+	db, err = getDb(false);
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Save(schedule)
+}
+
+func RemoveSnapshotSchedule(schedule *SnapshotSchedule) (err error) {
+	var db *storm.DB
+	db, err = getDb(false);
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.DeleteStruct(schedule)
+}
+
+//returns snapshot schedule of container, nil if container has no schedule
+func FindSnapshotSchedule(container string) (schedule *SnapshotSchedule, err error) {
+	var db *storm.DB
+	db, err = getDb(true);
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var result SnapshotSchedule
+	err = db.One("Container", container, &result)
+	if err == storm.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func FindSnapshotSchedules() (schedules []SnapshotSchedule, err error) {
+	var db *storm.DB
+	db, err = getDb(true);
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.All(&schedules)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+
+	return schedules, err
+}
+
+// >>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>>> Snapshot schedules
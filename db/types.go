@@ -1,5 +1,7 @@
 package db
 
+import "time"
+
 type Proxy struct {
 	Id             int    `storm:"id,increment"`
 	Protocol       string `storm:"index"`
@@ -45,4 +47,88 @@ type Container struct {
 	TemplateOwner   string
 	TemplateVersion string
 	TemplateId      string
+	//applied by daemon to container supposed to be running: always, on-failure:N or never, empty means always
+	RestartPolicy string
+	//restarts made by daemon since container was last started by user or kept running long enough
+	Restarts int
+	//time of the last restart attempt, failed one included
+	LastRestart time.Time
+	LastFailure string
+	//set once restarts allowed by policy are exhausted, daemon stops restarting container
+	Failed bool
+	//containers started and ready before this one
+	DependsOn []string
+	//containers independent of each other are started in order of priority, higher first
+	Priority int
+	//command executed inside container, exit code 0 means container is ready for its dependents
+	ReadinessProbe string
+	//run by daemon on interval: exec:{command}, tcp:{port} or http:{port}[/{path}], empty disables checks
+	HealthCheck    string
+	HealthInterval time.Duration
+	//consecutive failures after which container is restarted according to restart policy, zero never restarts
+	HealthRetries int
+	//result of the last checks: starting, healthy or unhealthy
+	Health          string
+	HealthFailures  int
+	HealthOutput    string
+	LastHealthCheck time.Time
+}
+
+//command accepted from Console, kept until its final response is handed over for delivery
+type Command struct {
+	Id        int    `storm:"id,increment"`
+	CommandId string `storm:"unique"`
+	HostId    string
+	Container string
+	Pid       int
+	//process start time in clock ticks since boot, protects from pid reuse
+	PidStart uint64
+	Timeout  int
+	Started  time.Time
+}
+
+//encrypted response not yet accepted by Console
+type PendingResponse struct {
+	Id        int    `storm:"id,increment"`
+	CommandId string `storm:"index"`
+	Message   []byte
+	Deadline time.Time
+}
+
+//event waiting for delivery to Console
+type Event struct {
+	Id        int    `storm:"id,increment"`
+	Type      string `storm:"index"`
+	Container string
+	Details   map[string]string
+	Time      time.Time
+}
+
+//GPG key replaced by rotation, kept for decrypting in-flight requests until Expires
+type RetiredKey struct {
+	Id          int    `storm:"id,increment"`
+	Fingerprint string `storm:"unique"`
+	//RH gpg user or container name
+	Owner   string `storm:"index"`
+	Keyring string
+	Expires time.Time
+}
+
+//request accepted from Console, remembered to refuse its replays
+type ReceivedRequest struct {
+	Id int `storm:"id,increment"`
+	//request type and command id
+	Key      string    `storm:"unique"`
+	Received time.Time `storm:"index"`
+}
+
+//periodic snapshots of container partition, taken by daemon
+type SnapshotSchedule struct {
+	Id        int    `storm:"id,increment"`
+	Container string `storm:"unique"`
+	Partition string
+	Interval  time.Duration
+	//number of scheduled snapshots kept, older ones are removed
+	Keep int
+	Last time.Time
 }
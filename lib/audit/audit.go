@@ -0,0 +1,246 @@
+// Package audit keeps append-only log of privileged operations performed on Resource host.
+// Entries are chained by hashes, so that removal or modification of any entry breaks the chain.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/log"
+)
+
+// CommandIDEnv is set for commands executed on behalf of Console, so that nested CLI calls are attributed to Console command
+const CommandIDEnv = "SUBUTAI_COMMAND_ID"
+
+//last line is searched in this tail of log first
+const tailSize = 64 * 1024
+
+// Entry describes single privileged operation
+type Entry struct {
+	Time time.Time `json:"time"`
+	//Console command id in form command:{id}, local user in form uid:{uid} or uid:{uid}({name}) for sudo, API client in form remote:{ip}
+	Who string `json:"who"`
+	//CLI command or Console request command
+	What      string   `json:"what"`
+	Args      []string `json:"args,omitempty"`
+	Container string   `json:"container,omitempty"`
+	//"success" or error message
+	Outcome string `json:"outcome"`
+	//milliseconds
+	Duration int64 `json:"duration"`
+	//hash of previous entry, empty for the first one
+	Prev string `json:"prev"`
+	Hash string `json:"hash"`
+}
+
+func logFile() string {
+	return path.Join(config.Agent.DataPrefix, "audit.log")
+}
+
+// Who returns identity of current process initiator: Console command if process is spawned by executer, local user otherwise
+func Who() string {
+	if id := os.Getenv(CommandIDEnv); id != "" {
+		return "command:" + id
+	}
+
+	//sudo keeps invoking user in environment
+	if uid := os.Getenv("SUDO_UID"); uid != "" && os.Getuid() == 0 {
+		return "uid:" + uid + "(" + os.Getenv("SUDO_USER") + ")"
+	}
+
+	return "uid:" + strconv.Itoa(os.Getuid())
+}
+
+// Mask returns copy of subutai CLI arguments, without program name, with values of secret flags and arguments replaced by ***.
+// It is set by CLI, which knows its flags.
+var Mask = MaskAll
+
+// MaskAll returns copy of arguments with every argument replaced by ***
+func MaskAll(args []string) []string {
+	masked := make([]string, len(args))
+	for i := range masked {
+		masked[i] = "***"
+	}
+
+	return masked
+}
+
+// Start returns function which records operation with its outcome and duration measured since Start call.
+// err passed to returned function is the operation outcome, nil means success.
+func Start(who, what string, args []string, container string) func(err error) {
+	started := time.Now()
+	recorded := false
+
+	return func(err error) {
+		//operation is recorded once even if process is stopped on error after deferred recording is set up
+		if recorded {
+			return
+		}
+		recorded = true
+
+		outcome := "success"
+		if err != nil {
+			outcome = err.Error()
+		}
+
+		log.Check(log.WarnLevel, "Writing audit entry", Record(Entry{
+			Time:      started,
+			Who:       who,
+			What:      what,
+			Args:      args,
+			Container: container,
+			Outcome:   outcome,
+			Duration:  int64(time.Since(started) / time.Millisecond),
+		}))
+	}
+}
+
+// Record appends entry to audit log chaining it to the last entry
+func Record(entry Entry) error {
+	if err := os.MkdirAll(path.Dir(logFile()), 0700); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(logFile(), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	//log is written by daemon and CLI processes
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	last, err := lastEntry(f)
+	if err != nil {
+		return err
+	}
+
+	entry.Prev = last.Hash
+	if entry.Hash, err = hash(entry); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(append(data, '\n'))
+
+	return err
+}
+
+// List returns entries recorded since passed time for passed container, empty container matches any.
+// Entries are verified, error is returned together with entries if chain is broken.
+func List(since time.Time, container string) ([]Entry, error) {
+	f, err := os.Open(logFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	var broken error
+	prev := ""
+
+	reader := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		} else if err != nil && err != io.EOF {
+			return entries, err
+		}
+
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return entries, errors.New("Malformed audit entry " + strconv.Itoa(n))
+		}
+
+		if broken == nil {
+			if h, err := hash(entry); err != nil || entry.Prev != prev || h != entry.Hash {
+				broken = errors.New("Audit log chain is broken at entry " + strconv.Itoa(n))
+			}
+		}
+		prev = entry.Hash
+
+		if entry.Time.Before(since) || (container != "" && !affects(entry, container)) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, broken
+}
+
+//operations on several containers list them separated by comma
+func affects(entry Entry, container string) bool {
+	for _, name := range strings.Split(entry.Container, ",") {
+		if name == container {
+			return true
+		}
+	}
+
+	return false
+}
+
+//returns hash of entry chained with previous one
+func hash(entry Entry) (string, error) {
+	entry.Hash = ""
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+//returns the last entry of log, empty entry if log is empty
+func lastEntry(f *os.File) (Entry, error) {
+	var entry Entry
+
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return entry, err
+	}
+
+	offset := info.Size() - tailSize
+	if offset < 0 {
+		offset = 0
+	}
+
+	for {
+		tail := make([]byte, info.Size()-offset)
+		if _, err = f.ReadAt(tail, offset); err != nil && err != io.EOF {
+			return entry, err
+		}
+
+		tail = bytes.TrimRight(tail, "\n")
+		if i := bytes.LastIndexByte(tail, '\n'); i != -1 || offset == 0 {
+			return entry, json.Unmarshal(tail[i+1:], &entry)
+		}
+
+		//entry is longer than tail, read the whole log
+		offset = 0
+	}
+}
@@ -0,0 +1,186 @@
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/subutai-io/agent/config"
+)
+
+//points audit log to temporary directory, returned function restores it
+func tempLog(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataPrefix := config.Agent.DataPrefix
+	config.Agent.DataPrefix = dir
+
+	return func() {
+		config.Agent.DataPrefix = dataPrefix
+		os.RemoveAll(dir)
+	}
+}
+
+func record(t *testing.T, entries ...Entry) {
+	for _, entry := range entries {
+		if err := Record(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	defer tempLog(t)()
+
+	start := time.Now()
+	record(t,
+		Entry{Time: start.Add(-2 * time.Hour), Who: "uid:0", What: "clone", Container: "foo", Outcome: "success"},
+		Entry{Time: start.Add(-time.Hour), Who: "uid:0", What: "destroy", Container: "bar", Outcome: "success"},
+		Entry{Time: start, Who: "command:1", What: "stop", Container: "foo,bar", Outcome: "Exit code 1"},
+		Entry{Time: start, Who: "remote:10.10.10.1", What: "api", Outcome: "Rejected: Unknown route"},
+	)
+
+	tests := []struct {
+		name      string
+		since     time.Time
+		container string
+		want      []string
+	}{
+		{"all entries", time.Time{}, "", []string{"clone", "destroy", "stop", "api"}},
+		{"since time", start.Add(-90 * time.Minute), "", []string{"destroy", "stop", "api"}},
+		{"container", time.Time{}, "foo", []string{"clone", "stop"}},
+		{"container of multi-container operation", time.Time{}, "bar", []string{"destroy", "stop"}},
+		{"container and time", start.Add(-90 * time.Minute), "foo", []string{"stop"}},
+		{"unknown container", time.Time{}, "baz", nil},
+		{"container name is not a prefix match", time.Time{}, "fo", nil},
+	}
+
+	for _, tt := range tests {
+		entries, err := List(tt.since, tt.container)
+		if err != nil {
+			t.Errorf("%s: List() returned %v", tt.name, err)
+		}
+
+		var got []string
+		for _, entry := range entries {
+			got = append(got, entry.What)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: List() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestListDetectsBrokenChain(t *testing.T) {
+	tests := []struct {
+		name string
+		//modifies lines of valid log
+		tamper func(lines [][]byte) [][]byte
+		broken bool
+	}{
+		{"intact log", func(lines [][]byte) [][]byte {
+			return lines
+		}, false},
+		{"modified entry", func(lines [][]byte) [][]byte {
+			lines[1] = bytes.Replace(lines[1], []byte(`"outcome":"success"`), []byte(`"outcome":"denied"`), 1)
+			return lines
+		}, true},
+		{"removed entry", func(lines [][]byte) [][]byte {
+			return append(lines[:1], lines[2:]...)
+		}, true},
+		{"removed first entry", func(lines [][]byte) [][]byte {
+			return lines[1:]
+		}, true},
+		{"reordered entries", func(lines [][]byte) [][]byte {
+			lines[0], lines[1] = lines[1], lines[0]
+			return lines
+		}, true},
+	}
+
+	for _, tt := range tests {
+		func() {
+			defer tempLog(t)()
+
+			for _, what := range []string{"clone", "start", "stop"} {
+				record(t, Entry{Time: time.Now(), Who: "uid:0", What: what, Outcome: "success"})
+			}
+
+			data, err := ioutil.ReadFile(logFile())
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := tt.tamper(bytes.SplitAfter(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")))
+			for i := range lines {
+				lines[i] = append(bytes.TrimSuffix(lines[i], []byte("\n")), '\n')
+			}
+			if err = ioutil.WriteFile(logFile(), bytes.Join(lines, nil), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err = List(time.Time{}, ""); (err != nil) != tt.broken {
+				t.Errorf("%s: List() returned %v, broken chain expected %t", tt.name, err, tt.broken)
+			}
+		}()
+	}
+}
+
+func TestLastEntry(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []Entry
+	}{
+		{"empty log", nil},
+		{"single entry", []Entry{{What: "clone"}}},
+		{"several entries", []Entry{{What: "clone"}, {What: "start"}}},
+		{"last entry longer than tail", []Entry{{What: "clone"}, {What: "start", Args: []string{strings.Repeat("x", 2*tailSize)}}}},
+		{"previous entry longer than tail", []Entry{{What: "clone", Args: []string{strings.Repeat("x", 2*tailSize)}}, {What: "start"}}},
+	}
+
+	for _, tt := range tests {
+		func() {
+			defer tempLog(t)()
+
+			record(t, tt.entries...)
+
+			f, err := os.OpenFile(logFile(), os.O_RDWR|os.O_CREATE, 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			last, err := lastEntry(f)
+			if err != nil {
+				t.Errorf("%s: lastEntry() returned %v", tt.name, err)
+				return
+			}
+
+			want := Entry{}
+			if len(tt.entries) > 0 {
+				want = tt.entries[len(tt.entries)-1]
+			}
+			if last.What != want.What || (len(tt.entries) > 0) == (last.Hash == "") {
+				t.Errorf("%s: lastEntry() = %s with hash %q, want %s", tt.name, last.What, last.Hash, want.What)
+			}
+		}()
+	}
+}
+
+func TestStartRecordsOnce(t *testing.T) {
+	defer tempLog(t)()
+
+	done := Start("uid:0", "destroy", []string{"foo"}, "foo")
+	done(errors.New("Container is busy"))
+	done(nil)
+
+	entries, err := List(time.Time{}, "")
+	if err != nil || len(entries) != 1 || entries[0].Outcome != "Container is busy" {
+		t.Errorf("Start() recorded %v, %v, want single failed entry", entries, err)
+	}
+}
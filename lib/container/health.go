@@ -0,0 +1,97 @@
+package container
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//health check kinds: command executed inside container, TCP connect or HTTP GET to container port
+const (
+	HealthExec = "exec"
+	HealthTcp  = "tcp"
+	HealthHttp = "http"
+)
+
+//health statuses of containers with health check
+const (
+	Healthy   = "healthy"
+	Unhealthy = "unhealthy"
+	//no check has completed since container start yet
+	HealthStarting = "starting"
+)
+
+// ParseHealthCheck splits health check in form exec:{command}, tcp:{port} or http:{port}[/{path}] into kind and target
+func ParseHealthCheck(check string) (kind, target string, err error) {
+	parts := strings.SplitN(strings.TrimSpace(check), ":", 2)
+	if len(parts) == 2 {
+		kind, target = strings.ToLower(parts[0]), strings.TrimSpace(parts[1])
+	}
+
+	switch kind {
+	case HealthExec:
+		if target != "" {
+			return kind, target, nil
+		}
+	case HealthTcp, HealthHttp:
+		port := strings.SplitN(target, "/", 2)[0]
+		if p, err := strconv.Atoi(port); err == nil && p > 0 && p < 65536 {
+			return kind, target, nil
+		}
+	}
+
+	return "", "", errors.New("Invalid health check " + check + ", expected exec:{command}, tcp:{port} or http:{port}[/{path}]")
+}
+
+// CheckHealth runs health check against running container, nil means container is healthy
+func CheckHealth(name, check string, timeout time.Duration) error {
+	kind, target, err := ParseHealthCheck(check)
+	if err != nil {
+		return err
+	}
+
+	if kind == HealthExec {
+		return checkExec(name, target, timeout)
+	}
+
+	ip := strings.Fields(GetIp(name))
+	if len(ip) == 0 {
+		return errors.New("Container " + name + " has no IP address")
+	}
+
+	if kind == HealthTcp {
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip[0], target), timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get("http://" + ip[0] + ":" + target)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return errors.New("HTTP status " + resp.Status)
+	}
+
+	return nil
+}
+
+//executes command inside container, command which does not finish within timeout is killed
+func checkExec(name, command string, timeout time.Duration) error {
+	out, errOut, res := AttachExecOutputTimeout(name, []string{"/bin/sh", "-c", command}, timeout)
+	if res.Error() != nil {
+		return res.Error()
+	} else if res.ExitCode() != 0 {
+		return errors.New("Exit code " + strconv.Itoa(res.ExitCode()) + ": " + strings.TrimSpace(out+errOut))
+	}
+
+	return nil
+}
@@ -0,0 +1,34 @@
+package container
+
+import "testing"
+
+func TestParseHealthCheck(t *testing.T) {
+	tests := []struct {
+		check   string
+		kind    string
+		target  string
+		invalid bool
+	}{
+		{"exec:pg_isready", HealthExec, "pg_isready", false},
+		{"exec: curl -f localhost:80 ", HealthExec, "curl -f localhost:80", false},
+		{"EXEC:true", HealthExec, "true", false},
+		{"tcp:5432", HealthTcp, "5432", false},
+		{"http:80", HealthHttp, "80", false},
+		{"http:8080/health?full=1", HealthHttp, "8080/health?full=1", false},
+		{"exec:", "", "", true},
+		{"tcp:0", "", "", true},
+		{"tcp:65536", "", "", true},
+		{"tcp:postgres", "", "", true},
+		{"http:/health", "", "", true},
+		{"ping:10.10.10.1", "", "", true},
+		{"5432", "", "", true},
+		{"", "", "", true},
+	}
+
+	for _, tt := range tests {
+		kind, target, err := ParseHealthCheck(tt.check)
+		if (err != nil) != tt.invalid || kind != tt.kind || target != tt.target {
+			t.Errorf("ParseHealthCheck(%q) = %q, %q, %v, want %q, %q, invalid %t", tt.check, kind, target, err, tt.kind, tt.target, tt.invalid)
+		}
+	}
+}
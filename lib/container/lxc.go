@@ -28,6 +28,7 @@ import (
 	"github.com/nightlyone/lockfile"
 	"github.com/subutai-io/agent/lib/common"
 	"io"
+	"github.com/subutai-io/agent/lib/event"
 )
 
 const (
@@ -36,6 +37,28 @@ const (
 	Unknown = "UNKNOWN"
 )
 
+//time given to container to run pre-stop hook and shut down before it is stopped forcibly,
+//so that stop, restart and destroy of container which does not shut down take up to 2 minutes
+const DefaultStopTimeout = 120 * time.Second
+
+//container config items holding hook scripts executed inside container
+const (
+	PreStopHook   = "subutai.hook.prestop"
+	PostStartHook = "subutai.hook.poststart"
+)
+
+//time post-start hook is waited for, so that start is not held up by slow hook, pre-stop hook is limited by stop timeout.
+//Hook which takes longer keeps running.
+const hookTimeout = 10 * time.Second
+
+//restart policies applied by daemon to containers supposed to be running.
+//Exit status of container is not known, so on-failure:N allows N restarts whatever way container went down.
+const (
+	RestartAlways    = "always"
+	RestartOnFailure = "on-failure"
+	RestartNever     = "never"
+)
+
 const Management = "management"
 const ManagementIp = "10.10.10.1"
 const ContainerDefaultIface = "eth0"
@@ -86,6 +109,34 @@ func LxcInstanceExists(name string) bool {
 	return false
 }
 
+// ParseRestartPolicy returns kind of restart policy and, for on-failure policy, number of restarts allowed.
+// Empty policy is always.
+func ParseRestartPolicy(policy string) (kind string, max int, err error) {
+	policy = strings.ToLower(strings.TrimSpace(policy))
+	switch {
+	case policy == "" || policy == RestartAlways:
+		return RestartAlways, 0, nil
+	case policy == RestartNever:
+		return RestartNever, 0, nil
+	case strings.HasPrefix(policy, RestartOnFailure+":"):
+		max, err = strconv.Atoi(strings.TrimPrefix(policy, RestartOnFailure+":"))
+		if err == nil && max > 0 {
+			return RestartOnFailure, max, nil
+		}
+	}
+
+	return "", 0, errors.New("Invalid restart policy " + policy + ", expected always, on-failure:N or never")
+}
+
+// FormatRestartPolicy returns canonical form of policy parsed by ParseRestartPolicy
+func FormatRestartPolicy(kind string, max int) string {
+	if kind == RestartOnFailure {
+		return kind + ":" + strconv.Itoa(max)
+	}
+
+	return kind
+}
+
 // State returns container state in human readable format.
 func State(name string) (state string) {
 	if c, err := lxc.NewContainer(name, config.Agent.LxcPrefix); err == nil {
@@ -114,17 +165,19 @@ func Start(name string) error {
 	SetContainerConf(name, [][]string{
 		{"lxc.start.auto", "1"}})
 
-	v, _ := db.FindContainerByName(name)
-	if v != nil {
-		v.State = Running
-		db.SaveContainer(v)
-	}
+	setState(name, Running)
+
+	runHook(name, PostStartHook, hookTimeout)
+
+	event.Publish(event.ContainerStarted, name, nil)
 
 	return nil
 }
 
 // Stop stops the Subutai container.
-func Stop(name string) error {
+// Pre-stop hook is executed and container is shut down, container still running after timeout is stopped forcibly.
+// With force container is stopped right away, without running the hook.
+func Stop(name string, timeout time.Duration, force bool) error {
 	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
 
 	if log.Check(log.DebugLevel, "Creating container object", err) {
@@ -132,20 +185,26 @@ func Stop(name string) error {
 	}
 	defer lxc.Release(c)
 
-	log.Check(log.DebugLevel, "Stopping LXC container "+name, c.Stop())
+	//record is marked stopped first, so that daemon neither restores nor checks health of container being stopped
+	previous := setState(name, Stopped)
+
+	if !force {
+		shutdown(c, name, timeout)
+	}
 
 	if c.State().String() != Stopped {
+		log.Check(log.DebugLevel, "Stopping LXC container "+name, c.Stop())
+	}
+
+	if c.State().String() != Stopped {
+		setState(name, previous)
 		return errors.New("Unable to stop container " + name)
 	}
 
 	SetContainerConf(name, [][]string{
 		{"lxc.start.auto", ""}})
 
-	v, _ := db.FindContainerByName(name)
-	if v != nil {
-		v.State = Stopped
-		db.SaveContainer(v)
-	}
+	event.Publish(event.ContainerStopped, name, nil)
 
 	return nil
 }
@@ -158,28 +217,95 @@ func Restart(name string) error {
 	}
 	defer lxc.Release(c)
 
+	//daemon does not restore container while it is being restarted
+	previous := setState(name, Stopped)
+
 	if c.State().String() == Running {
-		log.Check(log.DebugLevel, "Stopping LXC container "+name, c.Stop())
+		shutdown(c, name, DefaultStopTimeout)
+		if c.State().String() != Stopped {
+			log.Check(log.DebugLevel, "Stopping LXC container "+name, c.Stop())
+		}
 	}
 
 	log.Check(log.DebugLevel, "Starting LXC container "+name, c.Start())
 
 	if c.State().String() != Running {
+		setState(name, previous)
 		return errors.New("Unable to start container " + name)
 	}
 
 	SetContainerConf(name, [][]string{
 		{"lxc.start.auto", "1"}})
 
-	v, _ := db.FindContainerByName(name)
-	if v != nil {
-		v.State = Running
-		db.SaveContainer(v)
-	}
+	setState(name, Running)
+
+	runHook(name, PostStartHook, hookTimeout)
+
+	event.Publish(event.ContainerRestarted, name, nil)
 
 	return nil
 }
 
+//updates state in container record and returns previous one, empty state is not saved
+func setState(name, state string) (previous string) {
+	if state == "" {
+		return ""
+	}
+
+	log.Check(log.WarnLevel, "Saving state of "+name, db.UpdateContainer(name, func(v *db.Container) {
+		previous, v.State = v.State, state
+	}))
+
+	return previous
+}
+
+//runs pre-stop hook and shuts container down, both within timeout
+func shutdown(c *lxc.Container, name string, timeout time.Duration) {
+	if c.State().String() != Running {
+		return
+	}
+
+	deadline := time.Now().Add(timeout)
+
+	runHook(name, PreStopHook, timeout)
+
+	if remaining := time.Until(deadline); remaining > 0 {
+		log.Check(log.DebugLevel, "Shutting down LXC container "+name, c.Shutdown(remaining))
+	}
+
+	if c.State().String() != Stopped {
+		log.Warn("Container " + name + " did not shut down in " + timeout.String() + ", stopping it forcibly")
+	}
+}
+
+//executes hook script declared in container config inside running container.
+//Hook which does not finish within timeout is left running, its failure does not stop the operation.
+func runHook(name, hook string, timeout time.Duration) {
+	script := strings.TrimSpace(GetProperty(name, hook))
+	if script == "" {
+		return
+	}
+
+	log.Debug("Running " + hook + " hook of " + name)
+
+	done := make(chan bool, 1)
+	go func() {
+		out, errOut, res := AttachExecOutput(name, []string{"/bin/sh", "-c", script})
+		if res.Error() != nil {
+			log.Warn("Running " + hook + " hook of " + name + ": " + res.Error().Error())
+		} else if res.ExitCode() != 0 {
+			log.Warn(hook + " hook of " + name + " exited with code " + strconv.Itoa(res.ExitCode()) + ": " + strings.TrimSpace(out+errOut))
+		}
+		done <- true
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Warn(hook + " hook of " + name + " did not finish in " + timeout.String())
+	}
+}
+
 // AttachExec executes a command inside Subutai container.
 func AttachExec(name string, command []string, env ...[]string) (output []string, err error) {
 	if !LxcInstanceExists(name) {
@@ -254,6 +380,17 @@ func GetErrResult(err error, exitCode int) ErrResult {
 
 // AttachExec executes a command inside Subutai container.
 func AttachExecOutput(name string, command []string, env ...[]string) (output string, errOutput string, errResult ErrResult) {
+	return attachExecOutput(name, command, 0, env...)
+}
+
+// AttachExecOutputTimeout executes a command inside Subutai container like AttachExecOutput,
+// command which does not finish within timeout is killed.
+func AttachExecOutputTimeout(name string, command []string, timeout time.Duration, env ...[]string) (output string, errOutput string, errResult ErrResult) {
+	return attachExecOutput(name, command, timeout, env...)
+}
+
+//zero timeout waits for command to finish
+func attachExecOutput(name string, command []string, timeout time.Duration, env ...[]string) (output string, errOutput string, errResult ErrResult) {
 	if !LxcInstanceExists(name) {
 		return "", "", GetErrResult(errors.New("Container does not exist"), -1)
 	}
@@ -316,7 +453,24 @@ func AttachExecOutput(name string, command []string, env ...[]string) (output st
 			GetErrResult(errors.New(fmt.Sprintf("Failed to find process by pid: %s", err.Error())), -1)
 	}
 
+	var timer *time.Timer
+	killed := make(chan bool, 1)
+	if timeout > 0 {
+		timer = time.AfterFunc(timeout, func() {
+			//process group of attached process if it leads one, so that children of command are killed too
+			if syscall.Kill(-pid, syscall.SIGKILL) != nil {
+				log.Check(log.DebugLevel, "Killing process "+strconv.Itoa(pid), proc.Kill())
+			}
+			killed <- true
+		})
+	}
+
 	procState, err := proc.Wait()
+	if timer != nil && !timer.Stop() {
+		<-killed
+		return string(stdoutBuf.Bytes()), string(stderrBuf.Bytes()),
+			GetErrResult(errors.New("Command did not finish in "+timeout.String()), -1)
+	}
 	log.Check(log.ErrorLevel, "Waiting for process completion", err)
 
 	if !procState.Success() {
@@ -332,6 +486,7 @@ func AttachExecOutput(name string, command []string, env ...[]string) (output st
 }
 
 // Destroy deletes the Subutai container.
+// Running container is shut down first, like on stop its pre-stop hook is executed within DefaultStopTimeout.
 func DestroyContainer(name string) error {
 
 	c, err := lxc.NewContainer(name, config.Agent.LxcPrefix)
@@ -342,7 +497,7 @@ func DestroyContainer(name string) error {
 
 	defer lxc.Release(c)
 
-	log.Check(log.DebugLevel, "Shutting down lxc", c.Shutdown(time.Second*120))
+	shutdown(c, name, DefaultStopTimeout)
 
 	err = Destroy(name, false)
 	for i := 1; err != nil && i < 3; i++ {
@@ -359,6 +514,8 @@ func DestroyContainer(name string) error {
 		log.Check(log.WarnLevel, "Deleting container metadata entry", db.RemoveContainer(cont))
 	}
 
+	event.Publish(event.ContainerDestroyed, name, nil)
+
 	return nil
 }
 
@@ -505,7 +662,9 @@ func QuotaDisk(name, size string) int {
 		vs, err := strconv.Atoi(size)
 		log.Check(log.DebugLevel, "Parsing disk limit "+size, err)
 		err = fs.SetQuota(name, vs)
-		log.Check(log.DebugLevel, "Setting disk limit of container "+name, err)
+		if !log.Check(log.DebugLevel, "Setting disk limit of container "+name, err) {
+			publishQuota(name, "disk", size)
+		}
 	}
 	vr, err := fs.GetQuota(name)
 	log.Check(log.DebugLevel, "Getting disk limit of container "+name, err)
@@ -531,6 +690,7 @@ func QuotaRAM(name string, size string) int {
 		log.Check(log.DebugLevel, "Parsing quota size", err)
 		log.Check(log.DebugLevel, "Setting memory limit", c.SetMemoryLimit(lxc.ByteSize(setLimit*1024*1024)))
 		SetContainerConf(name, [][]string{{"lxc.cgroup.memory.limit_in_bytes", size + "M"}})
+		publishQuota(name, "ram", size)
 	}
 
 	limit, err := c.MemoryLimit()
@@ -553,13 +713,27 @@ func QuotaCPU(name string, size string) int {
 	}
 	log.Check(log.DebugLevel, "Looking for container: "+name, err)
 	cfsPeriod := 100000
-	var quota float32;
-	if size != "" {
-		tmp, err := strconv.Atoi(size)
-		log.Check(log.DebugLevel, "Parsing quota size", err)
-		quota = float32(tmp)
+
+	if size != "" && State(name) == Running {
+		value := CPUQuotaValue(size)
+		log.Check(log.DebugLevel, "Setting cpu.cfs_quota_us", c.SetCgroupItem("cpu.cfs_quota_us", value))
+
+		SetContainerConf(name, [][]string{{"lxc.cgroup.cpu.cfs_quota_us", value}})
+		publishQuota(name, "cpu", size)
 	}
 
+	result, err := strconv.Atoi(c.CgroupItem("cpu.cfs_quota_us")[0])
+	log.Check(log.DebugLevel, "Parsing quota size", err)
+	return result * 100 / cfsPeriod / runtime.NumCPU()
+}
+
+// CPUQuotaValue returns value of cpu.cfs_quota_us QuotaCPU sets for passed quota size
+func CPUQuotaValue(size string) string {
+	cfsPeriod := 100000
+	tmp, err := strconv.Atoi(size)
+	log.Check(log.DebugLevel, "Parsing quota size", err)
+	quota := float32(tmp)
+
 	if quota > 100 {
 		out, err := ioutil.ReadFile("/sys/devices/system/cpu/cpu0/cpufreq/cpuinfo_max_freq")
 		freq, err := strconv.Atoi(strings.TrimSpace(string(out)))
@@ -579,16 +753,7 @@ func QuotaCPU(name string, size string) int {
 		quota = quota * 100 / float32(freq) / float32(runtime.NumCPU())
 	}
 
-	if size != "" && State(name) == Running {
-		value := strconv.Itoa(int(float32(cfsPeriod) * float32(runtime.NumCPU()) * quota / 100))
-		log.Check(log.DebugLevel, "Setting cpu.cfs_quota_us", c.SetCgroupItem("cpu.cfs_quota_us", value))
-
-		SetContainerConf(name, [][]string{{"lxc.cgroup.cpu.cfs_quota_us", value}})
-	}
-
-	result, err := strconv.Atoi(c.CgroupItem("cpu.cfs_quota_us")[0])
-	log.Check(log.DebugLevel, "Parsing quota size", err)
-	return result * 100 / cfsPeriod / runtime.NumCPU()
+	return strconv.Itoa(int(float32(cfsPeriod) * float32(runtime.NumCPU()) * quota / 100))
 }
 
 // QuotaCPUset sets particular cores that can be used by the Subutai container
@@ -602,6 +767,7 @@ func QuotaCPUset(name string, size string) string {
 	if size != "" {
 		log.Check(log.DebugLevel, "Setting cpuset.cpus", c.SetCgroupItem("cpuset.cpus", size))
 		SetContainerConf(name, [][]string{{"lxc.cgroup.cpuset.cpus", size}})
+		publishQuota(name, "cpuset", size)
 	}
 	return c.CgroupItem("cpuset.cpus")[0]
 }
@@ -617,10 +783,15 @@ func QuotaNet(name string, size string) string {
 	nic := GetConfigItem(c.ConfigFileName(), "lxc.network.veth.pair")
 	if size != "" {
 		SetContainerConf(name, [][]string{{"subutai.network.ratelimit", size}})
+		publishQuota(name, "network", size)
 	}
 	return net.RateLimit(nic, size)
 }
 
+func publishQuota(name, resource, size string) {
+	event.Publish(event.QuotaChanged, name, map[string]string{"resource": resource, "value": size})
+}
+
 func CreateContainerConf(confPath string, conf [][]string) error {
 
 	file, err := os.OpenFile(confPath, os.O_CREATE|os.O_RDWR, 0644)
@@ -0,0 +1,59 @@
+package container
+
+import (
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestParseRestartPolicy(t *testing.T) {
+	tests := []struct {
+		policy  string
+		kind    string
+		max     int
+		invalid bool
+	}{
+		{"", RestartAlways, 0, false},
+		{"always", RestartAlways, 0, false},
+		{" Always ", RestartAlways, 0, false},
+		{"never", RestartNever, 0, false},
+		{"on-failure:3", RestartOnFailure, 3, false},
+		{"ON-FAILURE:1", RestartOnFailure, 1, false},
+		{"on-failure", "", 0, true},
+		{"on-failure:", "", 0, true},
+		{"on-failure:0", "", 0, true},
+		{"on-failure:-1", "", 0, true},
+		{"on-failure:x", "", 0, true},
+		{"sometimes", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		kind, max, err := ParseRestartPolicy(tt.policy)
+		if (err != nil) != tt.invalid || kind != tt.kind || max != tt.max {
+			t.Errorf("ParseRestartPolicy(%q) = %q, %d, %v, want %q, %d, invalid %t", tt.policy, kind, max, err, tt.kind, tt.max, tt.invalid)
+		}
+		if err == nil {
+			if again, _, _ := ParseRestartPolicy(FormatRestartPolicy(kind, max)); again != kind {
+				t.Errorf("FormatRestartPolicy(%q, %d) does not parse back", kind, max)
+			}
+		}
+	}
+}
+
+func TestCPUQuotaValue(t *testing.T) {
+	cores := runtime.NumCPU()
+	tests := []struct {
+		size string
+		want string
+	}{
+		{"0", "0"},
+		{"50", strconv.Itoa(50000 * cores)},
+		{"100", strconv.Itoa(100000 * cores)},
+	}
+
+	for _, tt := range tests {
+		if got := CPUQuotaValue(tt.size); got != tt.want {
+			t.Errorf("CPUQuotaValue(%q) = %s, want %s", tt.size, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package container
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/log"
+)
+
+//time dependency is given to become ready before its dependent is started anyway
+const ReadinessTimeout = 120 * time.Second
+
+// StartOrder sorts containers so that each container follows containers it depends on.
+// Containers independent of each other are ordered by priority, higher first, then by name.
+// Containers in dependency cycle are started in priority order.
+func StartOrder(names []string) []string {
+	list, err := db.FindContainers("", "", "")
+	log.Check(log.WarnLevel, "Reading container metadata", err)
+
+	records := make(map[string]db.Container)
+	for _, c := range list {
+		records[c.Name] = c
+	}
+
+	return startOrder(names, records)
+}
+
+//orders containers by their records
+func startOrder(names []string, records map[string]db.Container) []string {
+	var remaining []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			remaining = append(remaining, name)
+		}
+	}
+
+	//dependencies are counted only among containers being ordered
+	pending := make(map[string]int)
+	for _, name := range remaining {
+		for _, dep := range records[name].DependsOn {
+			if seen[dep] && dep != name {
+				pending[name]++
+			}
+		}
+	}
+
+	sort.SliceStable(remaining, func(i, j int) bool {
+		a, b := records[remaining[i]], records[remaining[j]]
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		return remaining[i] < remaining[j]
+	})
+
+	var order []string
+	for len(remaining) > 0 {
+		next := -1
+		for i, name := range remaining {
+			if pending[name] == 0 {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			log.Warn("Containers " + strings.Join(remaining, ", ") + " have cyclic dependencies")
+			next = 0
+		}
+
+		name := remaining[next]
+		order = append(order, name)
+		remaining = append(remaining[:next], remaining[next+1:]...)
+
+		for _, other := range remaining {
+			for _, dep := range records[other].DependsOn {
+				if dep == name {
+					pending[other]--
+				}
+			}
+		}
+	}
+
+	return order
+}
+
+// DependencyCycle returns containers forming dependency cycle if container had passed dependencies, nil if there is none
+func DependencyCycle(name string, dependsOn []string) []string {
+	list, err := db.FindContainers("", "", "")
+	log.Check(log.WarnLevel, "Reading container metadata", err)
+
+	deps := make(map[string][]string)
+	for _, c := range list {
+		deps[c.Name] = c.DependsOn
+	}
+	deps[name] = dependsOn
+
+	return dependencyCycle(name, deps)
+}
+
+//looks for cycle through container in dependencies of containers
+func dependencyCycle(name string, deps map[string][]string) []string {
+	var path []string
+	visited := make(map[string]bool)
+	var visit func(current string) bool
+	visit = func(current string) bool {
+		//cycles not passing through container are not its concern
+		if visited[current] {
+			return false
+		}
+		visited[current] = true
+
+		path = append(path, current)
+		for _, dep := range deps[current] {
+			if dep == name {
+				path = append(path, dep)
+				return true
+			}
+			if visit(dep) {
+				return true
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+
+	if visit(name) {
+		return path
+	}
+
+	return nil
+}
+
+// WaitDependencies waits till running containers which container depends on are ready.
+// Missing or stopped dependencies are reported but not waited for.
+func WaitDependencies(name string) {
+	c, err := db.FindContainerByName(name)
+	if log.Check(log.WarnLevel, "Reading container metadata", err) || c == nil {
+		return
+	}
+
+	for _, dep := range c.DependsOn {
+		if !IsContainer(dep) {
+			log.Warn("Dependency " + dep + " of " + name + " not found")
+		} else if State(dep) != Running {
+			log.Warn("Dependency " + dep + " of " + name + " is not running")
+		} else {
+			log.Check(log.WarnLevel, "Waiting for dependency "+dep+" of "+name, WaitReady(dep, ReadinessTimeout))
+		}
+	}
+}
+
+// WaitReady waits till container passes its readiness probe, container without probe is ready once it is running
+func WaitReady(name string, timeout time.Duration) error {
+	probe := ""
+	if c, err := db.FindContainerByName(name); !log.Check(log.DebugLevel, "Reading container metadata", err) && c != nil {
+		probe = strings.TrimSpace(c.ReadinessProbe)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		if State(name) == Running {
+			if probe == "" {
+				return nil
+			}
+			_, _, res := AttachExecOutput(name, []string{"/bin/sh", "-c", probe})
+			if res.Error() == nil && res.ExitCode() == 0 {
+				return nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return errors.New("Container " + name + " is not ready after " + timeout.String())
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
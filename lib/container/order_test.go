@@ -0,0 +1,84 @@
+package container
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/subutai-io/agent/db"
+)
+
+func TestStartOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		names   []string
+		records []db.Container
+		want    []string
+	}{
+		{"independent containers by priority, then by name",
+			[]string{"b", "a", "c"},
+			[]db.Container{{Name: "c", Priority: 10}},
+			[]string{"c", "a", "b"}},
+		{"dependencies first",
+			[]string{"web", "app", "db"},
+			[]db.Container{{Name: "web", DependsOn: []string{"app"}}, {Name: "app", DependsOn: []string{"db"}}},
+			[]string{"db", "app", "web"}},
+		{"dependency wins over priority",
+			[]string{"app", "db"},
+			[]db.Container{{Name: "app", Priority: 10, DependsOn: []string{"db"}}},
+			[]string{"db", "app"}},
+		{"dependencies not being ordered are ignored",
+			[]string{"web"},
+			[]db.Container{{Name: "web", DependsOn: []string{"db"}}},
+			[]string{"web"}},
+		{"duplicates are removed",
+			[]string{"a", "a"},
+			nil,
+			[]string{"a"}},
+		{"self dependency is ignored",
+			[]string{"a", "b"},
+			[]db.Container{{Name: "a", DependsOn: []string{"a", "b"}}},
+			[]string{"b", "a"}},
+		{"cycle is started in priority order",
+			[]string{"a", "b"},
+			[]db.Container{{Name: "a", DependsOn: []string{"b"}}, {Name: "b", Priority: 5, DependsOn: []string{"a"}}},
+			[]string{"b", "a"}},
+		{"nothing to order",
+			nil,
+			nil,
+			nil},
+	}
+
+	for _, tt := range tests {
+		records := make(map[string]db.Container)
+		for _, c := range tt.records {
+			records[c.Name] = c
+		}
+
+		if got := startOrder(tt.names, records); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: startOrder(%v) = %v, want %v", tt.name, tt.names, got, tt.want)
+		}
+	}
+}
+
+func TestDependencyCycle(t *testing.T) {
+	tests := []struct {
+		name      string
+		container string
+		deps      map[string][]string
+		want      []string
+	}{
+		{"no dependencies", "a", map[string][]string{}, nil},
+		{"no cycle", "a", map[string][]string{"a": {"b"}, "b": {"c"}}, nil},
+		{"self dependency", "a", map[string][]string{"a": {"a"}}, []string{"a", "a"}},
+		{"direct cycle", "a", map[string][]string{"a": {"b"}, "b": {"a"}}, []string{"a", "b", "a"}},
+		{"indirect cycle", "a", map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"a"}}, []string{"a", "b", "c", "a"}},
+		{"cycle not passing through container", "a", map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"b"}}, nil},
+		{"shared dependency is not a cycle", "a", map[string][]string{"a": {"b", "c"}, "b": {"d"}, "c": {"d"}}, nil},
+	}
+
+	for _, tt := range tests {
+		if got := dependencyCycle(tt.container, tt.deps); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: dependencyCycle(%s, %v) = %v, want %v", tt.name, tt.container, tt.deps, got, tt.want)
+		}
+	}
+}
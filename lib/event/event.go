@@ -0,0 +1,38 @@
+// Package event publishes notable changes on Resource host (container lifecycle, quotas, proxies, etc.)
+// Events are buffered in agent database and pushed to Console by subutai daemon.
+package event
+
+import (
+	"time"
+
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/log"
+)
+
+const (
+	ContainerStarted   = "CONTAINER_STARTED"
+	ContainerStopped   = "CONTAINER_STOPPED"
+	ContainerRestarted = "CONTAINER_RESTARTED"
+	ContainerDestroyed = "CONTAINER_DESTROYED"
+	//container started by daemon since it was supposed to be running
+	ContainerRestored = "CONTAINER_RESTORED"
+	QuotaChanged      = "QUOTA_CHANGED"
+	ProxyChanged      = "PROXY_CHANGED"
+	SnapshotCreated   = "SNAPSHOT_CREATED"
+	TemplateImported  = "TEMPLATE_IMPORTED"
+	//Console request refused due to bad signature, age or replay
+	RequestRejected = "REQUEST_REJECTED"
+	//container exhausted restarts allowed by its restart policy
+	ContainerFailed = "CONTAINER_FAILED"
+)
+
+// Publish buffers event for delivery to Console
+// container is empty for events not related to a particular container
+func Publish(eventType, container string, details map[string]string) {
+	log.Check(log.WarnLevel, "Publishing event "+eventType, db.SaveEvent(&db.Event{
+		Type:      eventType,
+		Container: container,
+		Details:   details,
+		Time:      time.Now(),
+	}))
+}
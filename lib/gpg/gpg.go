@@ -2,12 +2,9 @@
 package gpg
 
 import (
-	"bufio"
 	"bytes"
 	"io/ioutil"
 	"os"
-	"os/exec"
-	"path/filepath"
 	"strings"
 	exec2 "github.com/subutai-io/agent/lib/exec"
 
@@ -18,251 +15,369 @@ import (
 	"fmt"
 	"github.com/subutai-io/agent/agent/util"
 	"net/http"
-	"github.com/subutai-io/agent/lib/fs"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+	"crypto"
+	"errors"
+	"io"
+	"time"
 )
 
 var (
-	GPG = "gpg1"
-
 	secureClient *http.Client
 )
 
 func init() {
-	ensureGPGVersion()
-
-	// move .gnupg dir to app home
-	err := os.Setenv("GNUPGHOME", config.Agent.GpgHome)
-	log.Check(log.DebugLevel, "Setting GNUPGHOME environment variable", err)
 	secureClient, _ = util.GetUtil().GetSecureClient(30)
 	if GetPk(config.Agent.GpgUser) == "" {
 		log.Check(log.FatalLevel, "Generating RH gpg key", GenerateKey(config.Agent.GpgUser))
 	}
 }
 
-func ensureGPGVersion() {
-	out, err := exec2.Execute("gpg1", "--version")
+// EncryptFile encrypts file with password, encrypted file is saved next to source with .gpg extension.
+// Output is compatible with gpg --symmetric --cipher-algo AES256
+func EncryptFile(pathToFile, password string) error {
+	src, err := os.Open(pathToFile)
 	if err != nil {
-		out, err = exec2.Execute("gpg", "--version")
-
-		if err != nil {
-			log.Fatal("GPG not found " + out)
-		} else {
-			lines := strings.Split(out, "\n")
-			if len(lines) > 0 && strings.HasPrefix(lines[0], "gpg (GnuPG) ") {
-				version := strings.TrimSpace(strings.TrimPrefix(lines[0], "gpg (GnuPG)"))
-				if strings.HasPrefix(version, "1.4") {
-					GPG = "gpg"
-				} else {
-					log.Fatal("GPG version " + version + " is not compatible with subutai")
-				}
-			} else {
-				log.Fatal("Failed to determine GPG version " + out)
-			}
-		}
-	} else {
-		lines := strings.Split(out, "\n")
-		if len(lines) > 0 && strings.HasPrefix(lines[0], "gpg (GnuPG) ") {
-			version := strings.TrimSpace(strings.TrimPrefix(lines[0], "gpg (GnuPG)"))
-			if strings.HasPrefix(version, "1.4") {
-				GPG = "gpg1"
-			} else {
-				log.Fatal("GPG version " + version + " is not compatible with subutai")
-			}
-		} else {
-			log.Fatal("Failed to determine GPG version " + out)
-		}
+		return err
 	}
-}
+	defer src.Close()
 
-func EncryptFile(pathToFile, password string) error {
-	_, err := exec2.ExecuteNoLog(GPG, "--batch", "--passphrase", password, "--symmetric", "--cipher-algo", "AES256", pathToFile)
+	dst, err := os.Create(pathToFile + ".gpg")
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
 
-	return err
+	w, err := openpgp.SymmetricallyEncrypt(dst, []byte(password), &openpgp.FileHints{FileName: path.Base(pathToFile)},
+		&packet.Config{DefaultCipher: packet.CipherAES256, DefaultHash: crypto.SHA256})
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, src); err != nil {
+		return err
+	}
+
+	return w.Close()
 }
 
+// DecryptFile decrypts file encrypted with password
 func DecryptFile(pathToSrcFile, pathToDestFile, password string) error {
-	_, err := exec2.ExecuteNoLog(GPG, "--batch", "--passphrase", password, "--output", pathToDestFile, "--decrypt", pathToSrcFile)
-
-	return err
-}
+	src, err := os.Open(pathToSrcFile)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
 
-//ImportPk imports Public Key "gpg2 --import pubkey.key" to RH
-func ImportPk(k []byte) error {
-	tmpfile, err := ioutil.TempFile("", "subutai-epub")
-	if log.Check(log.WarnLevel, "Creating gpg public key file", err) {
+	body, err := dearmor(src)
+	if err != nil {
 		return err
 	}
-	_, err = tmpfile.Write(k)
-	if log.Check(log.WarnLevel, "Writing gpg public key to "+tmpfile.Name(), err) {
+
+	//prompt is called again if password does not fit, fail instead of looping
+	prompted := false
+	md, err := openpgp.ReadMessage(body, nil, func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		if prompted {
+			return nil, errors.New("Invalid password")
+		}
+		prompted = true
+		return []byte(password), nil
+	}, nil)
+	if err != nil {
 		return err
 	}
-	log.Check(log.WarnLevel, "Closing "+tmpfile.Name(), tmpfile.Close())
 
-	_, err = exec.Command(GPG, "--import", tmpfile.Name()).CombinedOutput()
-	if log.Check(log.WarnLevel, "Importing gpg public key from "+tmpfile.Name(), err) {
+	dst, err := os.Create(pathToDestFile)
+	if err != nil {
 		return err
 	}
-	log.Check(log.WarnLevel, "Removing temp file", os.Remove(tmpfile.Name()))
-	return nil
+	defer dst.Close()
+
+	_, err = io.Copy(dst, md.UnverifiedBody)
+
+	return err
+}
+
+//ImportPk imports Public Key to RH keyring
+func ImportPk(k []byte) error {
+	_, err := importKeys(rhPubring(), k)
+	log.Check(log.WarnLevel, "Importing gpg public key", err)
+
+	return err
+}
+
+// ImportPkTo imports Public Key to passed keyring
+func ImportPkTo(keyring string, k []byte) error {
+	_, err := importKeys(keyring, k)
+
+	return err
 }
 
 // GetContainerPk returns GPG Public Key for container.
 func GetContainerPk(name string) string {
-	lxcPath := path.Join(config.Agent.LxcPrefix, name, "public.pub")
-	stdout, err := exec.Command("/bin/bash", "-c", GPG+" --no-default-keyring --keyring "+lxcPath+" --export -a "+name+"@subutai.io").Output()
-	log.Check(log.WarnLevel, "Getting Container public key", err)
-	return string(stdout)
+	return ExportPk(containerPubring(name), name+"@subutai.io")
 }
 
 // GetPk returns GPG Public Key from the Resource Host.
 func GetPk(name string) string {
-	stdout, err := exec.Command(GPG, "--export", "-a", name).Output()
-	if !log.Check(log.WarnLevel, "Getting public key", err) {
-		return string(stdout)
+	return ExportPk(rhPubring(), name)
+}
+
+// ExportPk returns armored GPG Public Key with passed id from keyring, empty string if key is not found
+func ExportPk(keyring, id string) string {
+	entities, err := readKeyring(keyring)
+	if log.Check(log.WarnLevel, "Reading keyring "+keyring, err) {
+		return ""
 	}
-	return ""
+
+	e := findEntity(entities, id)
+	if e == nil {
+		return ""
+	}
+
+	key, err := exportKey(e)
+	if log.Check(log.WarnLevel, "Getting public key", err) {
+		return ""
+	}
+
+	return key
 }
 
 // DecryptWrapper decrypts GPG message.
+// First argument is the message, optional second and third ones are secret and public keyrings, RH keyrings are used by default.
+// Returns empty string if message can not be decrypted or its signature is invalid.
 func DecryptWrapper(args ...string) string {
-	gpg := GPG + " --passphrase " + config.Agent.GpgPassword + " --no-tty"
+	secring, pubring := rhSecring(), rhPubring()
 	if len(args) == 3 {
-		gpg = gpg + " --no-default-keyring --keyring " + args[2] + " --secret-keyring " + args[1]
+		secring, pubring = args[1], args[2]
+	}
+
+	out, _, err := decrypt([]byte(args[0]), secring, pubring)
+	if log.Check(log.WarnLevel, "Decrypting message", err) {
+		return ""
+	}
+
+	return string(out)
+}
+
+// DecryptVerified decrypts GPG message which must be signed by key of signer (user id or fingerprint) from public keyring.
+// Optional arguments are secret and public keyrings, RH keyrings are used by default.
+// Returns decrypted message and time of signing.
+func DecryptVerified(message, signer string, args ...string) (string, time.Time, error) {
+	secring, pubring := rhSecring(), rhPubring()
+	if len(args) >= 2 {
+		secring, pubring = args[0], args[1]
+	}
+
+	out, md, err := decrypt([]byte(message), secring, pubring)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if !md.IsSigned || md.SignedBy == nil || md.Signature == nil {
+		return "", time.Time{}, errors.New("Message is not signed by known key")
+	}
+
+	//several Console keys may be imported after failover, any of them with signer identity is accepted
+	public, err := readKeyring(pubring)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	signedBy := md.SignedBy.Entity
+	if signedBy == nil || findEntity(openpgp.EntityList{signedBy}, signer) == nil || findEntity(public, fingerprint(signedBy)) == nil {
+		return "", time.Time{}, errors.New("Message is not signed by " + signer)
+	}
+
+	return string(out), md.Signature.CreationTime, nil
+}
+
+func decrypt(message []byte, secring, pubring string) ([]byte, *openpgp.MessageDetails, error) {
+	secret, err := readKeyring(secring)
+	if err != nil {
+		return nil, nil, err
+	}
+	public, err := readKeyring(pubring)
+	if err != nil {
+		return nil, nil, err
+	}
+	//requests encrypted for rotated key are accepted during grace period
+	retired, err := readKeyring(retiredKeyring(secring))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	body, err := dearmor(bytes.NewReader(message))
+	if err != nil {
+		return nil, nil, err
 	}
-	command := exec.Command("/bin/bash", "-c", gpg)
-	stdin, err := command.StdinPipe()
-	if err == nil {
-		_, err = stdin.Write([]byte(args[0]))
-		log.Check(log.DebugLevel, "Writing to stdin of gpg", err)
-		log.Check(log.DebugLevel, "Closing stdin of gpg", stdin.Close())
+
+	keys := append(append(append(openpgp.EntityList{}, secret...), retired...), public...)
+	md, err := openpgp.ReadMessage(body, keys, nil, nil)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	output, err := command.Output()
-	log.Check(log.WarnLevel, "Executing command "+gpg, err)
+	//signature is verified once the whole body is read
+	out, err := ioutil.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return nil, nil, err
+	}
+	if md.SignatureError != nil {
+		return nil, nil, md.SignatureError
+	}
 
-	return string(output)
+	return out, md, nil
 }
 
-// EncryptWrapper encrypts GPG message.
+// EncryptWrapper signs message with user key and encrypts it for recipient, returns armored message.
+// Optional arguments are public and secret keyrings, RH keyrings are used by default.
 func EncryptWrapper(user, recipient string, message []byte, args ...string) ([]byte, error) {
-	gpg := GPG + " --batch --passphrase " + config.Agent.GpgPassword + " --trust-model always --armor -u " + user + " -r " + recipient + " --sign --encrypt --no-tty"
+	pubring, secring := rhPubring(), rhSecring()
 	if len(args) >= 2 {
-		gpg = gpg + " --no-default-keyring --keyring " + args[0] + " --secret-keyring " + args[1]
+		pubring, secring = args[0], args[1]
 	}
-	command := exec.Command("/bin/bash", "-c", gpg)
-	stdin, err := command.StdinPipe()
-	if err == nil {
-		_, err = stdin.Write(message)
-		log.Check(log.DebugLevel, "Writing to stdin of gpg", err)
-		log.Check(log.DebugLevel, "Closing stdin of gpg", stdin.Close())
+
+	secret, err := readKeyring(secring)
+	if err != nil {
+		return nil, err
+	}
+	public, err := readKeyring(pubring)
+	if err != nil {
+		return nil, err
+	}
+
+	signer := findEntity(secret, user)
+	if signer == nil {
+		return nil, errors.New("Secret key of " + user + " not found")
 	}
-	return command.Output()
+
+	to := findEntity(public, recipient)
+	if to == nil {
+		return nil, errors.New("Public key of " + recipient + " not found")
+	}
+
+	return encrypt(message, to, signer)
+}
+
+//encrypts message for recipient, signs it if signer is not nil
+func encrypt(message []byte, to, signer *openpgp.Entity) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	armored, err := armor.Encode(buf, "PGP MESSAGE", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := openpgp.Encrypt(armored, []*openpgp.Entity{to}, signer, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(message); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	if err = armored.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
 }
 
 // GenerateKey generates GPG-key for Subutai Agent.
 // This key used for encrypting messages for Subutai Agent.
+// Key of container is written to container keyrings, key of RH is added to RH keyrings.
 func GenerateKey(name string) error {
-	thePath := path.Join(config.Agent.LxcPrefix, name)
-	email := name + "@subutai.io"
-	pass := config.Agent.GpgPassword
-
-	//rh gpg key
-	if !container.LxcInstanceExists(name) {
-		err := os.MkdirAll("/root/.gnupg/", 0700)
-		if log.Check(log.DebugLevel, "Creating /root/.gnupg/", err) {
-			return err
+	if container.LxcInstanceExists(name) {
+		if _, err := os.Stat(containerSecring(name)); err == nil {
+			return nil
 		}
-		thePath = "/root/.gnupg"
-		email = name
-		pass = config.Agent.GpgPassword
-	}
 
-	conf, err := os.Create(thePath + "/defaults")
-	if log.Check(log.FatalLevel, "Writing default key ident", err) {
-		return err
+		//fresh keyrings for container
+		log.Check(log.DebugLevel, "Removing stale public keyring", os.Remove(containerPubring(name)))
+
+		return GenerateKeyring(name, name+"@subutai.io", containerPubring(name), containerSecring(name))
 	}
 
-	_, err = conf.WriteString("%echo Generating default keys\n" +
-		"Key-Type: RSA\n" +
-		"Key-Length: 2048\n" +
-		"Name-Real: " + name + "\n" +
-		"Name-Comment: " + name + " GPG key\n" +
-		"Name-Email: " + email + "\n" +
-		"Expire-Date: 0\n" +
-		"Passphrase: " + pass + "\n" +
-		"%pubring " + thePath + "/public.pub\n" +
-		"%secring " + thePath + "/secret.sec\n" +
-		"%commit\n" +
-		"%echo Done\n")
-	if log.Check(log.DebugLevel, "Writing defaults for gpg", err) {
+	//rh gpg key
+	return GenerateKeyring(name, name, rhPubring(), rhSecring())
+}
+
+// GenerateKeyring generates RSA key pair and adds it to passed keyrings.
+// Private key is protected by agent GPG password and secret keyring is readable by owner only.
+func GenerateKeyring(name, email, pubring, secring string) error {
+	e, err := newEntity(name, email)
+	if err != nil {
 		return err
 	}
 
-	log.Check(log.DebugLevel, "Closing defaults for gpg", conf.Close())
+	importLock.Lock()
+	defer importLock.Unlock()
 
-	if _, err := os.Stat(thePath + "/secret.sec"); os.IsNotExist(err) {
-		if log.Check(log.DebugLevel, "Generating key", exec2.Exec(GPG, "--batch", "--gen-key", thePath+"/defaults")) {
-			return err
-		}
+	secret, err := readKeyring(secring)
+	if err != nil {
+		return err
+	}
+	if err = writeKeyring(secring, append(append(openpgp.EntityList{}, secret...), e), true); err != nil {
+		return err
 	}
 
-	//rh gpg key
-	if !container.LxcInstanceExists(name) {
-		out, err := exec.Command(GPG, "--allow-secret-key-import", "--import", "/root/.gnupg/secret.sec").CombinedOutput()
-		if log.Check(log.DebugLevel, "Importing secret key "+string(out), err) {
-			fs.RemoveFilesWildcard(filepath.Join(config.Agent.GpgHome, "*.lock"))
-			return err
-		}
-		out, err = exec.Command(GPG, "--import", "/root/.gnupg/public.pub").CombinedOutput()
-		if log.Check(log.DebugLevel, "Importing public key "+string(out), err) {
-			fs.RemoveFilesWildcard(filepath.Join(config.Agent.GpgHome, "*.lock"))
-			return err
-		}
+	public, err := readKeyring(pubring)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return writeKeyring(pubring, append(append(openpgp.EntityList{}, public...), e), false)
 }
 
-var rhFingeprint string
+//generates RSA key pair with user id used by agent keys
+func newEntity(name, email string) (*openpgp.Entity, error) {
+	return openpgp.NewEntity(name, name+" GPG key", email, &packet.Config{RSABits: 2048, DefaultHash: crypto.SHA256})
+}
 
+// GetRhFingerprint returns fingerprint of RH key.
+// Fingerprint is not cached since RH key may be rotated while agent is running.
 func GetRhFingerprint() string {
-	if rhFingeprint != "" {
-		return rhFingeprint
-	}
-
 	if config.Agent.GpgUser == "" {
-		rhFingeprint = strings.TrimSpace(GetFingerprint(config.RhGpgUser))
-	} else {
-		rhFingeprint = strings.TrimSpace(GetFingerprint(config.Agent.GpgUser))
+		return strings.TrimSpace(GetFingerprint(config.RhGpgUser))
 	}
 
-	return rhFingeprint
+	return strings.TrimSpace(GetFingerprint(config.Agent.GpgUser))
 }
 
 // GetFingerprint returns fingerprint of the Subutai container.
 func GetFingerprint(email string) string {
-	var out []byte
-	var err error
 	if email == config.Agent.GpgUser {
-		out, err = exec2.ExecB(GPG, "--fingerprint", email)
-		log.Check(log.DebugLevel, "Getting fingerprint by "+email, err)
-	} else {
-		out, err = exec2.ExecB(GPG, "--fingerprint", "--no-default-keyring", "--keyring", path.Join(config.Agent.LxcPrefix, email, "public.pub"))
-		log.Check(log.DebugLevel, "Getting fingerprint by "+email, err)
-	}
-	scanner := bufio.NewScanner(bytes.NewReader(out))
-	for scanner.Scan() {
-		if strings.Contains(scanner.Text(), "fingerprint") {
-			fp := strings.Split(scanner.Text(), "=")
-			if len(fp) > 1 {
-				return strings.Replace(fp[1], " ", "", -1)
-			}
-		}
+		return KeyringFingerprint(rhPubring(), email)
+	}
+
+	entities, err := readKeyring(containerPubring(email))
+	log.Check(log.DebugLevel, "Getting fingerprint by "+email, err)
+
+	if e := findEntity(entities, email+"@subutai.io"); e != nil {
+		return fingerprint(e)
 	}
+	//keys generated by older agents may have different user id, container key goes first
+	if len(entities) > 0 {
+		return fingerprint(entities[0])
+	}
+
+	return ""
+}
+
+// KeyringFingerprint returns fingerprint of key with passed id, empty string if key is not found
+func KeyringFingerprint(keyring, id string) string {
+	entities, err := readKeyring(keyring)
+	log.Check(log.DebugLevel, "Getting fingerprint by "+id, err)
+
+	if e := findEntity(entities, id); e != nil {
+		return fingerprint(e)
+	}
+
 	return ""
 }
 
-func installMgmtKey(c string) {
+func installMgmtKey(c string) []byte {
 
 	consolePublicKey, err := util.GetConsolePubKey()
 	log.Check(log.FatalLevel, "Getting Console public key", err)
@@ -273,44 +388,12 @@ func installMgmtKey(c string) {
 
 	err = ioutil.WriteFile(path.Join(config.Agent.LxcPrefix, c, "mgn.key"), consolePublicKey, 0644)
 	log.Check(log.FatalLevel, "Saving Console public key", err)
-}
-
-func parseKeyID(s string) string {
-	var id string
-
-	line := strings.Split(s, "\n")
-	if len(line) > 2 {
-		cell := strings.Split(line[1], " ")
-		if len(cell) > 3 {
-			key := strings.Split(cell[3], "/")
-			if len(key) > 1 {
-				id = key[1]
-			}
-		}
-	}
-	if len(id) == 0 {
-		log.Fatal("Key id parsing error")
-	}
-	return id
-}
-
-func writeData(c, t, n, m string) {
-	log.Check(log.DebugLevel, "Removing "+path.Join(config.Agent.LxcPrefix, c, "stdin.txt.asc"), os.Remove(path.Join(config.Agent.LxcPrefix, c, "stdin.txt.asc")))
-	log.Check(log.DebugLevel, "Removing "+path.Join(config.Agent.LxcPrefix, c, "stdin.txt"), os.Remove(path.Join(config.Agent.LxcPrefix, c, "stdin.txt")))
 
-	token := []byte(t + "\n" + GetFingerprint(c) + "\n" + n + m)
-	err := ioutil.WriteFile(path.Join(config.Agent.LxcPrefix, c, "stdin.txt"), token, 0644)
-	log.Check(log.FatalLevel, "Writing Management public key", err)
+	return consolePublicKey
 }
 
-func sendData(c string) {
-	asc, err := os.Open(path.Join(config.Agent.LxcPrefix, c, "stdin.txt.asc"))
-	log.Check(log.FatalLevel, "Reading encrypted stdin.txt.asc", err)
-	defer asc.Close()
-
-	resp, err := secureClient.Post("https://"+path.Join(config.ManagementIP)+":8444/rest/v1/registration/verify/container-token", "text/plain", asc)
-	log.Check(log.DebugLevel, "Removing "+path.Join(config.Agent.LxcPrefix, c, "stdin.txt.asc"), os.Remove(path.Join(config.Agent.LxcPrefix, c, "stdin.txt.asc")))
-	log.Check(log.DebugLevel, "Removing "+path.Join(config.Agent.LxcPrefix, c, "stdin.txt"), os.Remove(path.Join(config.Agent.LxcPrefix, c, "stdin.txt")))
+func sendData(c string, asc []byte) {
+	resp, err := secureClient.Post("https://"+path.Join(config.ManagementIP())+":"+config.Management.SecurePort+"/rest/v1/registration/verify/container-token", "text/plain", bytes.NewReader(asc))
 	log.Check(log.FatalLevel, "Sending container registration request to management", err)
 	defer util.Close(resp)
 	if resp.StatusCode != 200 && resp.StatusCode != 202 {
@@ -323,30 +406,21 @@ func sendData(c string) {
 // Sends container's GPG public key to the Management server. It requires encrypting and singing message
 // received from the Management server.
 func ExchangeAndEncrypt(c, t string) {
-	var impout, expout, imperr, experr bytes.Buffer
-
-	installMgmtKey(c)
-
 	//import mgmt key to container
-	impkey := exec.Command(GPG, "-v", "--no-default-keyring", "--keyring", path.Join(config.Agent.LxcPrefix, c, "public.pub"), "--import", path.Join(config.Agent.LxcPrefix, c, "mgn.key"))
-	impkey.Stdout = &impout
-	impkey.Stderr = &imperr
-	err := impkey.Run()
+	imported, err := importKeys(containerPubring(c), installMgmtKey(c))
 	log.Check(log.FatalLevel, "Importing Management public key to keyring", err)
 
-	id := parseKeyID(imperr.String())
-	expkey := exec.Command(GPG, "--no-default-keyring", "--keyring", path.Join(config.Agent.LxcPrefix, c, "public.pub"), "--export", "--armor", c+"@subutai.io")
-	expkey.Stdout = &expout
-	expkey.Stderr = &experr
-	err = expkey.Run()
-	log.Check(log.FatalLevel, "Exporting armored key", err)
+	pk := GetContainerPk(c)
+	if pk == "" {
+		log.Fatal("Exporting armored key")
+	}
 
-	writeData(c, t, expout.String(), experr.String())
+	token := []byte(t + "\n" + GetFingerprint(c) + "\n" + pk)
 
-	err = exec.Command(GPG, "--no-default-keyring", "--keyring", path.Join(config.Agent.LxcPrefix, c, "public.pub"), "--trust-model", "always", "--armor", "-r", id, "--encrypt", path.Join(config.Agent.LxcPrefix, c, "stdin.txt")).Run()
-	log.Check(log.FatalLevel, "Encrypting stdin.txt", err)
+	asc, err := encrypt(token, imported[0], nil)
+	log.Check(log.FatalLevel, "Encrypting token", err)
 
-	sendData(c)
+	sendData(c, asc)
 }
 
 //todo move to ssl
@@ -375,23 +449,31 @@ func ValidatePem(pathToCert string) bool {
 	return true
 }
 
+// ExtractKeyID returns short id of the first key in armored or binary key
 func ExtractKeyID(k []byte) string {
-	command := exec.Command(GPG)
-	stdin, err := command.StdinPipe()
-	if err != nil {
+	entities, err := parseKeys(k)
+	if log.Check(log.WarnLevel, "Extracting ID from Key", err) || len(entities) == 0 {
 		return ""
 	}
 
-	_, err = stdin.Write(k)
-	log.Check(log.DebugLevel, "Writing to stdin pipe", err)
-	log.Check(log.DebugLevel, "Closing stdin pipe", stdin.Close())
-	out, err := command.Output()
-	log.Check(log.WarnLevel, "Extracting ID from Key", err)
+	return entities[0].PrimaryKey.KeyIdShortString()
+}
 
-	if line := strings.Fields(string(out)); len(line) > 1 {
-		if key := strings.Split(line[1], "/"); len(key) > 1 {
-			return key[1]
-		}
+//returns decoded body of armored message, binary message is returned as is
+func dearmor(r io.Reader) (io.Reader, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
 	}
-	return ""
+
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		return bytes.NewReader(data), nil
+	}
+
+	block, err := armor.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return block.Body, nil
 }
@@ -0,0 +1,382 @@
+package gpg
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+	"golang.org/x/crypto/openpgp/s2k"
+
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/log"
+)
+
+//parsed keyring, kept until keyring file changes
+type keyring struct {
+	modTime  time.Time
+	size     int64
+	entities openpgp.EntityList
+}
+
+var (
+	keyrings     = make(map[string]*keyring)
+	keyringsLock sync.Mutex
+	//serializes read-modify-write of keyring files
+	importLock sync.Mutex
+)
+
+//RH keyrings in GnuPG 1.x home, Console key is imported to RH public keyring too
+func rhPubring() string {
+	return path.Join(config.Agent.GpgHome, "pubring.gpg")
+}
+
+func rhSecring() string {
+	return path.Join(config.Agent.GpgHome, "secring.gpg")
+}
+
+func containerPubring(name string) string {
+	return path.Join(config.Agent.LxcPrefix, name, "public.pub")
+}
+
+func containerSecring(name string) string {
+	return path.Join(config.Agent.LxcPrefix, name, "secret.sec")
+}
+
+// readKeyring returns keys stored in binary or armored keyring file.
+// Secret keys are decrypted with agent GPG password, error is returned if any of them can not be decrypted.
+// Missing keyring is treated as empty one.
+func readKeyring(file string) (openpgp.EntityList, error) {
+	keyringsLock.Lock()
+	defer keyringsLock.Unlock()
+
+	info, err := os.Stat(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if cached, ok := keyrings[file]; ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.entities, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	entities, err := parseKeys(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entities {
+		if err = unlock(e); err != nil {
+			log.Warn("Decrypting secret keys of " + file + ": " + err.Error())
+			return nil, err
+		}
+	}
+
+	keyrings[file] = &keyring{modTime: info.ModTime(), size: info.Size(), entities: entities}
+
+	return entities, nil
+}
+
+// writeKeyring replaces keyring file with passed keys.
+// Secret keyring is written with private keys protected by agent GPG password and is readable by owner only.
+func writeKeyring(file string, entities openpgp.EntityList, secret bool) error {
+	buf := new(bytes.Buffer)
+	for _, e := range entities {
+		var err error
+		if secret {
+			err = serializePrivate(buf, e, []byte(config.KeyringPassword()))
+		} else {
+			err = e.Serialize(buf)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	perm := os.FileMode(0644)
+	if secret {
+		perm = 0600
+	}
+
+	if err := os.MkdirAll(path.Dir(file), 0700); err != nil {
+		return err
+	}
+
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), perm); err != nil {
+		return err
+	}
+
+	keyringsLock.Lock()
+	defer keyringsLock.Unlock()
+	delete(keyrings, file)
+
+	return os.Rename(tmp, file)
+}
+
+// importKeys adds keys to keyring, keys with the same fingerprint are replaced
+func importKeys(file string, data []byte) (openpgp.EntityList, error) {
+	importLock.Lock()
+	defer importLock.Unlock()
+
+	imported, err := parseKeys(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(imported) == 0 {
+		return nil, errors.New("No keys found")
+	}
+
+	existing, err := readKeyring(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var result openpgp.EntityList
+	for _, e := range existing {
+		if findEntity(imported, fingerprint(e)) == nil {
+			result = append(result, e)
+		}
+	}
+	result = append(result, imported...)
+
+	return imported, writeKeyring(file, result, false)
+}
+
+//parses armored or binary keys
+func parseKeys(data []byte) (openpgp.EntityList, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		return openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	}
+
+	return openpgp.ReadKeyRing(bytes.NewReader(data))
+}
+
+//decrypts private keys of entity with agent GPG password
+func unlock(e *openpgp.Entity) error {
+	pass := []byte(config.KeyringPassword())
+
+	if e.PrivateKey != nil && e.PrivateKey.Encrypted {
+		if err := e.PrivateKey.Decrypt(pass); err != nil {
+			return errors.New("Private key " + fingerprint(e) + " can not be decrypted with agent GPG password: " + err.Error())
+		}
+	}
+	for _, sub := range e.Subkeys {
+		if sub.PrivateKey != nil && sub.PrivateKey.Encrypted {
+			if err := sub.PrivateKey.Decrypt(pass); err != nil {
+				return errors.New("Private subkey of " + fingerprint(e) + " can not be decrypted with agent GPG password: " + err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+// findEntity looks up key by fingerprint, key id, user id or email.
+// Unlike GnuPG -u and -r options, user id and email have to match exactly.
+func findEntity(entities openpgp.EntityList, id string) *openpgp.Entity {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return nil
+	}
+
+	if keyID := strings.ToUpper(strings.TrimPrefix(id, "0x")); isKeyID(keyID) {
+		for _, e := range entities {
+			if strings.HasSuffix(fingerprint(e), keyID) {
+				return e
+			}
+		}
+	}
+
+	for _, e := range entities {
+		for name, ident := range e.Identities {
+			if strings.EqualFold(ident.UserId.Email, id) || strings.EqualFold(ident.UserId.Name, id) || strings.EqualFold(name, id) {
+				return e
+			}
+		}
+	}
+
+	return nil
+}
+
+//returns true for short, long key ids and fingerprints
+func isKeyID(s string) bool {
+	if len(s) != 8 && len(s) != 16 && len(s) != 40 {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// serializePrivate writes entity the same way as openpgp.Entity.SerializePrivate,
+// x/crypto/openpgp can not encrypt private keys, so key packets are protected here.
+func serializePrivate(w io.Writer, e *openpgp.Entity, passphrase []byte) error {
+	if err := serializeProtected(w, e.PrivateKey, passphrase); err != nil {
+		return err
+	}
+	for _, ident := range e.Identities {
+		if err := ident.UserId.Serialize(w); err != nil {
+			return err
+		}
+		if err := ident.SelfSignature.SignUserId(ident.UserId.Id, e.PrimaryKey, e.PrivateKey, nil); err != nil {
+			return err
+		}
+		if err := ident.SelfSignature.Serialize(w); err != nil {
+			return err
+		}
+	}
+	for _, sub := range e.Subkeys {
+		if err := serializeProtected(w, sub.PrivateKey, passphrase); err != nil {
+			return err
+		}
+		if err := sub.Sig.SignKey(sub.PublicKey, e.PrivateKey, nil); err != nil {
+			return err
+		}
+		if err := sub.Sig.Serialize(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// serializeProtected writes private key packet encrypted with passphrase as described in RFC 4880, section 5.5.3:
+// AES256 key derived by iterated and salted SHA256 S2K, SHA1 checksum of key material.
+// Empty passphrase leaves key unencrypted.
+func serializeProtected(w io.Writer, pk *packet.PrivateKey, passphrase []byte) error {
+	if pk.Encrypted {
+		return errors.New("Private key " + pk.KeyIdShortString() + " is not decrypted")
+	}
+	if len(passphrase) == 0 {
+		return pk.Serialize(w)
+	}
+
+	plain, public := new(bytes.Buffer), new(bytes.Buffer)
+	if err := pk.Serialize(plain); err != nil {
+		return err
+	}
+	if err := pk.PublicKey.Serialize(public); err != nil {
+		return err
+	}
+
+	//unencrypted body is public key, s2k usage 0, key material and two octet checksum
+	tag, body := packetBody(plain.Bytes())
+	_, publicBody := packetBody(public.Bytes())
+	if len(body) < len(publicBody)+3 {
+		return errors.New("Malformed private key packet")
+	}
+	material := append([]byte{}, body[len(publicBody)+1:len(body)-2]...)
+	checksum := sha1.Sum(material)
+	material = append(material, checksum[:]...)
+
+	protected := bytes.NewBuffer(append([]byte{}, publicBody...))
+	protected.Write([]byte{254, byte(packet.CipherAES256)})
+
+	key := make([]byte, 32)
+	if err := s2k.Serialize(protected, key, rand.Reader, passphrase, &s2k.Config{Hash: crypto.SHA256}); err != nil {
+		return err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	iv := make([]byte, block.BlockSize())
+	if _, err = io.ReadFull(rand.Reader, iv); err != nil {
+		return err
+	}
+	protected.Write(iv)
+
+	cipher.NewCFBEncrypter(block, iv).XORKeyStream(material, material)
+	protected.Write(material)
+
+	if err = serializeHeader(w, tag, protected.Len()); err != nil {
+		return err
+	}
+	_, err = w.Write(protected.Bytes())
+
+	return err
+}
+
+//returns tag and body of single packet written by x/crypto/openpgp, which uses new format headers
+func packetBody(data []byte) (byte, []byte) {
+	if len(data) < 2 {
+		return 0, nil
+	}
+
+	tag := data[0] & 0x3f
+	switch {
+	case data[1] < 192:
+		return tag, data[2:]
+	case data[1] < 224 && len(data) >= 3:
+		return tag, data[3:]
+	case data[1] == 255 && len(data) >= 6:
+		return tag, data[6:]
+	}
+
+	return tag, nil
+}
+
+//writes new format packet header, see RFC 4880, section 4.2.2
+func serializeHeader(w io.Writer, tag byte, length int) error {
+	header := []byte{0xc0 | tag}
+	switch {
+	case length < 192:
+		header = append(header, byte(length))
+	case length < 8384:
+		length -= 192
+		header = append(header, 192+byte(length>>8), byte(length))
+	default:
+		header = append(header, 255, byte(length>>24), byte(length>>16), byte(length>>8), byte(length))
+	}
+
+	_, err := w.Write(header)
+
+	return err
+}
+
+func fingerprint(e *openpgp.Entity) string {
+	return strings.ToUpper(hex.EncodeToString(e.PrimaryKey.Fingerprint[:]))
+}
+
+//returns armored public key of entity
+func exportKey(e *openpgp.Entity) (string, error) {
+	buf := new(bytes.Buffer)
+	w, err := armor.Encode(buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return "", err
+	}
+	if err = e.Serialize(w); err != nil {
+		return "", err
+	}
+	if err = w.Close(); err != nil {
+		return "", err
+	}
+
+	return buf.String() + "\n", nil
+}
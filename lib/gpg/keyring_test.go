@@ -0,0 +1,274 @@
+package gpg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+
+	"github.com/subutai-io/agent/config"
+)
+
+//keyrings in format of secret.sec and public.pub generated by GnuPG 1.x for containers: RSA key without subkeys,
+//old format packets, secret key protected by CAST5 with iterated and salted SHA1 S2K and SHA1 checksum,
+//password 12345678; protection was checked by importing keyring into GnuPG
+const (
+	gpg1Secring = `
+lQH+BGrS3WQBBADCcUnjkBkccbBkxciSElZcallr2uXfCQoA5s9TBzg4ID0Om2UDTFz/orf5rTIh
+0I5L43sTY6STUdM63Euqt8YoXgg+f8oHugYfS0GKSXEH6VZVZEcoA933zb0w1LB5tivLLQRO2LWJ
+OlYaWAY1qSqN/UWWAr17mwVu21iXSt/fcQARAQAB/gMDAqEcECtTGpt3YCn2WlSGvfPCAhWjQzoo
+Yy1TlMjXGf5DlUzGkQB+X98gh3yThTdgHN+hawtCkQlvhGJMI7t+r9nBwmD2RKRwhwIOo0BPO9Uw
+3w2rtTMYKAwj5VCCs+kTvTgFs6N2luebrihMpNgBIqgxsKSFh1r0kxfiZ9TrUNEH/frr4Y1kLTnF
+9mvDOcvvCSDoVG0rq6Ee8EXDN57X4Fd7Zp39N1SVQ3Zrq1JYf8iOEoXl0sx96k1pbHlkUxMo1v5u
+D6TXs+NQ7NFoCLzUykpCQLCJ+/YY9Q0rKPFqPMnvqnbT7NVJObnTz5tFRPHawoRgryKSMft+NCiP
+j1DsMB53E13zcJh5Ws1KWRBh7K3nI2NMnXyTuDq/1nwy0el/KrOjN0ep30MIPLZhkazQ6vZokaFM
+xcdrxD6naQ/Px1gdbz7gvEa7yMaeqWUnhRy6ZJZFo6tPOwEXIYAE27qcBYiGrXP38BqndIJINwwD
+tCJmb28gKGZvbyBHUEcga2V5KSA8Zm9vQHN1YnV0YWkuaW8+iM4EEwEKADgWIQR5xipF1EAdi2tO
+Au/y9SkUUM+pUwUCatLdZAIbLwULCQgHAgYVCgkICwIEFgIDAQIeAQIXgAAKCRDy9SkUUM+pUwD4
+A/9aAyvu7dkAaQYDLuSWO+/YCtxTnsSPBHadYNQGtS+tR7ryJZrhXnLlw2DSM2L5ocoF7xaaVsSW
+oMHTgZOX9L1wFv2jBDsNr6wfmKPFIb2MP6Nf9OZb2IXuqqjsgwuqVMQsdAoTzLrdkFW3sUG9I+qt
+iQpMdcTdK8ESN0DAi3K6Qg==`
+
+	gpg1Pubring = `
+mI0EatLdZAEEAMJxSeOQGRxxsGTFyJISVlxqWWva5d8JCgDmz1MHODggPQ6bZQNMXP+it/mtMiHQ
+jkvjexNjpJNR0zrcS6q3xiheCD5/yge6Bh9LQYpJcQfpVlVkRygD3ffNvTDUsHm2K8stBE7YtYk6
+VhpYBjWpKo39RZYCvXubBW7bWJdK399xABEBAAG0ImZvbyAoZm9vIEdQRyBrZXkpIDxmb29Ac3Vi
+dXRhaS5pbz6IzgQTAQoAOBYhBHnGKkXUQB2La04C7/L1KRRQz6lTBQJq0t1kAhsvBQsJCAcCBhUK
+CQgLAgQWAgMBAh4BAheAAAoJEPL1KRRQz6lTAPgD/1oDK+7t2QBpBgMu5JY779gK3FOexI8Edp1g
+1Aa1L61HuvIlmuFecuXDYNIzYvmhygXvFppWxJagwdOBk5f0vXAW/aMEOw2vrB+Yo8UhvYw/o1/0
+5lvYhe6qqOyDC6pUxCx0ChPMut2QVbexQb0j6q2JCkx1xN0rwRI3QMCLcrpC`
+
+	//signed and encrypted by GnuPG for foo@subutai.io with key of gpg1Secring
+	gnupgMessage = `-----BEGIN PGP MESSAGE-----
+
+hIwD8vUpFFDPqVMBA/0ZS8MNTEY1U5gCoZpf0OkkVJAkBZ8Cc0EcVt+3vFZzuwjg
+EBe3sVwjZiVxGVlK9b9Ja9HGS48lzJCsbHBpBf0v8ZTZD4kjm3i0lQ0GkuYrLVbj
+c/prO3FjOZfIc+DSavEPBqx6tI+td1v0gM3G9MVp5uxA0Re8M3TgfShNbF1N8NLA
+WgF0mTJIrtojagFwez8QtTbvfWAf+dHCZhQI7EYTbX29t1RBGKq+yM6VyyzgrfYq
+HybXnv+eMrO8r+ChkK+ywf5qgY6UI/nTQMfqb90Kt495/cyjPjbnt/46aQ4a7B5d
+XsC0DXE8E7J6m2S1PPZNpoV1DZ+/JYE7ZOrf4Bq7CWeu7BY3tUn9GKPYPTNfC4Eg
+BvjPzBLMldnHSbXZy3aBxXrA9fc5QmTUBIYjsQsGdxDNTSWF7gKydL9NTFoXFzHm
+zXDOthihAIvNRVtbzAuEfZsPFSvNyKp9cWMFXBmUCLypiQW/FHj0O55SA9YjlLEP
+vyrXflyEaHqsseQu8WhxMQbIxk80Vl2NwdpUy7a2lmHb8cOlTmD4BvsYLQ==
+=c0D/
+-----END PGP MESSAGE-----
+`
+)
+
+//points agent GPG password to passed one and creates temporary directory, returned function restores both
+func tempKeyrings(t *testing.T, password string) (string, func()) {
+	dir, err := ioutil.TempDir("", "gpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved := config.Agent.GpgPassword
+	config.Agent.GpgPassword = password
+
+	return dir, func() {
+		config.Agent.GpgPassword = saved
+		os.RemoveAll(dir)
+	}
+}
+
+//writes base64 encoded keyring into file
+func writeFixture(t *testing.T, file, data string) {
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(file, raw, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//forgets parsed keyring, so that it is read from file again
+func forget(file string) {
+	keyringsLock.Lock()
+	defer keyringsLock.Unlock()
+
+	delete(keyrings, file)
+}
+
+func TestProtectedKeyringRoundTrip(t *testing.T) {
+	dir, restore := tempKeyrings(t, "pass phrase")
+	defer restore()
+
+	secring, pubring := path.Join(dir, "secret.sec"), path.Join(dir, "public.pub")
+	if err := GenerateKeyring("foo", "foo@subutai.io", pubring, secring); err != nil {
+		t.Fatal(err)
+	}
+
+	//keys are stored encrypted
+	data, err := ioutil.ReadFile(secring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := openpgp.ReadKeyRing(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || !stored[0].PrivateKey.Encrypted || len(stored[0].Subkeys) != 1 || !stored[0].Subkeys[0].PrivateKey.Encrypted {
+		t.Fatal("private keys are not encrypted in keyring")
+	}
+
+	//and are decrypted on reading
+	entities, err := readKeyring(secring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entities) != 1 || entities[0].PrivateKey.Encrypted || entities[0].Subkeys[0].PrivateKey.Encrypted {
+		t.Fatal("private keys are not decrypted")
+	}
+	if fingerprint(entities[0]) != fingerprint(stored[0]) {
+		t.Errorf("fingerprint %s, want %s", fingerprint(entities[0]), fingerprint(stored[0]))
+	}
+
+	msg, err := EncryptWrapper("foo@subutai.io", "foo@subutai.io", []byte("secret message"), pubring, secring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := DecryptWrapper(string(msg), secring, pubring); out != "secret message" {
+		t.Errorf("decrypted %q", out)
+	}
+
+	//wrong password is reported instead of leaving keys encrypted
+	config.Agent.GpgPassword = "wrong"
+	forget(secring)
+	if _, err := readKeyring(secring); err == nil {
+		t.Error("expected error for wrong password")
+	}
+	if out := DecryptWrapper(string(msg), secring, pubring); out != "" {
+		t.Errorf("decrypted %q with wrong password", out)
+	}
+}
+
+func TestUnprotectedKeyring(t *testing.T) {
+	dir, restore := tempKeyrings(t, "")
+	defer restore()
+
+	secring, pubring := path.Join(dir, "secret.sec"), path.Join(dir, "public.pub")
+	if err := GenerateKeyring("foo", "foo@subutai.io", pubring, secring); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(secring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := openpgp.ReadKeyRing(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored[0].PrivateKey.Encrypted {
+		t.Error("private key is encrypted with empty password")
+	}
+}
+
+func TestReadGpg1Keyring(t *testing.T) {
+	dir, restore := tempKeyrings(t, "12345678")
+	defer restore()
+
+	secring, pubring := path.Join(dir, "secret.sec"), path.Join(dir, "public.pub")
+	writeFixture(t, secring, gpg1Secring)
+	writeFixture(t, pubring, gpg1Pubring)
+
+	entities, err := readKeyring(secring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if findEntity(entities, "foo@subutai.io") == nil {
+		t.Fatal("key of foo@subutai.io not found")
+	}
+
+	msg, err := EncryptWrapper("foo@subutai.io", "foo@subutai.io", []byte("secret message"), pubring, secring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := DecryptWrapper(string(msg), secring, pubring); out != "secret message" {
+		t.Errorf("decrypted %q", out)
+	}
+
+	//keyring rewritten by agent, e.g. on key rotation, keeps the same keys
+	if err = writeKeyring(secring, entities, true); err != nil {
+		t.Fatal(err)
+	}
+	rewritten, err := readKeyring(secring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rewritten) != 1 || fingerprint(rewritten[0]) != fingerprint(entities[0]) {
+		t.Error("keys differ after rewriting keyring")
+	}
+	if out := DecryptWrapper(string(msg), secring, pubring); out != "secret message" {
+		t.Errorf("decrypted %q after rewriting keyring", out)
+	}
+
+	config.Agent.GpgPassword = "wrong"
+	forget(secring)
+	if _, err := readKeyring(secring); err == nil {
+		t.Error("expected error for wrong password")
+	}
+}
+
+func TestDecryptGnupgMessage(t *testing.T) {
+	dir, restore := tempKeyrings(t, "12345678")
+	defer restore()
+
+	secring, pubring := path.Join(dir, "secret.sec"), path.Join(dir, "public.pub")
+	writeFixture(t, secring, gpg1Secring)
+	writeFixture(t, pubring, gpg1Pubring)
+
+	if out := DecryptWrapper(gnupgMessage, secring, pubring); out != "hello from gnupg" {
+		t.Errorf("decrypted %q", out)
+	}
+
+	out, _, err := DecryptVerified(gnupgMessage, "foo@subutai.io", secring, pubring)
+	if err != nil || out != "hello from gnupg" {
+		t.Errorf("decrypted %q, %v", out, err)
+	}
+
+	if _, _, err := DecryptVerified(gnupgMessage, "bar@subutai.io", secring, pubring); err == nil {
+		t.Error("expected error for another signer")
+	}
+}
+
+func TestEncryptForAnotherKeyring(t *testing.T) {
+	dir, restore := tempKeyrings(t, "12345678")
+	defer restore()
+
+	//RH signs with its key and encrypts for container key imported into RH public keyring
+	rhSec, rhPub := path.Join(dir, "rh.sec"), path.Join(dir, "rh.pub")
+	if err := GenerateKeyring("rh", "rh@subutai.io", rhPub, rhSec); err != nil {
+		t.Fatal(err)
+	}
+	fooSec, fooPub := path.Join(dir, "secret.sec"), path.Join(dir, "public.pub")
+	writeFixture(t, fooSec, gpg1Secring)
+	writeFixture(t, fooPub, gpg1Pubring)
+
+	if err := ImportPkTo(rhPub, []byte(ExportPk(fooPub, "foo@subutai.io"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := ImportPkTo(fooPub, []byte(ExportPk(rhPub, "rh@subutai.io"))); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := EncryptWrapper("rh@subutai.io", "foo@subutai.io", []byte("for foo"), rhPub, rhSec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, _, err := DecryptVerified(string(msg), "rh@subutai.io", fooSec, fooPub)
+	if err != nil || out != "for foo" {
+		t.Errorf("decrypted %q, %v", out, err)
+	}
+
+	//RH can not read message encrypted for foo
+	if out := DecryptWrapper(string(msg), rhSec, rhPub); out != "" {
+		t.Errorf("RH decrypted %q", out)
+	}
+}
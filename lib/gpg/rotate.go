@@ -0,0 +1,303 @@
+package gpg
+
+import (
+	"errors"
+	"os"
+	"path"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+
+	"github.com/subutai-io/agent/config"
+	"github.com/subutai-io/agent/db"
+	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/log"
+	"github.com/subutai-io/agent/lib/secret"
+)
+
+//keyring with keys replaced by rotation, kept next to secret keyring
+func retiredKeyring(secring string) string {
+	return secring + ".retired"
+}
+
+// RotateKey replaces GPG key of RH (name is agent GPG user) or container with freshly generated one.
+// User ids of new key are certified by the old key, so that Console can trust the new key.
+// Old key is moved to retired keyring and keeps decrypting in-flight requests until grace period is over,
+// with zero grace period old key is dropped immediately.
+// Returns fingerprint of the new key and function restoring the old key, e.g. if Console does not accept the new one.
+func RotateKey(name string, grace time.Duration) (string, func() error, error) {
+	pubring, secring, email := rhPubring(), rhSecring(), name
+	if name != config.Agent.GpgUser {
+		if !container.LxcInstanceExists(name) {
+			return "", nil, errors.New("Container " + name + " not found")
+		}
+		pubring, secring, email = containerPubring(name), containerSecring(name), name+"@subutai.io"
+	}
+
+	importLock.Lock()
+	defer importLock.Unlock()
+
+	secretKeys, err := readKeyring(secring)
+	if err != nil {
+		return "", nil, err
+	}
+	public, err := readKeyring(pubring)
+	if err != nil {
+		return "", nil, err
+	}
+	retired, err := readKeyring(retiredKeyring(secring))
+	if err != nil {
+		return "", nil, err
+	}
+
+	old := findEntity(secretKeys, email)
+	if old == nil || old.PrivateKey == nil {
+		return "", nil, errors.New("Secret key of " + name + " not found")
+	}
+	if old.PrivateKey.Encrypted {
+		return "", nil, errors.New("Secret key of " + name + " is locked")
+	}
+
+	e, err := newEntity(name, email)
+	if err != nil {
+		return "", nil, err
+	}
+	for id := range e.Identities {
+		if err = e.SignIdentity(id, old, nil); err != nil {
+			return "", nil, err
+		}
+	}
+
+	oldFingerprint := fingerprint(old)
+	retiredKey := &db.RetiredKey{
+		Fingerprint: oldFingerprint,
+		Owner:       name,
+		Keyring:     retiredKeyring(secring),
+		Expires:     time.Now().Add(grace),
+	}
+
+	//agent secrets are encrypted with key derived from RH key
+	revertSecrets := func() {}
+
+	//restores keyrings, secrets and retired key record as they were before rotation
+	restore := func() error {
+		revertSecrets()
+		if grace > 0 {
+			log.Check(log.WarnLevel, "Removing retired key record", db.RemoveRetiredKey(retiredKey))
+			if err := restoreKeyring(retiredKeyring(secring), retired, true); err != nil {
+				return err
+			}
+		}
+		if err := writeKeyring(secring, secretKeys, true); err != nil {
+			return err
+		}
+
+		return writeKeyring(pubring, public, false)
+	}
+	fail := func(err error) (string, func() error, error) {
+		log.Check(log.WarnLevel, "Restoring key of "+name, restore())
+		return "", nil, err
+	}
+
+	if grace > 0 {
+		if err = writeKeyring(retiredKeyring(secring), append(append(openpgp.EntityList{}, retired...), old), true); err != nil {
+			return fail(err)
+		}
+		if err = db.SaveRetiredKey(retiredKey); err != nil {
+			return fail(err)
+		}
+	}
+
+	if name == config.Agent.GpgUser {
+		if revertSecrets, err = rekeySecrets(old, e); err != nil {
+			revertSecrets = func() {}
+			return fail(err)
+		}
+	}
+
+	if err = writeKeyring(secring, replaceEntity(secretKeys, oldFingerprint, e), true); err != nil {
+		return fail(err)
+	}
+	if err = writeKeyring(pubring, replaceEntity(public, oldFingerprint, e), false); err != nil {
+		return fail(err)
+	}
+
+	return fingerprint(e), func() error {
+		importLock.Lock()
+		defer importLock.Unlock()
+
+		return restore()
+	}, nil
+}
+
+//writes keys back to keyring, keyring which was missing is removed
+func restoreKeyring(file string, entities openpgp.EntityList, secret bool) error {
+	if len(entities) > 0 {
+		return writeKeyring(file, entities, secret)
+	}
+
+	keyringsLock.Lock()
+	defer keyringsLock.Unlock()
+	delete(keyrings, file)
+
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+//re-encrypts agent secrets with key derived from new RH key, returns function reverting it
+func rekeySecrets(old, e *openpgp.Entity) (func(), error) {
+	oldKey, err := secret.DeriveKey(old.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	newKey, err := secret.DeriveKey(e.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	rekeyed, err := secret.NewStore(path.Join(config.Agent.DataPrefix, "agent.db"), oldKey).Rekey(newKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		_, err := rekeyed.Rekey(oldKey)
+		log.Check(log.WarnLevel, "Reverting secrets encryption key", err)
+	}, nil
+}
+
+//returns copy of keys with key of passed fingerprint replaced by e, e is appended if key is missing
+func replaceEntity(entities openpgp.EntityList, fp string, e *openpgp.Entity) openpgp.EntityList {
+	var result openpgp.EntityList
+	replaced := false
+	for _, entity := range entities {
+		if fingerprint(entity) == fp {
+			result = append(result, e)
+			replaced = true
+		} else {
+			result = append(result, entity)
+		}
+	}
+	if !replaced {
+		result = append(result, e)
+	}
+
+	return result
+}
+
+// RetiredKeyOwner returns name of RH or container whose retired key has passed fingerprint,
+// empty string if there is no such key or its grace period is over
+func RetiredKeyOwner(fingerprint string) string {
+	key, err := db.FindRetiredKeyByFingerprint(fingerprint)
+	if log.Check(log.DebugLevel, "Looking up retired key "+fingerprint, err) || key == nil || time.Now().After(key.Expires) {
+		return ""
+	}
+
+	return key.Owner
+}
+
+// RetiredFingerprints returns fingerprints of retired keys of RH or container which are still in grace period
+func RetiredFingerprints(owner string) []string {
+	keys, err := db.FindRetiredKeys(owner)
+	log.Check(log.DebugLevel, "Reading retired keys of "+owner, err)
+
+	var fingerprints []string
+	for _, key := range keys {
+		if time.Now().Before(key.Expires) {
+			fingerprints = append(fingerprints, key.Fingerprint)
+		}
+	}
+
+	return fingerprints
+}
+
+// IsRhFingerprint returns true if passed fingerprint belongs to current or retired RH key
+func IsRhFingerprint(fingerprint string) bool {
+	return fingerprint == GetRhFingerprint() || RetiredKeyOwner(fingerprint) == config.Agent.GpgUser
+}
+
+// PruneRetiredKeys removes keys whose grace period is over from retired keyrings
+func PruneRetiredKeys() {
+	keys, err := db.FindRetiredKeys("")
+	if log.Check(log.WarnLevel, "Reading retired keys", err) {
+		return
+	}
+
+	for i := range keys {
+		if time.Now().Before(keys[i].Expires) {
+			continue
+		}
+
+		log.Info("Removing retired key " + keys[i].Fingerprint + " of " + keys[i].Owner)
+
+		if !log.Check(log.WarnLevel, "Removing retired key from keyring", removeEntity(keys[i].Keyring, keys[i].Fingerprint)) {
+			log.Check(log.WarnLevel, "Removing retired key record", db.RemoveRetiredKey(&keys[i]))
+		}
+	}
+}
+
+//removes key with passed fingerprint from secret keyring, keyring left without keys is deleted
+func removeEntity(keyring, fp string) error {
+	importLock.Lock()
+	defer importLock.Unlock()
+
+	//keyring of destroyed container
+	if _, err := os.Stat(keyring); os.IsNotExist(err) {
+		return nil
+	}
+
+	entities, err := readKeyring(keyring)
+	if err != nil {
+		return err
+	}
+
+	var result openpgp.EntityList
+	for _, e := range entities {
+		if fingerprint(e) != fp {
+			result = append(result, e)
+		}
+	}
+
+	if len(result) == 0 {
+		keyringsLock.Lock()
+		delete(keyrings, keyring)
+		keyringsLock.Unlock()
+
+		return os.Remove(keyring)
+	}
+
+	return writeKeyring(keyring, result, true)
+}
+
+// CertifiedBy returns true if user id of armored public key is certified by key with passed id from keyring.
+// It is used to verify that rotated key is issued by owner of the old one.
+func CertifiedBy(pk []byte, keyring, id string) bool {
+	entities, err := parseKeys(pk)
+	if err != nil || len(entities) == 0 {
+		return false
+	}
+
+	signers, err := readKeyring(keyring)
+	if log.Check(log.DebugLevel, "Reading keyring "+keyring, err) {
+		return false
+	}
+	signer := findEntity(signers, id)
+	if signer == nil {
+		return false
+	}
+
+	e := entities[0]
+	for _, ident := range e.Identities {
+		for _, sig := range ident.Signatures {
+			if sig.IssuerKeyId != nil && *sig.IssuerKeyId == signer.PrimaryKey.KeyId &&
+				signer.PrimaryKey.VerifyUserIdSignature(ident.Name, e.PrimaryKey, sig) == nil {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -21,6 +21,7 @@ import (
 	"github.com/subutai-io/agent/lib/exec"
 	"github.com/subutai-io/agent/agent/util"
 	"regexp"
+	"github.com/subutai-io/agent/lib/event"
 )
 
 //todo split this file into types, snippets,
@@ -398,7 +399,7 @@ func CreateProxy(protocol, domain, loadBalancing, tag string, port int, redirect
 		return errors.New(fmt.Sprintf("Error saving proxy to db: %s", err.Error()))
 	}
 
-	return applyConfig(tag, true)
+	return publish(tag, "create", "", applyConfig(tag, true))
 }
 
 func RemoveProxy(tag string) error {
@@ -420,7 +421,7 @@ func RemoveProxy(tag string) error {
 		return errors.New(fmt.Sprintf("Error reloading nginx: %s", err.Error()))
 	}
 
-	return nil
+	return publish(tag, "remove", "", nil)
 }
 
 func AddProxiedServer(tag, socket string) error {
@@ -472,7 +473,7 @@ func AddProxiedServer(tag, socket string) error {
 		return errors.New(fmt.Sprintf("Error saving server to db: %s", err.Error()))
 	}
 
-	return applyConfig(tag, false)
+	return publish(tag, "add-server", socket, applyConfig(tag, false))
 
 }
 
@@ -498,7 +499,20 @@ func RemoveProxiedServer(tag, socket string) error {
 		return errors.New(fmt.Sprintf("Error removing server from db: %s", err.Error()))
 	}
 
-	return applyConfig(tag, false)
+	return publish(tag, "remove-server", socket, applyConfig(tag, false))
+}
+
+//publishes proxy change event if change has been applied successfully, passes err through
+func publish(tag, action, socket string, err error) error {
+	if err == nil {
+		details := map[string]string{"tag": tag, "action": action}
+		if socket != "" {
+			details["server"] = socket
+		}
+		event.Publish(event.ProxyChanged, "", details)
+	}
+
+	return err
 }
 
 func applyConfig(tag string, creating bool) error {
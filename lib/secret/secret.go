@@ -0,0 +1,288 @@
+// Package secret keeps agent credentials encrypted at rest in agent db.
+// Values are sealed with AES-256-GCM under key derived from private part of RH GPG key,
+// so copy of agent db is useless without RH secret keyring.
+// Package does not depend on config, since config resolves secret references at load time.
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/asdine/storm"
+	"go.etcd.io/bbolt"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/elgamal"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// Prefix marks config values which are names of secrets rather than values
+const Prefix = "secret:"
+
+var ErrNotFound = errors.New("Secret not found")
+
+// Secret is a sealed value stored in agent db
+type Secret struct {
+	Name    string `storm:"id"`
+	Value   []byte
+	Updated time.Time
+}
+
+// Store reads and writes secrets in agent db
+type Store struct {
+	dbFile string
+	key    []byte
+}
+
+func NewStore(dbFile string, key []byte) Store {
+	return Store{dbFile: dbFile, key: key}
+}
+
+// Ref returns name of secret if value is a reference in form secret:name
+func Ref(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, Prefix) {
+		return "", false
+	}
+
+	name := strings.TrimSpace(strings.TrimPrefix(value, Prefix))
+
+	return name, name != ""
+}
+
+// DeriveKey returns secrets encryption key derived from decrypted private GPG key
+func DeriveKey(pk *packet.PrivateKey) ([]byte, error) {
+	if pk == nil || pk.Encrypted {
+		return nil, errors.New("Private key is not available")
+	}
+
+	//private exponent is used, since serialized key packet changes on keyring round trip
+	var secret *big.Int
+	switch key := pk.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		secret = key.D
+	case *dsa.PrivateKey:
+		secret = key.X
+	case *ecdsa.PrivateKey:
+		secret = key.D
+	case *elgamal.PrivateKey:
+		secret = key.X
+	default:
+		return nil, errors.New("Unsupported private key type")
+	}
+
+	mac := hmac.New(sha256.New, []byte("subutai agent secrets"))
+	mac.Write(secret.Bytes())
+
+	return mac.Sum(nil), nil
+}
+
+// KeyringKey derives secrets encryption key from key of user in GPG secret keyring.
+// Password is used for keys protected by GnuPG.
+func KeyringKey(keyring, user, password string) ([]byte, error) {
+	f, err := os.Open(keyring)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entities, err := openpgp.ReadKeyRing(f)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entities {
+		if e.PrivateKey == nil {
+			continue
+		}
+		for _, ident := range e.Identities {
+			if strings.EqualFold(ident.UserId.Email, user) || strings.EqualFold(ident.UserId.Name, user) {
+				if e.PrivateKey.Encrypted {
+					if err = e.PrivateKey.Decrypt([]byte(password)); err != nil {
+						return nil, err
+					}
+				}
+				return DeriveKey(e.PrivateKey)
+			}
+		}
+	}
+
+	return nil, errors.New("Secret key of " + user + " not found")
+}
+
+// Set stores secret, existing secret with the same name is replaced
+func (s Store) Set(name, value string) error {
+	sealed, err := s.seal(name, []byte(value))
+	if err != nil {
+		return err
+	}
+
+	db, err := s.db()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Save(&Secret{Name: name, Value: sealed, Updated: time.Now()})
+}
+
+// Get returns value of secret, ErrNotFound if there is no such secret
+func (s Store) Get(name string) (string, error) {
+	if _, err := os.Stat(s.dbFile); os.IsNotExist(err) {
+		return "", ErrNotFound
+	}
+
+	db, err := s.db()
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	var secret Secret
+	err = db.One("Name", name, &secret)
+	if err == storm.ErrNotFound {
+		return "", ErrNotFound
+	} else if err != nil {
+		return "", err
+	}
+
+	value, err := s.unseal(name, secret.Value)
+
+	return string(value), err
+}
+
+// List returns stored secrets, values are left sealed
+func (s Store) List() (secrets []Secret, err error) {
+	if _, err := os.Stat(s.dbFile); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	db, err := s.db()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	err = db.All(&secrets)
+	if err == storm.ErrNotFound {
+		err = nil
+	}
+
+	return secrets, err
+}
+
+// Remove deletes secret, ErrNotFound if there is no such secret
+func (s Store) Remove(name string) error {
+	db, err := s.db()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = db.DeleteStruct(&Secret{Name: name})
+	if err == storm.ErrNotFound {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// Rekey re-encrypts all secrets with new key, e.g. when RH GPG key is rotated.
+// Returns store bound to new key.
+func (s Store) Rekey(key []byte) (Store, error) {
+	rekeyed := NewStore(s.dbFile, key)
+
+	if _, err := os.Stat(s.dbFile); os.IsNotExist(err) {
+		return rekeyed, nil
+	}
+
+	db, err := s.db()
+	if err != nil {
+		return s, err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		return s, err
+	}
+	defer tx.Rollback()
+
+	var secrets []Secret
+	if err = tx.All(&secrets); err != nil && err != storm.ErrNotFound {
+		return s, err
+	}
+
+	for i := range secrets {
+		value, err := s.unseal(secrets[i].Name, secrets[i].Value)
+		if err != nil {
+			return s, err
+		}
+		if secrets[i].Value, err = rekeyed.seal(secrets[i].Name, value); err != nil {
+			return s, err
+		}
+		if err = tx.Save(&secrets[i]); err != nil {
+			return s, err
+		}
+	}
+
+	return rekeyed, tx.Commit()
+}
+
+func (s Store) db() (*storm.DB, error) {
+	return storm.Open(s.dbFile, storm.BoltOptions(0600, &bolt.Options{Timeout: 15 * time.Second}))
+}
+
+//encrypts value, name is authenticated so that sealed values can not be swapped between secrets
+func (s Store) seal(name string, value []byte) ([]byte, error) {
+	gcm, err := s.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, value, []byte(name)), nil
+}
+
+//decrypts value sealed by seal
+func (s Store) unseal(name string, sealed []byte) ([]byte, error) {
+	gcm, err := s.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("Invalid secret " + name)
+	}
+
+	value, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(name))
+	if err != nil {
+		return nil, errors.New("Failed to decrypt secret " + name + ", it may be encrypted with another RH key")
+	}
+
+	return value, nil
+}
+
+func (s Store) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
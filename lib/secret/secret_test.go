@@ -0,0 +1,172 @@
+package secret
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+func key(seed string) []byte {
+	sum := sha256.Sum256([]byte(seed))
+	return sum[:]
+}
+
+func TestSealRoundTrip(t *testing.T) {
+	s := NewStore("", key("rh"))
+
+	for _, value := range []string{"", "secret", "pässwörd", "line\nbreak", string([]byte{0, 1, 255})} {
+		sealed, err := s.seal("name", []byte(value))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(value) > 0 && bytes.Contains(sealed, []byte(value)) {
+			t.Errorf("Sealed value contains plain value %q", value)
+		}
+
+		unsealed, err := s.unseal("name", sealed)
+		if err != nil || string(unsealed) != value {
+			t.Errorf("unseal(seal(%q)) = %q, %v", value, unsealed, err)
+		}
+	}
+}
+
+func TestUnsealRejectsForeignValues(t *testing.T) {
+	s := NewStore("", key("rh"))
+
+	sealed, err := s.seal("name", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name   string
+		store  Store
+		secret string
+		sealed []byte
+	}{
+		{"another key", NewStore("", key("other rh")), "name", sealed},
+		{"another secret name", s, "other", sealed},
+		{"tampered value", s, "name", tampered},
+		{"truncated value", s, "name", sealed[:4]},
+	}
+
+	for _, tt := range tests {
+		if value, err := tt.store.unseal(tt.secret, tt.sealed); err == nil {
+			t.Errorf("%s: unseal() = %q, error expected", tt.name, value)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := NewStore(path.Join(dir, "agent.db"), key("rh"))
+
+	if _, err = s.Get("influx"); err != ErrNotFound {
+		t.Errorf("Get() from missing db returned %v, want ErrNotFound", err)
+	}
+
+	for name, value := range map[string]string{"influx": "root", "cdn": "token"} {
+		if err = s.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Set("influx", "changed"); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, err := s.Get("influx"); err != nil || value != "changed" {
+		t.Errorf("Get() = %q, %v, want replaced value", value, err)
+	}
+	if secrets, err := s.List(); err != nil || len(secrets) != 2 {
+		t.Errorf("List() = %v, %v, want 2 secrets", secrets, err)
+	}
+
+	rekeyed, err := s.Rekey(key("rotated rh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, err := rekeyed.Get("cdn"); err != nil || value != "token" {
+		t.Errorf("Get() after rekey = %q, %v", value, err)
+	}
+	if _, err = s.Get("cdn"); err == nil {
+		t.Error("Secret is readable with old key after rekey")
+	}
+
+	if err = rekeyed.Remove("cdn"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rekeyed.Get("cdn"); err != ErrNotFound {
+		t.Errorf("Get() of removed secret returned %v, want ErrNotFound", err)
+	}
+	if err = rekeyed.Remove("cdn"); err != ErrNotFound {
+		t.Errorf("Remove() of removed secret returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestRef(t *testing.T) {
+	tests := []struct {
+		value string
+		name  string
+		ok    bool
+	}{
+		{"secret:influx", "influx", true},
+		{" secret: influx ", "influx", true},
+		{"secret:", "", false},
+		{"Secret:influx", "", false},
+		{"influx", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		if name, ok := Ref(tt.value); name != tt.name || ok != tt.ok {
+			t.Errorf("Ref(%q) = %q, %t, want %q, %t", tt.value, name, ok, tt.name, tt.ok)
+		}
+	}
+}
+
+func TestKeyringKeySurvivesRoundTrip(t *testing.T) {
+	e, err := openpgp.NewEntity("rh", "", "rh@subutai.io", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	derived, err := DeriveKey(e.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err = e.SerializePrivate(buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := ioutil.TempFile("", "secring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(keyring.Name())
+	keyring.Write(buf.Bytes())
+	keyring.Close()
+
+	for _, user := range []string{"rh@subutai.io", "RH"} {
+		if got, err := KeyringKey(keyring.Name(), user, ""); err != nil || !bytes.Equal(got, derived) {
+			t.Errorf("KeyringKey(%s) = %x, %v, want %x", user, got, err, derived)
+		}
+	}
+	if _, err = KeyringKey(keyring.Name(), "other@subutai.io", ""); err == nil {
+		t.Error("KeyringKey() of unknown user succeeded")
+	}
+}
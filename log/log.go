@@ -2,6 +2,9 @@
 package log
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"log/syslog"
 	"os"
 	lSyslog "github.com/sirupsen/logrus/hooks/syslog"
@@ -23,6 +26,13 @@ var (
 	PanicLevel = logrus.PanicLevel
 )
 
+var (
+	//receives JSON error document, set in JSON output mode
+	jsonOut io.Writer
+	//called before process is stopped by Error or Fatal
+	exitHooks []func(msg string)
+)
+
 func init() {
 
 	//add syslog hook
@@ -63,6 +73,36 @@ func Check(level logrus.Level, msg string, err error) bool {
 	return false
 }
 
+// JsonOutput switches logging to JSON format on stderr.
+// Errors stopping the process are also written to out as a JSON document {"error": "..."}
+func JsonOutput(out io.Writer) {
+	jsonOut = out
+	logrus.SetFormatter(&logrus.JSONFormatter{TimestampFormat: "2006-01-02 15:04:05"})
+	logrus.SetOutput(os.Stderr)
+}
+
+//writes error document in JSON output mode
+func jsonError(msg ...interface{}) {
+	if jsonOut == nil {
+		return
+	}
+	out, err := json.Marshal(map[string]string{"error": fmt.Sprint(msg...)})
+	if err == nil {
+		fmt.Fprintln(jsonOut, string(out))
+	}
+}
+
+// OnExit registers hook called with error message before process is stopped by Error or Fatal
+func OnExit(hook func(msg string)) {
+	exitHooks = append(exitHooks, hook)
+}
+
+func runExitHooks(msg ...interface{}) {
+	for _, hook := range exitHooks {
+		hook(fmt.Sprint(msg...))
+	}
+}
+
 // Level sets output level
 func Level(level logrus.Level) {
 	logrus.SetLevel(level)
@@ -76,12 +116,16 @@ func Panic(msg ...interface{}) {
 
 // Fatal stops process after showing fatal message.
 func Fatal(msg ...interface{}) {
+	jsonError(msg...)
+	runExitHooks(msg...)
 	logrus.SetOutput(os.Stderr)
 	logrus.Fatal(msg...)
 }
 
 // Error stops process after showing error message.
 func Error(msg ...interface{}) {
+	jsonError(msg...)
+	runExitHooks(msg...)
 	logrus.SetOutput(os.Stderr)
 	logrus.Error(msg...)
 	os.Exit(1)
@@ -15,13 +15,29 @@ import (
 	"text/tabwriter"
 	"strings"
 	"github.com/subutai-io/agent/lib/container"
+	"github.com/subutai-io/agent/agent/local"
+	"strconv"
+	"encoding/json"
+	"sort"
+	"github.com/subutai-io/agent/lib/audit"
+	"errors"
+	"time"
 )
 
 var version = "unknown"
 
+var (
+	//real standard output, used for JSON document when --json flag is set
+	stdout = os.Stdout
+	//true if JSON document has been printed
+	printed bool
+)
+
 var (
 	app       = kingpin.New("subutai", "Subutai Agent")
 	debugFlag = app.Flag("debug", "Set log level to DEBUG").Short('d').Bool()
+	viaDaemon = app.Flag("via-daemon", "Execute command through running subutai daemon, which serializes commands on the same container").Bool()
+	jsonFlag  = app.Flag("json", "Print command output as a single JSON document").Bool()
 
 	//daemon command
 	daemonCmd = app.Command("daemon", "Run subutai agent daemon")
@@ -66,6 +82,14 @@ var (
 	cloneNetwork   = cloneCmd.Flag("network", "container network settings in form 'ip/mask vlan'").Short('n').String()
 	cloneSecret    = cloneCmd.Flag("secret", "console secret").Short('s').String()
 
+	//apply command
+	/*
+	subutai apply -f container.yaml [--dry-run]
+	*/
+	applyCmd    = app.Command("apply", "Create or update container to match declarative spec")
+	applyFile   = applyCmd.Flag("file", "path to YAML container spec").Short('f').Required().String()
+	applyDryRun = applyCmd.Flag("dry-run", "print changes without making them").Bool()
+
 	restoreCmd       = app.Command("restore", "Restore container")
 	restoreContainer = restoreCmd.Arg("container", "container name").Required().String()
 	restoreEnvId     = restoreCmd.Flag("environment", "id of container environment").Short('e').String()
@@ -140,6 +164,10 @@ var (
 	//subutai info qu foo
 	infoQuotaCmd       = infoCmd.Command("qu", "container quota usage")
 	infoQuotaContainer = infoQuotaCmd.Arg("container", "container name").Required().String()
+	//subutai info pending
+	infoPendingCmd = infoCmd.Command("pending", "number of command responses not yet delivered to Console")
+	//info management
+	infoManagementCmd = infoCmd.Command("management", "Management host daemon currently talks to").Alias("mh")
 
 	//hostname command
 	//TODO add hostname read commands e.g. subutai hostname rh, subutai hostname con foo [no-console-change]
@@ -253,6 +281,8 @@ var (
 	//stop command
 	stopCmd          = app.Command("stop", "Stop Subutai container")
 	stopCmdContainer = stopCmd.Arg("name(s)", "container name(s)").Required().Strings()
+	stopCmdTimeout   = stopCmd.Flag("timeout", "seconds given to container to run pre-stop hook and shut down before it is stopped forcibly").Short('t').Default("120").Int()
+	stopCmdForce     = stopCmd.Flag("force", "stop container right away, without pre-stop hook and shutdown").Short('f').Bool()
 
 	//snapshot command
 	snapshotCmd                = app.Command("snapshot", "Manage container snapshots").Alias("snap")
@@ -310,10 +340,70 @@ var (
 	fileDecryptCmdTargetPath = fileDecryptCmd.Flag("target", "Target decrypted file").Short('t').String()
 	fileDecryptCmdPassword   = fileDecryptCmd.Flag("password", "Password to use for decryption").Short('p').Required().String()
 
+	//keys command
+	/*
+	subutai keys rotate rh --grace 24h
+	subutai keys rotate foo -t {token}
+	subutai keys unpin 10.10.10.1
+	*/
+	keysCmd             = app.Command("keys", "Manage GPG keys")
+	keysRotateCmd       = keysCmd.Command("rotate", "Replace GPG key of RH or container with a new one")
+	keysRotateCmdTarget = keysRotateCmd.Arg("target", "rh or container name").Required().String()
+	keysRotateCmdGrace  = keysRotateCmd.Flag("grace", "period old key keeps decrypting requests, e.g. 24h").Short('g').Default("24h").Duration()
+	keysRotateCmdToken  = keysRotateCmd.Flag("token", "Console token, required for container").Short('t').String()
+	keysUnpinCmd        = keysCmd.Command("unpin", "Forget pinned Console certificate, new one is pinned on next registration check")
+	keysUnpinCmdHost    = keysUnpinCmd.Arg("host", "Management host, all hosts if omitted").String()
+
+	//secret command
+	/*
+	subutai secret set influx-pass
+	subutai secret ls
+	*/
+	secretCmd           = app.Command("secret", "Manage secrets referenced in agent.conf as secret:name")
+	secretSetCmd        = secretCmd.Command("set", "Store secret, value is read from stdin if omitted")
+	secretSetCmdName    = secretSetCmd.Arg("name", "secret name").Required().String()
+	secretSetCmdValue   = secretSetCmd.Arg("value", "secret value").String()
+	secretGetCmd        = secretCmd.Command("get", "Print secret value")
+	secretGetCmdName    = secretGetCmd.Arg("name", "secret name").Required().String()
+	secretListCmd       = secretCmd.Command("list", "List secrets").Alias("ls")
+	secretRemoveCmd     = secretCmd.Command("rm", "Remove secret").Alias("del")
+	secretRemoveCmdName = secretRemoveCmd.Arg("name", "secret name").Required().String()
+
+	//audit command
+	/*
+	subutai audit list --since 24h --container foo
+	*/
+	auditCmd              = app.Command("audit", "Audit log of privileged operations")
+	auditListCmd          = auditCmd.Command("list", "List audit log entries").Alias("ls")
+	auditListCmdSince     = auditListCmd.Flag("since", "duration back from now, e.g. 24h, or time 'yyyy-mm-dd hh:mi:ss'").Short('s').String()
+	auditListCmdContainer = auditListCmd.Flag("container", "container or template name").Short('c').String()
+
 	//restart command
 	restartCmd          = app.Command("restart", "Restart Subutai container")
 	restartCmdContainer = restartCmd.Arg("name(s)", "container name(s)").Required().Strings()
 
+	//config command
+	/*
+	subutai config show foo
+	subutai config set foo depends-on db1,db2
+	subutai config set foo health-check http:80/status
+	*/
+	configCmd         = app.Command("config", "Container settings kept by agent")
+	configShowCmd     = configCmd.Command("show", "Show container settings")
+	configShowCmdName = configShowCmd.Arg("container", "container name").Required().String()
+	configSetCmd      = configCmd.Command("set", "Change container setting")
+	configSetCmdName  = configSetCmd.Arg("container", "container name").Required().String()
+	configSetCmdKey   = configSetCmd.Arg("key", "setting [depends-on|priority|readiness|restart-policy|health-check|health-interval|health-retries]").Required().Enum("depends-on", "priority", "readiness", "restart-policy", "health-check", "health-interval", "health-retries")
+	configSetCmdValue = configSetCmd.Arg("value", "comma separated containers for depends-on, number for priority, command for readiness, policy for restart-policy, exec:{command}, tcp:{port} or http:{port}[/{path}] for health-check, duration for health-interval, number for health-retries").String()
+
+	//restart policy command
+	/*
+	subutai restart-policy foo [always|on-failure:N|never]
+	*/
+	restartPolicyCmd          = app.Command("restart-policy", "Show or set policy of restarting container by daemon")
+	restartPolicyCmdContainer = restartPolicyCmd.Arg("container", "container name").Required().String()
+	restartPolicyCmdPolicy    = restartPolicyCmd.Arg("policy", "always, on-failure:N (at most N restarts) or never").String()
+
 	//update command
 	//subutai update rh
 	//subutai update management -c
@@ -361,6 +451,15 @@ func init() {
 	app.VersionFlag.Hidden().Short('v')
 
 	vars.Version = version
+
+	//Console commands executed by daemon are audited with secrets masked the same way as CLI commands
+	audit.Mask = maskArgs
+
+	//local API of daemon accepts CLI commands only
+	for _, cmd := range app.Model().Commands {
+		local.Actions = append(append(local.Actions, cmd.Name), cmd.Aliases...)
+	}
+	local.Targets = lockTargets
 }
 
 func main() {
@@ -371,20 +470,42 @@ func main() {
 		log.Level(log.DebugLevel)
 	}
 
+	if *jsonFlag {
+		//any other output goes to stderr, so that stdout contains only JSON document
+		os.Stdout = os.Stderr
+		log.JsonOutput(stdout)
+	}
+
 	vars.IsDaemon = input == daemonCmd.FullCommand()
 
+	if *viaDaemon {
+		forward(input)
+	}
+
+	if container, ok := auditTarget(input); ok {
+		done := audit.Start(audit.Who(), input, maskArgs(os.Args[1:]), container)
+		log.OnExit(func(msg string) {
+			done(errors.New(msg))
+		})
+		defer done(nil)
+	}
+
 	switch input {
 
 	case listContainers.FullCommand():
-		cli.LxcList(*listName, true, false, false, *listParents)
+		list(*listName, true, false, false, *listParents)
 	case listTemplates.FullCommand():
-		cli.LxcList(*listName, false, true, false, *listParents)
+		list(*listName, false, true, false, *listParents)
 	case listContainersDetails.FullCommand():
-		cli.LxcList(*listName, false, false, true, *listParents)
+		list(*listName, false, false, true, *listParents)
 	case listAll.FullCommand():
-		cli.LxcList(*listName, true, true, false, *listParents)
+		list(*listName, true, true, false, *listParents)
 	case existsCmd.FullCommand():
-		if !container.LxcInstanceExists(*existsCmdName) {
+		exists := container.LxcInstanceExists(*existsCmdName)
+		if *jsonFlag {
+			printJson(map[string]bool{"exists": exists})
+		}
+		if !exists {
 			os.Exit(1)
 		}
 	case daemonCmd.FullCommand():
@@ -394,6 +515,17 @@ func main() {
 		cli.LxcAttach(*attachName, *attachCommand)
 	case cloneCmd.FullCommand():
 		cli.LxcClone(*cloneTemplate, *cloneContainer, *cloneEnvId, *cloneNetwork, *cloneSecret)
+	case applyCmd.FullCommand():
+		changes := cli.Apply(*applyFile, *applyDryRun)
+		if *jsonFlag {
+			printJson(append([]string{}, changes...))
+		} else if len(changes) == 0 {
+			fmt.Println("No changes")
+		} else {
+			for _, change := range changes {
+				fmt.Println(change)
+			}
+		}
 	case restoreCmd.FullCommand():
 		cli.RestoreContainer(*restoreContainer, *restoreEnvId, *restoreNetwork, *restoreSecret)
 	case cleanupCmd.FullCommand():
@@ -407,21 +539,38 @@ func main() {
 	case importCmd.FullCommand():
 		cli.LxcImport(*importName, *importSecret)
 	case infoIdCmd.FullCommand():
-		fmt.Println(cli.GetFingerprint(*infoIdContainer))
+		printValue("id", cli.GetFingerprint(*infoIdContainer))
 	case infoSystemCmd.FullCommand():
-		fmt.Println(cli.GetSystemInfo())
+		printRaw(cli.GetSystemInfo())
 	case infoOsCmd.FullCommand():
-		fmt.Println(cli.GetOsName())
+		printValue("os", cli.GetOsName())
 	case infoIpCmd.FullCommand():
-		fmt.Println(net.GetIp())
+		printValue("ip", net.GetIp())
 	case infoPortsCmd.FullCommand():
+		ports := []string{}
 		for k := range cli.GetUsedPorts() {
-			fmt.Println(k)
+			ports = append(ports, k)
+		}
+		if *jsonFlag {
+			sort.Strings(ports)
+			printJson(ports)
+		} else {
+			for _, k := range ports {
+				fmt.Println(k)
+			}
 		}
 	case infoDUCmd.FullCommand():
-		fmt.Println(cli.GetDiskUsage(*infoDUContainer))
+		printValue("diskUsage", cli.GetDiskUsage(*infoDUContainer))
 	case infoQuotaCmd.FullCommand():
-		fmt.Println(cli.GetContainerQuotaUsage(*infoQuotaContainer))
+		printRaw(cli.GetContainerQuotaUsage(*infoQuotaContainer))
+	case infoPendingCmd.FullCommand():
+		count, err := local.PendingResponses()
+		log.Check(log.ErrorLevel, "Getting pending responses from daemon", err)
+		printValue("pending", count)
+	case infoManagementCmd.FullCommand():
+		host, err := local.ManagementHost()
+		log.Check(log.ErrorLevel, "Getting active Management host from daemon", err)
+		printValue("management", host)
 	case hostnameRh.FullCommand():
 		cli.Hostname(*hostnameRhNewHostname)
 	case hostnameContainer.FullCommand():
@@ -434,8 +583,13 @@ func main() {
 		cli.RemovePortMapping(*mapRemoveProtocol, *mapRemoveDomain, *mapRemoveExternalPort, *mapRemoveInternalServer)
 
 	case mapList.FullCommand():
-		for _, v := range cli.GetPortMappings(*mapListProtocol) {
-			fmt.Println(v)
+		mappings := cli.GetPortMappings(*mapListProtocol)
+		if *jsonFlag {
+			printJson(mappings)
+		} else {
+			for _, v := range mappings {
+				fmt.Printf("%s\t%d\t%s\t%s\n", v.Protocol, v.Port, v.Server, v.Domain)
+			}
 		}
 
 		//prxy command
@@ -449,6 +603,7 @@ func main() {
 		lines := []string{"Tag\tProtocol\tPort\tDomain\tBalancing\tRedirected\tSslBackend\tLE\tHttp2\tApplied"}
 		proxies, err := prxy.GetProxies(*prxyListProtocol)
 		log.Check(log.ErrorLevel, "Getting proxies", err)
+		list := []proxyInfo{}
 		for _, v := range proxies {
 			proxy := v.Proxy
 			if *prxyListTag == "" || *prxyListTag == proxy.Tag {
@@ -456,9 +611,16 @@ func main() {
 				lines = append(lines, fmt.Sprintf("%s\t%s\t%d\t%s\t%s\t%t\t%t\t%t\t%t\t%t",
 					proxy.Tag, proxy.Protocol, proxy.Port, proxy.Domain, proxy.LoadBalancing, proxy.Redirect80Port,
 					proxy.SslBackend, proxy.IsLE(), proxy.Http2, len(servers) > 0))
+				list = append(list, proxyInfo{Tag: proxy.Tag, Protocol: proxy.Protocol, Port: proxy.Port,
+					Domain: proxy.Domain, LoadBalancing: proxy.LoadBalancing, Redirect: proxy.Redirect80Port,
+					SslBackend: proxy.SslBackend, LE: proxy.IsLE(), Http2: proxy.Http2, Applied: len(servers) > 0})
 			}
 		}
-		output(lines)
+		if *jsonFlag {
+			printJson(list)
+		} else {
+			output(lines)
+		}
 
 	case prxyRemoveCmd.FullCommand():
 		log.Check(log.ErrorLevel, "Removing proxy", prxy.RemoveProxy(*prxyRemoveTag))
@@ -473,15 +635,21 @@ func main() {
 		lines := []string{"Protocol\tPort\tDomain\tServer"}
 		proxies, err := prxy.GetProxies("")
 		log.Check(log.ErrorLevel, "Getting proxies", err)
+		list := []cli.PortMapping{}
 		for _, v := range proxies {
 			proxy := v.Proxy
 			if *prxyServerListTag == proxy.Tag {
 				for _, server := range v.Servers {
 					lines = append(lines, fmt.Sprintf("%s\t%d\t%s\t%s", proxy.Protocol, proxy.Port, proxy.Domain, server.Socket))
+					list = append(list, cli.PortMapping{Protocol: proxy.Protocol, Port: proxy.Port, Domain: proxy.Domain, Server: server.Socket})
 				}
 			}
 		}
-		output(lines)
+		if *jsonFlag {
+			printJson(list)
+		} else {
+			output(lines)
+		}
 
 	case snapshotCreateCmd.FullCommand():
 		cli.CreateSnapshot(*snapshotCreateCmdContainer, *snapshotCreateCmdPartition, *snapshotCreateCmdLabel, *snapshotCreateCmdStop)
@@ -490,7 +658,11 @@ func main() {
 		cli.RemoveSnapshot(*snapshotRemoveCmdContainer, *snapshotRemoveCmdPartition, *snapshotRemoveCmdLabel)
 
 	case snapshotListCmd.FullCommand():
-		fmt.Println(cli.ListSnapshots(*snapshotListCmdContainer, *snapshotListCmdPartition))
+		if *jsonFlag {
+			printJson(cli.GetSnapshots(*snapshotListCmdContainer, *snapshotListCmdPartition))
+		} else {
+			fmt.Println(cli.ListSnapshots(*snapshotListCmdContainer, *snapshotListCmdPartition))
+		}
 
 	case snapshotRollbackCmd.FullCommand():
 		cli.RollbackToSnapshot(*snapshotRollBackCmdContainer, *snapshotRollbackCmdPartition, *snapshotRollbackCmdLabel, *snapshotRollbackCmdForce, *snapshotRollbackCmdStop)
@@ -512,31 +684,113 @@ func main() {
 	case fileDecryptCmd.FullCommand():
 		cli.DecryptFile(*fileDecryptCmdSourcePath, *fileDecryptCmdTargetPath, *fileDecryptCmdPassword)
 
+	case keysRotateCmd.FullCommand():
+		cli.RotateKey(*keysRotateCmdTarget, *keysRotateCmdGrace, *keysRotateCmdToken)
+	case keysUnpinCmd.FullCommand():
+		cli.UnpinConsoleCert(*keysUnpinCmdHost)
+
+	case secretSetCmd.FullCommand():
+		cli.SecretSet(*secretSetCmdName, *secretSetCmdValue)
+	case secretGetCmd.FullCommand():
+		printValue("value", cli.SecretGet(*secretGetCmdName))
+	case secretListCmd.FullCommand():
+		if *jsonFlag {
+			printJson(cli.SecretList())
+		} else {
+			for _, s := range cli.SecretList() {
+				fmt.Println(s.Name)
+			}
+		}
+	case secretRemoveCmd.FullCommand():
+		cli.SecretRemove(*secretRemoveCmdName)
+
+	case auditListCmd.FullCommand():
+		entries := cli.AuditList(*auditListCmdSince, *auditListCmdContainer)
+		if *jsonFlag {
+			printJson(entries)
+		} else {
+			lines := []string{"TIME\tWHO\tWHAT\tCONTAINER\tOUTCOME\tDURATION"}
+			for _, e := range entries {
+				lines = append(lines, e.Time.Format("2006-01-02 15:04:05")+"\t"+e.Who+"\t"+strings.Join(append([]string{e.What}, e.Args...), " ")+
+					"\t"+e.Container+"\t"+e.Outcome+"\t"+strconv.FormatInt(e.Duration, 10)+"ms")
+			}
+			output(lines)
+		}
+
 	case metricsCmd.FullCommand():
-		fmt.Println(cli.GetHostMetrics(*metricsHost, *metricsStart, *metricsEnd))
+		printJson(cli.GetHostMetrics(*metricsHost, *metricsStart, *metricsEnd))
 
 	case quotaGetCmd.FullCommand():
-		cli.LxcQuota(*quotaGetContainer, *quotaGetResource, "", "")
+		printJson(cli.LxcQuota(*quotaGetContainer, *quotaGetResource, "", ""))
 	case quotaSetCmd.FullCommand():
-		cli.LxcQuota(*quotaSetContainer, *quotaSetResource, *quotaSetLimit, "")
+		printJson(cli.LxcQuota(*quotaSetContainer, *quotaSetResource, *quotaSetLimit, ""))
 	case startCmd.FullCommand():
 		cli.LxcStart(*startCmdContainer...)
 	case stopCmd.FullCommand():
-		cli.LxcStop(*stopCmdContainer...)
+		cli.LxcStopTimeout(time.Duration(*stopCmdTimeout)*time.Second, *stopCmdForce, *stopCmdContainer...)
 	case restartCmd.FullCommand():
 		cli.LxcRestart(*restartCmdContainer...)
+	case configShowCmd.FullCommand():
+		settings := cli.GetContainerSettings(*configShowCmdName)
+		if *jsonFlag {
+			printJson(settings)
+		} else {
+			lines := []string{
+				"Depends on:\t" + strings.Join(settings.DependsOn, ","),
+				"Priority:\t" + strconv.Itoa(settings.Priority),
+				"Readiness probe:\t" + settings.ReadinessProbe,
+				"Restart policy:\t" + settings.RestartPolicy,
+			}
+			if settings.HealthCheck != "" {
+				lines = append(lines,
+					"Health check:\t"+settings.HealthCheck,
+					"Health check interval:\t"+settings.HealthInterval.String(),
+					"Health check retries:\t"+strconv.Itoa(settings.HealthRetries),
+					"Health:\t"+settings.Health+" "+settings.HealthOutput,
+				)
+			}
+			output(lines)
+		}
+	case configSetCmd.FullCommand():
+		cli.SetContainerSetting(*configSetCmdName, *configSetCmdKey, *configSetCmdValue)
+	case restartPolicyCmd.FullCommand():
+		if *restartPolicyCmdPolicy != "" {
+			cli.SetRestartPolicy(*restartPolicyCmdContainer, *restartPolicyCmdPolicy)
+			break
+		}
+		info := cli.GetRestartPolicy(*restartPolicyCmdContainer)
+		if *jsonFlag {
+			printJson(info)
+		} else {
+			lines := []string{"Policy:\t" + info.Policy, "Restarts:\t" + strconv.Itoa(info.Restarts), "Failed:\t" + strconv.FormatBool(info.Failed)}
+			if info.LastRestart != nil {
+				lines = append(lines, "Last restart:\t"+info.LastRestart.Format("2006-01-02 15:04:05"))
+			}
+			if info.LastFailure != "" {
+				lines = append(lines, "Last failure:\t"+info.LastFailure)
+			}
+			output(lines)
+		}
 	case updateCmd.FullCommand():
 		cli.Update(*updateCmdComponent, *updateCheck)
 	case tunnelAddCmd.FullCommand():
-		cli.AddSshTunnel(*tunneAddSocket, *tunnelAddTimeout, *tunnelAddHumanFriendly)
+		printValue("tunnel", cli.AddSshTunnel(*tunneAddSocket, *tunnelAddTimeout, *tunnelAddHumanFriendly))
 	case tunnelDelCmd.FullCommand():
 		cli.DelSshTunnel(*tunnelDelSocket)
 	case tunnelCheckCmd.FullCommand():
 		cli.CheckSshTunnels()
 	case tunnelListCmd.FullCommand():
-		for _, tunnel := range cli.GetSshTunnels() {
-			fmt.Printf("%s\t%s\t%d\n",
-				tunnel.RemoteSocket, tunnel.LocalSocket, tunnel.Ttl)
+		if *jsonFlag {
+			list := []tunnelInfo{}
+			for _, tunnel := range cli.GetSshTunnels() {
+				list = append(list, tunnelInfo{RemoteSocket: tunnel.RemoteSocket, LocalSocket: tunnel.LocalSocket, Ttl: tunnel.Ttl})
+			}
+			printJson(list)
+		} else {
+			for _, tunnel := range cli.GetSshTunnels() {
+				fmt.Printf("%s\t%s\t%d\n",
+					tunnel.RemoteSocket, tunnel.LocalSocket, tunnel.Ttl)
+			}
 		}
 
 	case vxlanAddCmd.FullCommand():
@@ -544,14 +798,360 @@ func main() {
 	case vxlanDelCmd.FullCommand():
 		cli.DelVxlanTunnel(*vxlanDelName)
 	case vxlanListCmd.FullCommand():
-		for _, tun := range cli.GetVxlanTunnels() {
-			fmt.Println(tun.Name, tun.RemoteIp, tun.Vlan, tun.Vni)
+		if *jsonFlag {
+			printJson(cli.GetVxlanTunnels())
+		} else {
+			for _, tun := range cli.GetVxlanTunnels() {
+				fmt.Println(tun.Name, tun.RemoteIp, tun.Vlan, tun.Vni)
+			}
 		}
 
 	case batchCmd.FullCommand():
-		cli.Batch(*batchJson)
+		printJson(cli.Batch(*batchJson))
 	}
 
+	//action commands report success
+	if *jsonFlag && !printed {
+		printJson(map[string]string{"status": "ok"})
+	}
+}
+
+type proxyInfo struct {
+	Tag           string `json:"tag"`
+	Protocol      string `json:"protocol"`
+	Port          int    `json:"port"`
+	Domain        string `json:"domain"`
+	LoadBalancing string `json:"balancing"`
+	Redirect      bool   `json:"redirect"`
+	SslBackend    bool   `json:"sslBackend"`
+	LE            bool   `json:"le"`
+	Http2         bool   `json:"http2"`
+	Applied       bool   `json:"applied"`
+}
+
+type tunnelInfo struct {
+	RemoteSocket string `json:"remoteSocket"`
+	LocalSocket  string `json:"localSocket"`
+	Ttl          int    `json:"ttl"`
+}
+
+//prints containers/templates list as table or JSON document
+func list(name string, c, t, i, p bool) {
+	if *jsonFlag {
+		printJson(cli.GetInstances(name, c, t, i, p))
+	} else {
+		cli.LxcList(name, c, t, i, p)
+	}
+}
+
+//prints value to standard output, wrapped into JSON object with passed key if --json flag is set
+func printValue(key string, value interface{}) {
+	if *jsonFlag {
+		printJson(map[string]interface{}{key: value})
+	} else {
+		fmt.Println(value)
+	}
+}
+
+//prints document which is already in JSON format
+func printRaw(document string) {
+	if document == "" {
+		log.Error("Failed to collect output")
+	}
+	if *jsonFlag {
+		printJson(json.RawMessage(document))
+	} else {
+		fmt.Println(document)
+	}
+}
+
+//prints value as JSON document
+func printJson(value interface{}) {
+	out, err := json.Marshal(value)
+	log.Check(log.ErrorLevel, "Marshalling output", err)
+
+	fmt.Fprintln(stdout, string(out))
+	printed = true
+}
+
+//returns container or template affected by privileged command, false if command is not audited
+func auditTarget(input string) (string, bool) {
+	switch input {
+	case cloneCmd.FullCommand():
+		return *cloneContainer, true
+	case applyCmd.FullCommand():
+		return cli.SpecName(*applyFile), !*applyDryRun
+	case restoreCmd.FullCommand():
+		return *restoreContainer, true
+	case destroyCmd.FullCommand():
+		return strings.Join(*destroyName, ","), true
+	case exportCmd.FullCommand():
+		return *exportContainer, true
+	case importCmd.FullCommand():
+		return *importName, true
+	case attachCmd.FullCommand():
+		return *attachName, true
+	case hostnameContainer.FullCommand():
+		return *hostnameContainerName, true
+	case quotaSetCmd.FullCommand():
+		return *quotaSetContainer, true
+	case startCmd.FullCommand():
+		return strings.Join(*startCmdContainer, ","), true
+	case stopCmd.FullCommand():
+		return strings.Join(*stopCmdContainer, ","), true
+	case restartCmd.FullCommand():
+		return strings.Join(*restartCmdContainer, ","), true
+	case restartPolicyCmd.FullCommand():
+		return *restartPolicyCmdContainer, *restartPolicyCmdPolicy != ""
+	case configSetCmd.FullCommand():
+		return *configSetCmdName, true
+	case snapshotCreateCmd.FullCommand():
+		return *snapshotCreateCmdContainer, true
+	case snapshotRemoveCmd.FullCommand():
+		return *snapshotRemoveCmdContainer, true
+	case snapshotRollbackCmd.FullCommand():
+		return *snapshotRollBackCmdContainer, true
+	case snapshotReceiveCmd.FullCommand():
+		return *snapshotReceiveCmdContainer, true
+	case keysRotateCmd.FullCommand():
+		return *keysRotateCmdTarget, true
+	case keysUnpinCmd.FullCommand():
+		return *keysUnpinCmdHost, true
+	case cleanupCmd.FullCommand(), pruneCmd.FullCommand(), hostnameRh.FullCommand(),
+		mapAddCmd.FullCommand(), mapRemoveCmd.FullCommand(),
+		prxyCreateCmd.FullCommand(), prxyRemoveCmd.FullCommand(), prxyServerAddCmd.FullCommand(), prxyServerRemoveCmd.FullCommand(),
+		tunnelAddCmd.FullCommand(), tunnelDelCmd.FullCommand(), vxlanAddCmd.FullCommand(), vxlanDelCmd.FullCommand(),
+		updateCmd.FullCommand(), secretSetCmd.FullCommand(), secretGetCmd.FullCommand(), secretRemoveCmd.FullCommand():
+		return "", true
+	}
+
+	return "", false
+}
+
+//returns command line arguments with secrets and tokens masked
+//returns copy of command line with values of flags and arguments holding secrets replaced by ***,
+//values are found by position, command line which can not be parsed is masked entirely
+func maskArgs(args []string) []string {
+	ctx, err := app.ParseContext(args)
+	if err != nil || ctx.SelectedCommand == nil {
+		return audit.MaskAll(args)
+	}
+
+	//names of flags and arguments holding secrets
+	secrets := map[string][]string{
+		cloneCmd.FullCommand():       {"secret"},
+		restoreCmd.FullCommand():     {"secret"},
+		exportCmd.FullCommand():      {"token"},
+		importCmd.FullCommand():      {"secret"},
+		cdnUploadCmd.FullCommand():   {"token"},
+		keysRotateCmd.FullCommand():  {"token"},
+		secretSetCmd.FullCommand():   {"value"},
+		fileEncryptCmd.FullCommand(): {"password"},
+		fileDecryptCmd.FullCommand(): {"password"},
+	}[ctx.SelectedCommand.FullCommand()]
+	secret := func(name string) bool {
+		for _, s := range secrets {
+			if s == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	//flags of application and matched commands, positional tokens are command names followed by command arguments
+	flags := app.Model().Flags
+	var positional []string
+	for _, element := range ctx.Elements {
+		if cmd, ok := element.Clause.(*kingpin.CmdClause); ok {
+			flags = append(flags, cmd.Model().Flags...)
+			positional = append(positional, "")
+		}
+	}
+	for _, arg := range ctx.SelectedCommand.Model().Args {
+		positional = append(positional, arg.Name)
+	}
+	long := make(map[string]*kingpin.FlagModel)
+	short := make(map[byte]*kingpin.FlagModel)
+	for _, flag := range flags {
+		long[flag.Name] = flag
+		if flag.Short != 0 {
+			short[byte(flag.Short)] = flag
+		}
+	}
+
+	masked := append([]string{}, args...)
+	n := 0
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			//rest is positional
+			for i++; i < len(args); i, n = i+1, n+1 {
+				if n < len(positional) && secret(positional[n]) {
+					masked[i] = "***"
+				}
+			}
+		case strings.HasPrefix(arg, "--"):
+			name := strings.SplitN(arg[2:], "=", 2)[0]
+			flag, ok := long[name]
+			if !ok || flag.IsBoolFlag() {
+				continue
+			}
+			if strings.Contains(arg, "=") {
+				if secret(flag.Name) {
+					masked[i] = "--" + name + "=***"
+				}
+			} else if i++; i < len(args) && secret(flag.Name) {
+				masked[i] = "***"
+			}
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			//short flags may be grouped, value follows flag in the same token or in the next one
+			for j := 1; j < len(arg); j++ {
+				flag, ok := short[arg[j]]
+				if !ok || flag.IsBoolFlag() {
+					continue
+				}
+				if j+1 < len(arg) {
+					if secret(flag.Name) {
+						masked[i] = arg[:j+1] + "***"
+					}
+				} else if i++; i < len(args) && secret(flag.Name) {
+					masked[i] = "***"
+				}
+				break
+			}
+		default:
+			if n < len(positional) && secret(positional[n]) {
+				masked[i] = "***"
+			}
+			n++
+		}
+	}
+
+	return masked
+}
+
+//returns resources daemon locks while it executes command line, e.g. containers named by arguments or flags,
+//and whether command only reads state; command without targets runs exclusively
+func lockTargets(args []string) (targets []string, readOnly bool, err error) {
+	ctx, err := app.ParseContext(args)
+	if err != nil {
+		return nil, false, err
+	}
+	if ctx.SelectedCommand == nil {
+		return nil, false, errors.New("No command")
+	}
+
+	//parsed values of flags and arguments by name, repeated arguments have several values
+	values := make(map[string][]string)
+	for _, element := range ctx.Elements {
+		if element.Value == nil {
+			continue
+		}
+		switch clause := element.Clause.(type) {
+		case *kingpin.ArgClause:
+			values[clause.Model().Name] = append(values[clause.Model().Name], *element.Value)
+		case *kingpin.FlagClause:
+			values[clause.Model().Name] = append(values[clause.Model().Name], *element.Value)
+		}
+	}
+	//containers and templates share names
+	containers := func(names ...string) []string {
+		var result []string
+		for _, name := range names {
+			for _, value := range values[name] {
+				result = append(result, "container:"+value)
+			}
+		}
+		return result
+	}
+
+	switch ctx.SelectedCommand.FullCommand() {
+	case listContainers.FullCommand(), listTemplates.FullCommand(), listAll.FullCommand(), listContainersDetails.FullCommand(),
+		existsCmd.FullCommand(), metricsCmd.FullCommand(), auditListCmd.FullCommand(),
+		infoIdCmd.FullCommand(), infoSystemCmd.FullCommand(), infoOsCmd.FullCommand(), infoIpCmd.FullCommand(),
+		infoPortsCmd.FullCommand(), infoDUCmd.FullCommand(), infoQuotaCmd.FullCommand(), infoPendingCmd.FullCommand(),
+		infoManagementCmd.FullCommand(), mapList.FullCommand(), prxyListCmd.FullCommand(), prxyServerListCmd.FullCommand(),
+		quotaGetCmd.FullCommand(), snapshotListCmd.FullCommand(), configShowCmd.FullCommand(),
+		secretGetCmd.FullCommand(), secretListCmd.FullCommand(), tunnelListCmd.FullCommand(), vxlanListCmd.FullCommand():
+		return nil, true, nil
+	case restartPolicyCmd.FullCommand():
+		return containers("container"), len(values["policy"]) == 0, nil
+	case cloneCmd.FullCommand():
+		return containers("template", "container"), false, nil
+	case applyCmd.FullCommand():
+		//spec changes proxies of container too
+		for _, file := range values["file"] {
+			if name := cli.SpecName(file); name != "" {
+				targets = append(targets, "container:"+name, "proxy")
+			}
+		}
+		return targets, false, nil
+	case restoreCmd.FullCommand(), exportCmd.FullCommand(), quotaSetCmd.FullCommand(), configSetCmd.FullCommand(),
+		hostnameContainer.FullCommand(), snapshotCreateCmd.FullCommand(), snapshotRemoveCmd.FullCommand(),
+		snapshotRollbackCmd.FullCommand(), snapshotSendCmd.FullCommand(), snapshotReceiveCmd.FullCommand():
+		return containers("container"), false, nil
+	case importCmd.FullCommand():
+		return containers("template"), false, nil
+	case destroyCmd.FullCommand(), startCmd.FullCommand(), stopCmd.FullCommand(), restartCmd.FullCommand():
+		return containers("name", "name(s)"), false, nil
+	case keysRotateCmd.FullCommand():
+		return containers("target"), false, nil
+	case mapAddCmd.FullCommand(), mapRemoveCmd.FullCommand(), prxyCreateCmd.FullCommand(), prxyRemoveCmd.FullCommand(),
+		prxyServerAddCmd.FullCommand(), prxyServerRemoveCmd.FullCommand():
+		//port mappings and proxies share web server configuration
+		return []string{"proxy"}, false, nil
+	case secretSetCmd.FullCommand(), secretRemoveCmd.FullCommand():
+		return []string{"secrets"}, false, nil
+	case keysUnpinCmd.FullCommand():
+		return []string{"console-cert"}, false, nil
+	case tunnelAddCmd.FullCommand(), tunnelDelCmd.FullCommand():
+		return []string{"tunnels"}, false, nil
+	case vxlanAddCmd.FullCommand(), vxlanDelCmd.FullCommand():
+		return []string{"vxlan:" + strings.Join(values["name"], ",")}, false, nil
+	case cdnDownloadCmd.FullCommand():
+		return []string{"cdn:" + strings.Join(values["id"], ",")}, false, nil
+	case cdnUploadCmd.FullCommand(), fileEncryptCmd.FullCommand(), fileDecryptCmd.FullCommand():
+		return []string{"file:" + strings.Join(append(values["file"], values["source"]...), ",")}, false, nil
+	}
+
+	return nil, false, nil
+}
+
+//executes command through local API of daemon and exits with its exit code
+func forward(input string) {
+	switch input {
+	case daemonCmd.FullCommand(), attachCmd.FullCommand():
+		log.Error("Command " + input + " can not be executed through daemon")
+	}
+
+	if !local.Available() {
+		log.Error("Subutai daemon is not available")
+	}
+
+	//global flags, which are all boolean, may precede command
+	action := ""
+	var args []string
+	for _, arg := range os.Args[1:] {
+		if action == "" && !strings.HasPrefix(arg, "-") {
+			action = arg
+		} else if arg != "--via-daemon" {
+			args = append(args, arg)
+		}
+	}
+
+	result, err := local.Command(action, args...)
+	log.Check(log.ErrorLevel, "Executing command through daemon", err)
+
+	//with --json os.Stdout is redirected to stderr, JSON document goes to saved stdout
+	fmt.Fprint(stdout, result.Stdout)
+	fmt.Fprint(os.Stderr, result.Stderr)
+
+	exitCode, err := strconv.Atoi(result.ExitCode)
+	if err != nil {
+		exitCode = 1
+	}
+	os.Exit(exitCode)
 }
 
 func output(lines []string) {
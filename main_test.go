@@ -0,0 +1,45 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLockTargets(t *testing.T) {
+	tests := []struct {
+		args     []string
+		targets  []string
+		readOnly bool
+	}{
+		{[]string{"clone", "debian-stretch", "foo", "-e", "env1", "-n", "10.10.10.2/24 100"},
+			[]string{"container:debian-stretch", "container:foo"}, false},
+		{[]string{"stop", "--timeout", "50", "foo", "bar"}, []string{"container:foo", "container:bar"}, false},
+		{[]string{"stop", "-t", "50", "-f", "foo"}, []string{"container:foo"}, false},
+		{[]string{"quota", "set", "-c", "foo", "-r", "ram", "100"}, []string{"container:foo"}, false},
+		{[]string{"snapshot", "create", "-c", "foo", "-p", "all", "-l", "daily"}, []string{"container:foo"}, false},
+		{[]string{"snap", "rm", "--container=foo", "-p", "all", "-l", "daily"}, []string{"container:foo"}, false},
+		{[]string{"rm", "foo", "bar"}, []string{"container:foo", "container:bar"}, false},
+		{[]string{"--json", "restart-policy", "foo"}, []string{"container:foo"}, true},
+		{[]string{"restart-policy", "foo", "always"}, []string{"container:foo"}, false},
+		{[]string{"map", "add", "-p", "tcp", "-e", "5000", "-i", "10.10.10.2:22"}, []string{"proxy"}, false},
+		{[]string{"list", "containers", "-n", "foo"}, nil, true},
+		{[]string{"quota", "get", "-c", "foo", "-r", "cpu"}, nil, true},
+		{[]string{"prune"}, nil, false},
+		{[]string{"cleanup", "100"}, nil, false},
+	}
+
+	for _, test := range tests {
+		targets, readOnly, err := lockTargets(test.args)
+		if err != nil {
+			t.Errorf("%v: %v", test.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(targets, test.targets) || readOnly != test.readOnly {
+			t.Errorf("%v: got %v read only %v, want %v read only %v", test.args, targets, readOnly, test.targets, test.readOnly)
+		}
+	}
+
+	if _, _, err := lockTargets([]string{"no-such-command"}); err == nil {
+		t.Error("expected error for unknown command")
+	}
+}
@@ -0,0 +1,28 @@
+// +build mock
+
+// Mock Console for local testing of agent, it is not part of subutai binary.
+// Agent is pointed to it by management host and ports in agent.conf.
+//	go build -tags mock -o mock-console ./tools/mock-console
+package main
+
+import (
+	"os"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/subutai-io/agent/agent/console/mock"
+	"github.com/subutai-io/agent/log"
+)
+
+var (
+	app        = kingpin.New("mock-console", "Mock Subutai Console server for testing")
+	dir        = app.Flag("dir", "mock Console GPG home").Default("/tmp/subutai-mock-console").String()
+	port       = app.Flag("port", "public port").Default("8443").String()
+	securePort = app.Flag("secure-port", "agent port").Default("8444").String()
+)
+
+func main() {
+	kingpin.MustParse(app.Parse(os.Args[1:]))
+
+	log.Check(log.ErrorLevel, "Running mock Console", mock.Serve(*dir, *port, *securePort))
+}